@@ -0,0 +1,16 @@
+package comshim
+
+// WithActivationContext makes the shim's thread activate the side-by-side
+// activation context described by the manifest at manifestPath before it
+// initializes COM, and deactivate it after COM has been uninitialized. Objects
+// created on the thread, for instance through Run or CreateObject, can then
+// come from registration-free COM components declared in the manifest.
+//
+// If the manifest can't be loaded, starting the shim fails with an *InitError.
+// The option has no effect on shims holding an MTA usage cookie, as they don't
+// have a thread of their own.
+func WithActivationContext(manifestPath string) Option {
+	return func(c *config) {
+		c.manifest = manifestPath
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package comshim
+
+// activationContext is an activation context that is active on the calling
+// thread.
+type activationContext struct{}
+
+// activateContext creates an activation context from the manifest at path and
+// activates it on the calling thread.
+func activateContext(path string) (*activationContext, error) {
+	return &activationContext{}, nil
+}
+
+// deactivate deactivates and releases the activation context. It must be
+// called on the thread that activated it.
+func (a *activationContext) deactivate() {}
@@ -0,0 +1,68 @@
+//go:build windows
+
+package comshim
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modkernel32 = windows.NewLazySystemDLL("kernel32.dll")
+
+	procCreateActCtxW    = modkernel32.NewProc("CreateActCtxW")
+	procActivateActCtx   = modkernel32.NewProc("ActivateActCtx")
+	procDeactivateActCtx = modkernel32.NewProc("DeactivateActCtx")
+	procReleaseActCtx    = modkernel32.NewProc("ReleaseActCtx")
+)
+
+// actCtxW is ACTCTXW.
+type actCtxW struct {
+	size                  uint32
+	flags                 uint32
+	source                *uint16
+	processorArchitecture uint16
+	langID                uint16
+	assemblyDirectory     *uint16
+	resourceName          *uint16
+	applicationName       *uint16
+	module                windows.Handle
+}
+
+// activationContext is an activation context that is active on the calling
+// thread.
+type activationContext struct {
+	handle windows.Handle
+	cookie uintptr
+}
+
+// activateContext creates an activation context from the manifest at path and
+// activates it on the calling thread.
+func activateContext(path string) (*activationContext, error) {
+	source, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	ctx := actCtxW{source: source}
+	ctx.size = uint32(unsafe.Sizeof(ctx))
+
+	r, _, err := procCreateActCtxW.Call(uintptr(unsafe.Pointer(&ctx)))
+	if windows.Handle(r) == windows.InvalidHandle {
+		return nil, err
+	}
+	a := &activationContext{handle: windows.Handle(r)}
+
+	if ok, _, err := procActivateActCtx.Call(r, uintptr(unsafe.Pointer(&a.cookie))); ok == 0 {
+		procReleaseActCtx.Call(r)
+		return nil, err
+	}
+	return a, nil
+}
+
+// deactivate deactivates and releases the activation context. It must be
+// called on the thread that activated it.
+func (a *activationContext) deactivate() {
+	procDeactivateActCtx.Call(0, a.cookie)
+	procReleaseActCtx.Call(uintptr(a.handle))
+}
@@ -0,0 +1,80 @@
+package comshim
+
+import (
+	"runtime"
+
+	"github.com/go-ole/go-ole"
+)
+
+// WithAdoptHostApartment lets a multithreaded shim make use of an MTA that the
+// host process has already initialized, as happens when the program is built
+// with -buildmode=c-shared or c-archive and loaded by a COM-aware host. When
+// the shim starts, it checks with CoGetApartmentType whether the calling
+// thread belongs to the implicit MTA. If so, no thread is started and COM isn't
+// initialized; the shim only keeps count, and Run calls its function on the
+// calling goroutine, with its OS thread locked, as it does for NewMTAUsage.
+// Otherwise the shim starts as usual.
+//
+// The host is responsible for keeping its MTA alive. While a shim of this
+// process holds an MTA usage cookie (see WithMTAUsageCookie), the MTA isn't
+// adopted, as it might not be the host's. WithSecurity has no
+// effect on an adopted apartment, as the host has settled the process-wide
+// security already. Init and teardown hooks are called as for NewMTAUsage.
+func WithAdoptHostApartment() Option {
+	return func(c *config) {
+		c.adoptHost = true
+	}
+}
+
+// hostApartment reports whether the shim should adopt the MTA of its host.
+func (s *Shim) hostApartment() bool {
+	if !s.settings().adoptHost || s.settings().coinit&ole.COINIT_APARTMENTTHREADED != 0 {
+		return false
+	}
+	if mtaCookies.Load() > 0 {
+		return false // The MTA may only be alive thanks to another shim
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	return hostMTA()
+}
+
+// startAdopted takes the place of the shim's goroutine when the shim adopts
+// the MTA of its host.
+func (s *Shim) startAdopted() error {
+	defer s.end()
+
+	if len(s.settings().initHooks) > 0 || s.settings().namedEvent != "" {
+		runtime.LockOSThread()
+		err := s.runInitHooks()
+		runtime.UnlockOSThread()
+		if err != nil {
+			return err
+		}
+	}
+
+	s.signalAccess.Lock()
+	s.adopted = true
+	s.setRunning(true)
+	if !s.held() || s.closed.Load() {
+		// Every reference was dropped, or the shim was closed, in the
+		// meantime
+		s.releaseAdopted()
+	}
+	s.signalAccess.Unlock()
+
+	s.log().Debug("comshim: adopted the host's MTA")
+	return nil
+}
+
+// releaseAdopted stops using the MTA of the host. It must be called while
+// holding signalAccess.
+func (s *Shim) releaseAdopted() {
+	s.setRunning(false)
+	s.teardownMTAUsage()
+	s.reportUnreleased()
+	s.releaseTracked()
+	s.revokeGIT()
+	s.adopted = false
+	s.emit(EventUninitialized, nil)
+}
@@ -0,0 +1,78 @@
+package comshim
+
+import (
+	"sync"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+)
+
+// SinkFunc receives the events delivered to a sink created by Advise. dispID
+// identifies the event within the source interface and args holds its
+// arguments in order, converted with ole.VARIANT.Value. Objects among the
+// arguments are only valid until the function returns.
+type SinkFunc func(dispID int32, args []interface{})
+
+// Advise subscribes sink to the events that source raises through the
+// outgoing interface iid, by way of source's IConnectionPointContainer. The
+// subscription is made on the shim's thread, and the shim holds a reference
+// until it is cancelled by calling unadvise.
+//
+// sink is either an *ole.IUnknown implementing iid, or a SinkFunc, which is
+// wrapped in an IDispatch implementation suitable for dispinterfaces. In a
+// single-threaded apartment, events for a SinkFunc arrive on the shim's
+// thread; in the multithreaded apartment they may arrive on any thread.
+func (s *Shim) Advise(source *ole.IUnknown, iid *ole.GUID, sink interface{}) (unadvise func(), err error) {
+	var unknown *ole.IUnknown
+	switch sink := sink.(type) {
+	case *ole.IUnknown:
+		unknown = sink
+	case SinkFunc:
+		if unknown, err = newDispatchSink(s, iid, sink); err != nil {
+			return nil, err
+		}
+		defer unknown.Release() // The connection point holds its own reference
+	case func(int32, []interface{}):
+		return s.Advise(source, iid, SinkFunc(sink))
+	default:
+		return nil, ole.NewError(ole.E_INVALIDARG)
+	}
+
+	if err := s.hold(1); err != nil {
+		return nil, err
+	}
+	var point *ole.IConnectionPoint
+	var cookie uint32
+	err = s.Run(func() error {
+		container, err := source.QueryInterface(ole.IID_IConnectionPointContainer)
+		if err != nil {
+			return err
+		}
+		defer container.Release()
+
+		cpc := (*ole.IConnectionPointContainer)(unsafe.Pointer(container))
+		if err := cpc.FindConnectionPoint(iid, &point); err != nil {
+			return err
+		}
+		if cookie, err = point.Advise(unknown); err != nil {
+			point.Release()
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		s.done(nil)
+		return nil, err
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.Run(func() error {
+				defer point.Release()
+				return point.Unadvise(cookie)
+			})
+			s.done(nil)
+		})
+	}, nil
+}
@@ -0,0 +1,33 @@
+package comshim
+
+// WithStrictAffinity makes the shim check, before running each function passed
+// to Run and the other methods that queue functions, that it is about to run
+// on the thread hosting the apartment. A function that would run anywhere else
+// isn't run, and ErrWrongThread is returned in its place. This guards against
+// bugs in the shim's dispatching, which would otherwise let objects with thread
+// affinity be used from the wrong thread, and is mostly useful during
+// development. It is off by default.
+//
+// Thread identifiers are only available on Windows, so elsewhere the check
+// always passes. Shims holding an MTA usage cookie have no thread of their
+// own, and run functions without checking.
+func WithStrictAffinity() Option {
+	return func(c *config) {
+		c.strictAffinity = true
+	}
+}
+
+// misrouted reports whether the calling thread isn't the one hosting the
+// apartment, if WithStrictAffinity asked for the check. It must be called by
+// the shim's goroutine while holding signalAccess.
+func (s *Shim) misrouted() bool {
+	if !s.settings().strictAffinity {
+		return false
+	}
+	id, want := currentThreadID(), s.threadID.Load()
+	if id == want {
+		return false
+	}
+	s.log().Error("comshim: function dispatched to the wrong thread", "thread", id, "want", want)
+	return true
+}
@@ -0,0 +1,35 @@
+package comshim
+
+import "testing"
+
+func TestWithStrictAffinity(t *testing.T) {
+	s := New(WithStrictAffinity())
+	defer s.WaitDone()
+	s.Add(1)
+	defer s.Done()
+
+	if err := s.Run(func() error { return nil }); err != nil {
+		t.Fatalf("Run on the shim's thread returned %v", err)
+	}
+
+	// Pretend the apartment lives on another thread, so that the next
+	// function is dispatched to the wrong one
+	s.signalAccess.Lock()
+	id := s.threadID.Load()
+	s.threadID.Store(id + 1)
+	s.signalAccess.Unlock()
+	ran := false
+	if err := s.Run(func() error { ran = true; return nil }); err != ErrWrongThread {
+		t.Errorf("misrouted Run returned %v, want %v", err, ErrWrongThread)
+	}
+	if ran {
+		t.Error("misrouted function was run")
+	}
+
+	s.signalAccess.Lock()
+	s.threadID.Store(id)
+	s.signalAccess.Unlock()
+	if err := s.Run(func() error { return nil }); err != nil {
+		t.Errorf("Run once back on the shim's thread returned %v", err)
+	}
+}
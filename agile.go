@@ -0,0 +1,53 @@
+package comshim
+
+import "github.com/go-ole/go-ole"
+
+// AgileRef is an agile reference to a COM interface, created with
+// RoGetAgileReference. Unlike the interface itself, it may be used from any
+// apartment, which makes it a lighter alternative to the Global Interface
+// Table for handing an interface to other goroutines. It requires Windows 8.1
+// or later.
+type AgileRef struct {
+	shim *Shim
+	ref  *ole.IUnknown // The IAgileReference
+}
+
+// AgileRef creates an agile reference to unknown. Like RegisterInterface, it
+// runs on the shim's thread, which should be the thread that obtained
+// unknown. The reference keeps unknown alive until Release is called.
+func (s *Shim) AgileRef(unknown *ole.IUnknown) (*AgileRef, error) {
+	var ref *ole.IUnknown
+	err := s.Run(func() (err error) {
+		ref, err = roGetAgileReference(unknown)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &AgileRef{shim: s, ref: ref}, nil
+}
+
+// Resolve returns a pointer to the referenced interface that is valid in the
+// apartment of the calling thread, marshaling it if necessary. The caller must
+// release it when done.
+//
+// Like GetInterface, Resolve runs on the calling goroutine, and the result
+// belongs to the multithreaded apartment unless the caller has locked its
+// goroutine to a thread with an apartment of its own.
+func (r *AgileRef) Resolve() (*ole.IUnknown, error) {
+	var unknown *ole.IUnknown
+	err := r.shim.With(func() (err error) {
+		unknown, err = agileResolve(r.ref)
+		return err
+	})
+	return unknown, err
+}
+
+// Release releases the agile reference, and with it the reference to the
+// interface that it kept alive. The AgileRef must not be used afterwards.
+func (r *AgileRef) Release() error {
+	return r.shim.With(func() error {
+		r.ref.Release()
+		return nil
+	})
+}
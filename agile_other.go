@@ -0,0 +1,19 @@
+//go:build !windows
+
+package comshim
+
+import "github.com/go-ole/go-ole"
+
+// agileReferencesSupported reports whether the operating system provides
+// RoGetAgileReference.
+func agileReferencesSupported() bool {
+	return false
+}
+
+func roGetAgileReference(unknown *ole.IUnknown) (*ole.IUnknown, error) {
+	return nil, ole.NewError(ole.E_NOTIMPL)
+}
+
+func agileResolve(ref *ole.IUnknown) (*ole.IUnknown, error) {
+	return nil, ole.NewError(ole.E_NOTIMPL)
+}
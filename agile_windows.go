@@ -0,0 +1,53 @@
+//go:build windows
+
+package comshim
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+)
+
+var procRoGetAgileReference = modole32.NewProc("RoGetAgileReference")
+
+const agileReferenceDefault = 0 // AGILEREFERENCE_DEFAULT
+
+type iAgileReferenceVtbl struct {
+	ole.IUnknownVtbl
+	Resolve uintptr
+}
+
+// agileReferencesSupported reports whether the operating system provides
+// RoGetAgileReference.
+func agileReferencesSupported() bool {
+	return procRoGetAgileReference.Find() == nil
+}
+
+func roGetAgileReference(unknown *ole.IUnknown) (ref *ole.IUnknown, err error) {
+	if err := procRoGetAgileReference.Find(); err != nil {
+		return nil, err
+	}
+	hr, _, _ := procRoGetAgileReference.Call(
+		agileReferenceDefault,
+		uintptr(unsafe.Pointer(ole.IID_IUnknown)),
+		uintptr(unsafe.Pointer(unknown)),
+		uintptr(unsafe.Pointer(&ref)))
+	if err := hresultError(hr); err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
+
+func agileResolve(ref *ole.IUnknown) (unknown *ole.IUnknown, err error) {
+	vtbl := (*iAgileReferenceVtbl)(unsafe.Pointer(ref.RawVTable))
+	hr, _, _ := syscall.SyscallN(
+		vtbl.Resolve,
+		uintptr(unsafe.Pointer(ref)),
+		uintptr(unsafe.Pointer(ole.IID_IUnknown)),
+		uintptr(unsafe.Pointer(&unknown)))
+	if err := hresultError(hr); err != nil {
+		return nil, err
+	}
+	return unknown, nil
+}
@@ -0,0 +1,100 @@
+package comshim
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-ole/go-ole"
+)
+
+// ApartmentType is the kind of apartment a thread belongs to, as reported by
+// CoGetApartmentType.
+type ApartmentType int
+
+// The apartment types reported by CoGetApartmentType.
+const (
+	ApartmentSTA     ApartmentType = 0 // A single-threaded apartment
+	ApartmentMTA     ApartmentType = 1 // The multithreaded apartment
+	ApartmentNA      ApartmentType = 2 // The neutral apartment
+	ApartmentMainSTA ApartmentType = 3 // The main single-threaded apartment
+)
+
+// String returns the name of the apartment type.
+func (t ApartmentType) String() string {
+	switch t {
+	case ApartmentSTA:
+		return "STA"
+	case ApartmentMTA:
+		return "MTA"
+	case ApartmentNA:
+		return "NA"
+	case ApartmentMainSTA:
+		return "main STA"
+	default:
+		return fmt.Sprintf("ApartmentType(%d)", int(t))
+	}
+}
+
+// VerifyApartment asks COM which apartment the shim's thread belongs to and
+// returns it, along with an error wrapping ErrWrongApartment if it isn't the
+// one the shim was configured for. Code that initialized the thread before the
+// shim could, such as a misbehaving DLL, is otherwise hard to notice.
+//
+// The shim already performs this check whenever it starts its thread, and
+// fails the start on a mismatch.
+func (s *Shim) VerifyApartment() (ApartmentType, error) {
+	var apt ApartmentType
+	err := s.Run(func() (err error) {
+		apt, err = s.checkApartment()
+		return err
+	})
+	return apt, err
+}
+
+// checkApartment returns the apartment of the calling thread, and an error if
+// it isn't the one the shim is configured for.
+func (s *Shim) checkApartment() (ApartmentType, error) {
+	getApartmentType := coGetApartmentType
+	if r, ok := s.settings().initializer.(ApartmentReporter); ok {
+		getApartmentType = r.ApartmentType
+	}
+	apt, err := getApartmentType()
+	if err != nil {
+		return apt, err
+	}
+	want := ApartmentMTA
+	if s.settings().coinit&ole.COINIT_APARTMENTTHREADED != 0 {
+		want = ApartmentSTA
+	}
+	if apt != want && !(want == ApartmentSTA && apt == ApartmentMainSTA) {
+		return apt, fmt.Errorf("%w: want %v, got %v", ErrWrongApartment, want, apt)
+	}
+	return apt, nil
+}
+
+// AddStrict adds delta to the counter like TryAdd, then makes sure that the
+// shim's thread is in the apartment the shim was configured for, as
+// VerifyApartment does. On a mismatch, for example because other code
+// initialized the thread for the other concurrency model first, delta is
+// taken back off the counter and an error wrapping ErrApartmentMismatch is
+// returned. Any other failure to tell the apartment is returned likewise.
+//
+// The check costs a call on the shim's thread, which is why Add and TryAdd
+// don't make it.
+func (s *Shim) AddStrict(delta int) error {
+	if err := s.TryAdd(delta); err != nil {
+		return err
+	}
+	apt, err := s.VerifyApartment()
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, ErrWrongApartment) {
+		err = fmt.Errorf("%w: %v", ErrApartmentMismatch, err)
+	}
+	s.log().Error("comshim: apartment check failed", "apartment", apt, "error", err)
+	if derr := s.DoneN(delta); derr != nil {
+		return derr
+	}
+	return err
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package comshim
+
+import "github.com/go-ole/go-ole"
+
+// coGetApartmentType returns the apartment of the calling thread.
+func coGetApartmentType() (ApartmentType, error) {
+	return 0, ole.NewError(ole.E_NOTIMPL)
+}
+
+// hostMTA reports whether the calling thread, which hasn't initialized COM
+// itself, belongs to the implicit MTA because other code in the process has
+// initialized the MTA.
+func hostMTA() bool {
+	return false
+}
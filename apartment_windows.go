@@ -0,0 +1,33 @@
+//go:build windows
+
+package comshim
+
+import "unsafe"
+
+// aptTypeQualifierImplicitMTA is APTTYPEQUALIFIER_IMPLICIT_MTA.
+const aptTypeQualifierImplicitMTA = 1
+
+var procCoGetApartmentType = modole32.NewProc("CoGetApartmentType")
+
+// coGetApartmentType returns the apartment of the calling thread.
+func coGetApartmentType() (ApartmentType, error) {
+	var apt, qualifier int32
+	hr, _, _ := procCoGetApartmentType.Call(
+		uintptr(unsafe.Pointer(&apt)),
+		uintptr(unsafe.Pointer(&qualifier)))
+	if err := hresultError(hr); err != nil {
+		return 0, err
+	}
+	return ApartmentType(apt), nil
+}
+
+// hostMTA reports whether the calling thread, which hasn't initialized COM
+// itself, belongs to the implicit MTA because other code in the process has
+// initialized the MTA.
+func hostMTA() bool {
+	var apt, qualifier int32
+	hr, _, _ := procCoGetApartmentType.Call(
+		uintptr(unsafe.Pointer(&apt)),
+		uintptr(unsafe.Pointer(&qualifier)))
+	return hresultError(hr) == nil && ApartmentType(apt) == ApartmentMTA && qualifier == aptTypeQualifierImplicitMTA
+}
@@ -0,0 +1,34 @@
+package comshim
+
+// CreateObjectAuto creates an instance of the class registered as progID and
+// returns its IDispatch interface, for components whose apartment
+// requirements are unknown or poorly documented. It first tries the
+// multithreaded apartment, and if the activation fails in a way that points
+// at the apartment, RPC_E_WRONG_THREAD or CO_E_NOT_SUPPORTED, it tries again
+// on one of the manager's single-threaded apartments. The apartment that
+// worked is returned along with the object; every other failure is returned
+// as is.
+//
+// The object holds a reference to the shim of its thread until it is closed,
+// and its methods run on that thread. Other code using the object must run
+// there too, through the shim returned by its Shim method. Use
+// ThreadingModelOf and RunFor instead where the class is registered with a
+// threading model.
+func (m *ApartmentManager) CreateObjectAuto(progID string) (*ManagedDispatch, ApartmentType, error) {
+	disp, release, err := m.mta.CreateDispatch(progID)
+	if err == nil {
+		return m.mta.manage(disp, release), ApartmentMTA, nil
+	}
+	if code := hresultCode(err); code != rpcEWrongThread && code != coENotSupported {
+		return nil, ApartmentMTA, err
+	}
+	m.mta.log().Debug("comshim: activation failed in the MTA, trying an STA", "progID", progID, "error", err)
+
+	w := m.sta.claim()
+	defer w.load.Add(-1)
+	disp, release, err = w.shim.CreateDispatch(progID)
+	if err != nil {
+		return nil, ApartmentSTA, err
+	}
+	return w.shim.manage(disp, release), ApartmentSTA, nil
+}
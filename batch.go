@@ -0,0 +1,78 @@
+package comshim
+
+import (
+	"context"
+	"sync"
+)
+
+// RunAll runs fns on the shim's thread, in order, as a single task, which saves
+// a round trip to the thread for each of them. It returns the result of each
+// function at the same index. If fn panics, the panic is recovered and
+// reported as a *PanicError like with Run, and the remaining functions still
+// run.
+//
+// Once ctx is done the remaining functions are skipped. If RunAll stops
+// waiting early, because ctx is done or the shim could not run the batch,
+// every function that hadn't finished by then reports the error that stopped
+// it.
+func (s *Shim) RunAll(ctx context.Context, fns []func() error) []error {
+	var m sync.Mutex
+	errs := make([]error, len(fns))
+	finished := make([]bool, len(fns))
+	returned := false // Set once RunAll has returned, after which errs belongs to the caller
+
+	err := s.RunContext(ctx, func() error {
+		for i, fn := range fns {
+			err := ctx.Err()
+			if err == nil {
+				err = (&task{fn: fn}).call()
+			}
+
+			m.Lock()
+			if returned {
+				m.Unlock()
+				return nil
+			}
+			errs[i] = err
+			finished[i] = true
+			m.Unlock()
+		}
+		return nil
+	})
+
+	m.Lock()
+	defer m.Unlock()
+	returned = true
+	if err != nil {
+		for i := range errs {
+			if !finished[i] {
+				errs[i] = err
+			}
+		}
+	}
+	return errs
+}
+
+// RunResult is like Run, but fn returns a value, which is passed back to the
+// caller along with the error. This saves smuggling results out of fn through
+// variables captured by the closure.
+//
+// If fn doesn't run, or panics, the zero value is returned with the error.
+func RunResult[T any](s *Shim, fn func() (T, error)) (T, error) {
+	return RunResultContext(context.Background(), s, fn)
+}
+
+// RunResultContext is like RunResult, but stops waiting and returns ctx.Err()
+// if ctx is done before fn has finished, like RunContext.
+func RunResultContext[T any](ctx context.Context, s *Shim, fn func() (T, error)) (T, error) {
+	var result T
+	err := s.RunContext(ctx, func() (err error) {
+		result, err = fn()
+		return err
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return result, nil
+}
@@ -0,0 +1,59 @@
+package comshim
+
+import "github.com/go-ole/go-ole"
+
+// AuthnService is an RPC authentication service, as used by CoSetProxyBlanket.
+type AuthnService uint32
+
+// Authentication services, corresponding to the RPC_C_AUTHN constants.
+const (
+	AuthnServiceDefault      AuthnService = 0xFFFFFFFF
+	AuthnServiceGSSNegotiate AuthnService = 9
+	AuthnServiceWinNT        AuthnService = 10
+	AuthnServiceGSSSchannel  AuthnService = 14
+	AuthnServiceGSSKerberos  AuthnService = 16
+)
+
+// BlanketConfig holds the security settings that a shim created with
+// WithProxyBlanket applies to the proxies it returns. If AuthnService is zero,
+// COM chooses the authentication service, as with AuthnServiceDefault. The
+// authorization service, server principal name and client identity are left
+// as they are.
+type BlanketConfig struct {
+	AuthnService AuthnService
+	AuthnLevel   AuthnLevel
+	ImpLevel     ImpLevel
+	Capabilities Capabilities
+}
+
+// WithProxyBlanket causes the shim to call CoSetProxyBlanket with the given
+// settings on every interface returned by CreateObject, CreateDispatch,
+// CreateRemoteObject and GetActiveObject, before handing it out. Interfaces
+// that aren't proxies, such as those of in-process objects, are returned
+// unchanged. If setting the blanket fails, the object is released and the
+// error is returned.
+//
+// The blanket only applies to the returned interface. Interfaces obtained
+// from it later through QueryInterface get the process-wide settings again.
+func WithProxyBlanket(cfg BlanketConfig) Option {
+	return func(c *config) {
+		c.blanket = &cfg
+	}
+}
+
+// applyBlanket sets the blanket configured with WithProxyBlanket, if any, on
+// obj. It must be called on the shim's thread.
+func (s *Shim) applyBlanket(obj *ole.IUnknown) error {
+	if s.settings().blanket == nil {
+		return nil
+	}
+	cfg := *s.settings().blanket
+	if cfg.AuthnService == 0 {
+		cfg.AuthnService = AuthnServiceDefault
+	}
+	err := coSetProxyBlanket(obj, cfg)
+	if hresultCode(err) == ole.E_NOINTERFACE {
+		return nil // Not a proxy
+	}
+	return err
+}
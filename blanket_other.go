@@ -0,0 +1,11 @@
+//go:build !windows
+
+package comshim
+
+import "github.com/go-ole/go-ole"
+
+// coSetProxyBlanket sets the authentication information used for calls made
+// through the proxy obj.
+func coSetProxyBlanket(obj *ole.IUnknown, cfg BlanketConfig) error {
+	return ole.NewError(ole.E_NOTIMPL)
+}
@@ -0,0 +1,32 @@
+//go:build windows
+
+package comshim
+
+import (
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+)
+
+var procCoSetProxyBlanket = modole32.NewProc("CoSetProxyBlanket")
+
+const (
+	rpcCAuthzDefault     = 0xFFFFFFFF
+	coleDefaultPrincipal = ^uintptr(0) // Keep the server principal name
+	coleDefaultAuthInfo  = ^uintptr(0) // Keep the client identity
+)
+
+// coSetProxyBlanket sets the authentication information used for calls made
+// through the proxy obj.
+func coSetProxyBlanket(obj *ole.IUnknown, cfg BlanketConfig) error {
+	hr, _, _ := procCoSetProxyBlanket.Call(
+		uintptr(unsafe.Pointer(obj)),
+		uintptr(cfg.AuthnService),
+		rpcCAuthzDefault,
+		coleDefaultPrincipal,
+		uintptr(cfg.AuthnLevel),
+		uintptr(cfg.ImpLevel),
+		coleDefaultAuthInfo,
+		uintptr(cfg.Capabilities))
+	return hresultError(hr)
+}
@@ -0,0 +1,65 @@
+package comshim
+
+import "github.com/go-ole/go-ole"
+
+// RegCls is a set of REGCLS flags, which control how CoRegisterClassObject
+// lets clients connect to a class object.
+type RegCls uint32
+
+// Registration flags, corresponding to the REGCLS constants.
+const (
+	RegClsSingleUse     RegCls = 0
+	RegClsMultipleUse   RegCls = 1
+	RegClsMultiSeparate RegCls = 2
+	RegClsSuspended     RegCls = 4
+	RegClsSurrogate     RegCls = 8
+)
+
+// RegisterClassObject registers factory, which normally implements
+// IClassFactory, as the class object of clsid, so that other processes can
+// create instances of the class in this one. This is how an out-of-process COM
+// server makes its classes available. Registration runs on the shim's thread,
+// whose apartment serves the calls that come in.
+//
+// The shim holds a reference until revoke is called, so that its apartment
+// outlives the registration. revoke removes the registration on the shim's
+// thread and gives the reference back; it only has an effect the first time.
+// If the shim is closed first, the registration is revoked on its thread as
+// part of the teardown, as if it had been passed to Track.
+//
+// Classes registered with RegClsSuspended can't be reached until the process
+// calls CoResumeClassObjects.
+func (s *Shim) RegisterClassObject(clsid *ole.GUID, factory *ole.IUnknown, flags RegCls) (revoke func(), err error) {
+	if err := s.hold(1); err != nil {
+		return nil, err
+	}
+	var reg *classRegistration
+	err = s.Run(func() error {
+		cookie, err := coRegisterClassObject(clsid, factory, ole.CLSCTX_LOCAL_SERVER, flags)
+		if err != nil {
+			return err
+		}
+		reg = &classRegistration{shim: s, cookie: cookie}
+		return nil
+	})
+	if err != nil {
+		s.done(nil)
+		return nil, err
+	}
+	return s.own(reg), nil
+}
+
+// classRegistration is a registration made by RegisterClassObject. It is
+// tracked like an object, so that it is revoked on the shim's thread.
+type classRegistration struct {
+	shim   *Shim
+	cookie uint32
+}
+
+// Release revokes the registration. It implements Releaser.
+func (r *classRegistration) Release() int32 {
+	if err := coRevokeClassObject(r.cookie); err != nil {
+		r.shim.log().Error("comshim: CoRevokeClassObject failed", "error", err)
+	}
+	return 0
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package comshim
+
+import "github.com/go-ole/go-ole"
+
+// coRegisterClassObject registers factory as the class object of clsid.
+func coRegisterClassObject(clsid *ole.GUID, factory *ole.IUnknown, clsctx uint32, flags RegCls) (cookie uint32, err error) {
+	return 0, ole.NewError(ole.E_NOTIMPL)
+}
+
+// coRevokeClassObject removes a registration made by coRegisterClassObject.
+func coRevokeClassObject(cookie uint32) error {
+	return ole.NewError(ole.E_NOTIMPL)
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package comshim
+
+import (
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+)
+
+var (
+	procCoRegisterClassObject = modole32.NewProc("CoRegisterClassObject")
+	procCoRevokeClassObject   = modole32.NewProc("CoRevokeClassObject")
+)
+
+// coRegisterClassObject registers factory as the class object of clsid.
+func coRegisterClassObject(clsid *ole.GUID, factory *ole.IUnknown, clsctx uint32, flags RegCls) (cookie uint32, err error) {
+	hr, _, _ := procCoRegisterClassObject.Call(
+		uintptr(unsafe.Pointer(clsid)),
+		uintptr(unsafe.Pointer(factory)),
+		uintptr(clsctx),
+		uintptr(flags),
+		uintptr(unsafe.Pointer(&cookie)))
+	return cookie, hresultError(hr)
+}
+
+// coRevokeClassObject removes a registration made by coRegisterClassObject.
+func coRevokeClassObject(cookie uint32) error {
+	hr, _, _ := procCoRevokeClassObject.Call(uintptr(cookie))
+	return hresultError(hr)
+}
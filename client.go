@@ -0,0 +1,129 @@
+package comshim
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Client is a named holder of references to a shim, obtained from NewClient.
+// References taken through a client are attributed to it, so that the shim
+// can tell which components are keeping COM open. A Client may be used by
+// several goroutines at once.
+type Client struct {
+	shim  *Shim
+	name  string
+	count atomic.Int64 // The references the client is holding
+}
+
+// clients are the clients of a shim by name.
+type clients struct {
+	m      sync.Mutex
+	byName map[string]*Client
+}
+
+// WithStrictOwnership makes the shim reject references that aren't taken
+// through a Client: Add, TryAdd, TryAddContext, WaitAdd, Done, TryDone,
+// DoneN, TryAcquire, Acquire, NewRef and Transfer return ErrNoClient, or pass
+// it to the error handler, without touching the counter. Every reference is
+// then accounted for by ClientCounts, which makes it possible to find out who
+// is holding COM open in a large code base.
+//
+// The shim's own helpers, such as Run, With and CreateObject, take and give
+// back references of their own, and keep working without a client.
+func WithStrictOwnership() Option {
+	return func(c *config) {
+		c.strictOwnership = true
+	}
+}
+
+// NewClient returns the client of the shim called name, creating it on first
+// use. Later calls with the same name return the same client, so that
+// independent parts of a component can share its account.
+func (s *Shim) NewClient(name string) *Client {
+	s.clients.m.Lock()
+	defer s.clients.m.Unlock()
+	if c, ok := s.clients.byName[name]; ok {
+		return c
+	}
+	if s.clients.byName == nil {
+		s.clients.byName = make(map[string]*Client)
+	}
+	c := &Client{shim: s, name: name}
+	s.clients.byName[name] = c
+	return c
+}
+
+// ClientCounts returns the number of references held by each client of the
+// shim by name. Clients that have held references in the past and don't hold
+// any now are included with a count of zero.
+func (s *Shim) ClientCounts() map[string]int64 {
+	s.clients.m.Lock()
+	defer s.clients.m.Unlock()
+	counts := make(map[string]int64, len(s.clients.byName))
+	for name, c := range s.clients.byName {
+		counts[name] = c.count.Load()
+	}
+	return counts
+}
+
+// unowned returns ErrNoClient if the shim only accepts references taken
+// through a client.
+func (s *Shim) unowned() error {
+	if s.settings().strictOwnership {
+		return ErrNoClient
+	}
+	return nil
+}
+
+// Name returns the name the client was created with.
+func (c *Client) Name() string {
+	return c.name
+}
+
+// Count returns the number of references the client is holding.
+func (c *Client) Count() int64 {
+	return c.count.Load()
+}
+
+// Add adds delta, which may be negative, to the counter of the shim on behalf
+// of the client, like Shim.Add.
+func (c *Client) Add(delta int) {
+	if err := c.TryAdd(delta); err != nil {
+		c.shim.handleError(err)
+	}
+}
+
+// TryAdd adds delta, which may be negative, to the counter of the shim on
+// behalf of the client, like Shim.TryAdd. The client can't give back more
+// references than it holds: if delta would take its count below zero, neither
+// the count nor the counter changes and ErrNegativeCounter is returned.
+func (c *Client) TryAdd(delta int) error {
+	return c.TryAddContext(context.Background(), delta)
+}
+
+// TryAddContext is like TryAdd, but gives up waiting for the shim to start if
+// ctx is done first, like Shim.TryAddContext.
+func (c *Client) TryAddContext(ctx context.Context, delta int) error {
+	if delta < 0 {
+		if c.count.Add(int64(delta)) < 0 {
+			c.count.Add(int64(-delta))
+			return c.shim.negativeCounter(ErrNegativeCounter)
+		}
+	}
+	if err := c.shim.holdContext(ctx, delta); err != nil {
+		if delta < 0 {
+			c.count.Add(int64(-delta))
+		}
+		return err
+	}
+	if delta > 0 {
+		c.count.Add(int64(delta))
+	}
+	return nil
+}
+
+// Done gives back one of the client's references, like Shim.Done.
+func (c *Client) Done() {
+	c.Add(-1)
+}
@@ -0,0 +1,65 @@
+package comshim
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/NozomiNetworks/go-comshim/internal/clock"
+)
+
+func init() {
+	clock.Option = func(c clock.Clock) any { return withClock(c) }
+}
+
+// withClock makes the shim tell the time with c instead of the system clock.
+// If c is also a clock.Scheduler, the shim's timers are armed by it too,
+// including the deadlines the shim sets itself, such as those of
+// WithTaskTimeout, WaitDoneTimeout and the watchdog's pings. Deadlines of
+// contexts passed in by callers still follow the system clock.
+//
+// It is the test hook behind comshimtest.WithClock, and isn't exported so
+// that the clock doesn't become part of the API.
+func withClock(c clock.Clock) Option {
+	return func(cfg *config) {
+		cfg.clock = c
+		if sch, ok := c.(clock.Scheduler); ok {
+			cfg.scheduler = sch
+		}
+	}
+}
+
+// now returns the current time according to the shim's clock.
+func (s *Shim) now() time.Time {
+	return s.settings().clock.Now()
+}
+
+// sleep pauses the calling goroutine for d according to the shim's scheduler.
+func (s *Shim) sleep(d time.Duration) {
+	<-s.settings().scheduler.NewTimer(d).C()
+}
+
+// withTimeout is like context.WithTimeout, but the deadline is kept by the
+// shim's scheduler. Errors caused by the returned context must be passed
+// through timeoutErr, since a context can only report a deadline of the
+// system clock as context.DeadlineExceeded by itself.
+func (s *Shim) withTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := s.settings().scheduler.(clock.System); ok {
+		return context.WithTimeout(parent, d)
+	}
+	ctx, cancel := context.WithCancelCause(parent)
+	timer := s.settings().scheduler.AfterFunc(d, func() { cancel(context.DeadlineExceeded) })
+	return ctx, func() {
+		timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// timeoutErr returns context.DeadlineExceeded in place of err if ctx, created
+// by withTimeout, was cancelled because its deadline passed.
+func timeoutErr(ctx context.Context, err error) error {
+	if errors.Is(err, context.Canceled) && context.Cause(ctx) == context.DeadlineExceeded {
+		return context.DeadlineExceeded
+	}
+	return err
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package comshim
+
+// There is no COM outside of Windows. The shim still manages its counter,
+// thread and tasks so that cross-platform code behaves the same way, but
+// initializing and uninitializing COM do nothing.
+
+// coInitializeEx initializes COM on the calling thread.
+func coInitializeEx(coinit uint32) error {
+	return nil
+}
+
+// coUninitialize uninitializes COM on the calling thread.
+func coUninitialize() {}
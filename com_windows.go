@@ -0,0 +1,24 @@
+//go:build windows
+
+package comshim
+
+import "github.com/go-ole/go-ole"
+
+// coInitializeEx initializes COM on the calling thread.
+func coInitializeEx(coinit uint32) error {
+	return ole.CoInitializeEx(0, coinit)
+}
+
+// coUninitialize uninitializes COM on the calling thread.
+func coUninitialize() {
+	ole.CoUninitialize()
+}
+
+// hresultError converts a failed HRESULT returned by a COM function into an
+// error. It returns nil for S_OK.
+func hresultError(hr uintptr) error {
+	if hr != 0 {
+		return ole.NewError(hr)
+	}
+	return nil
+}
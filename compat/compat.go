@@ -0,0 +1,52 @@
+// Package compat mirrors the API of github.com/scjalliance/comshim, from which
+// this module was forked, so that code written against it can switch to this
+// module by changing its imports alone:
+//
+//	import comshim "github.com/NozomiNetworks/go-comshim/compat"
+//
+// The package-level functions operate on the same global shim as those of the
+// comshim package, so code using either import path shares one thread. Like
+// upstream, Add panics if the thread cannot be initialized, and Add and Done
+// panic with ErrNegativeCounter when the counter would drop below zero.
+package compat
+
+import "github.com/NozomiNetworks/go-comshim"
+
+// Shim is a shim as returned by New. It provides the methods of the upstream
+// type, Add and Done, along with the rest of those of comshim.Shim.
+type Shim = comshim.Shim
+
+// The errors that upstream exports.
+var (
+	ErrNegativeCounter    = comshim.ErrNegativeCounter
+	ErrAlreadyInitialized = comshim.ErrAlreadyInitialized
+)
+
+// New returns a new shim for multithreaded COM access, with the default
+// settings.
+func New() *Shim {
+	return comshim.New()
+}
+
+// Add adds delta, which may be negative, to the counter of the global shim. As
+// long as the counter is greater than zero, at least one thread is guaranteed
+// to be initialized for multithreaded COM access.
+//
+// If the counter goes negative, or the thread cannot be initialized, Add
+// panics.
+func Add(delta int) {
+	comshim.Add(delta)
+}
+
+// TryAdd is like Add, but returns an error instead of panicking if the thread
+// cannot be initialized.
+func TryAdd(delta int) error {
+	return comshim.TryAdd(delta)
+}
+
+// Done decrements the counter of the global shim.
+//
+// If the counter is already zero, Done panics.
+func Done() {
+	comshim.Done()
+}
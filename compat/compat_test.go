@@ -0,0 +1,27 @@
+package compat_test
+
+import (
+	"testing"
+
+	"github.com/NozomiNetworks/go-comshim"
+	"github.com/NozomiNetworks/go-comshim/compat"
+)
+
+func TestSharesGlobalShim(t *testing.T) {
+	compat.Add(1)
+	comshim.Done()
+	if err := compat.TryAdd(1); err != nil {
+		t.Fatal(err)
+	}
+	compat.Done()
+	comshim.WaitDone()
+}
+
+func TestDonePanicsOnNegativeCounter(t *testing.T) {
+	defer func() {
+		if r := recover(); r != compat.ErrNegativeCounter {
+			t.Errorf("Done with a zero counter panicked with %v, want %v", r, compat.ErrNegativeCounter)
+		}
+	}()
+	compat.Done()
+}
@@ -0,0 +1,73 @@
+package comshimtest
+
+import (
+	"time"
+
+	"github.com/NozomiNetworks/go-comshim"
+	"github.com/NozomiNetworks/go-comshim/internal/clock"
+)
+
+// FakeClock is a clock whose time only moves when Advance is called. Pass it
+// to WithClock to test idle timeouts, the watchdog, retry backoff and the
+// other time-based behavior of a shim without waiting for real time to pass.
+type FakeClock struct {
+	fake *clock.Fake
+}
+
+// NewFakeClock returns a fake clock set to midnight UTC on January 1, 2000.
+func NewFakeClock() *FakeClock {
+	return &FakeClock{fake: clock.NewFake()}
+}
+
+// WithClock makes a shim tell the time with c and arm its timers with it,
+// including the deadlines the shim sets itself, such as those of
+// WithTaskTimeout, WaitDoneTimeout and the watchdog's pings. Deadlines of
+// contexts passed in by callers still follow the system clock.
+func WithClock(c *FakeClock) comshim.Option {
+	return clock.Option(c.fake).(comshim.Option)
+}
+
+// Now returns the current time of the clock.
+func (c *FakeClock) Now() time.Time {
+	return c.fake.Now()
+}
+
+// Advance moves the clock forward by d, firing the timers that become due
+// along the way in order. Functions passed to AfterFunc are called in
+// goroutines of their own, so they may still be running when Advance returns.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.fake.Advance(d)
+}
+
+// WaitTimers blocks until at least n timers are armed, which lets a test wait
+// for a shim to arm the timer it wants to fire with Advance.
+func (c *FakeClock) WaitTimers(n int) {
+	c.fake.WaitTimers(n)
+}
+
+// AfterFunc calls f in its own goroutine once the clock has advanced by d,
+// unless the returned timer is stopped first.
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	return c.fake.AfterFunc(d, f)
+}
+
+// NewTimer returns a timer that sends the time on its channel once the clock
+// has advanced by d.
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	return c.fake.NewTimer(d)
+}
+
+// Timer is a timer of a FakeClock. It behaves like a time.Timer, except that
+// its channel is returned by C.
+type Timer interface {
+	// C returns the channel on which the time is sent, or nil for a timer
+	// created by AfterFunc.
+	C() <-chan time.Time
+
+	// Stop prevents the timer from firing, and reports whether it did.
+	Stop() bool
+
+	// Reset makes the timer fire after d, and reports whether it had been
+	// active.
+	Reset(d time.Duration) bool
+}
@@ -0,0 +1,138 @@
+// Package comshimtest provides a fake COM initialization layer for testing code
+// that uses a comshim.Shim. Pass an Initializer to comshim.WithInitializer to
+// make a shim fail to start, or start slowly, on demand, even on systems
+// without COM. Pass a FakeClock to WithClock to control the passing of time
+// for the shim's timers.
+package comshimtest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/NozomiNetworks/go-comshim"
+	"github.com/go-ole/go-ole"
+)
+
+// HRESULTs returned by CoInitializeEx that the shim handles specially.
+const (
+	SFalse          = 0x00000001 // The thread was already initialized for the same apartment
+	RPCEChangedMode = 0x80010106 // The thread was already initialized for the other apartment
+)
+
+// Initializer is a fake implementation of comshim.Initializer and
+// comshim.ApartmentReporter. Initialize
+// succeeds unless a result has been queued for it, and keeps count of the
+// threads it has initialized. The zero value is ready to use.
+type Initializer struct {
+	m       sync.Mutex
+	results []error       // Results of upcoming calls to Initialize, oldest first
+	delay   time.Duration // How long Initialize takes
+	inits   int           // Calls to Initialize that must be balanced by Uninitialize
+	uninits int           // Calls to Uninitialize
+	coinit  uint32        // The flags of the last call to Initialize
+
+	apartment comshim.ApartmentType // Reported by ApartmentType if forced
+	forced    bool                  // Whether SetApartment has been called
+}
+
+// NewInitializer returns a new fake initializer.
+func NewInitializer() *Initializer {
+	return new(Initializer)
+}
+
+// FailNext makes the next calls to Initialize return errs, one per call, in
+// order. A nil error lets the corresponding call succeed.
+func (f *Initializer) FailNext(errs ...error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.results = append(f.results, errs...)
+}
+
+// AlreadyInitialized makes the next call to Initialize report S_FALSE, as if
+// some other code had initialized the thread for the same apartment and never
+// uninitialized it.
+func (f *Initializer) AlreadyInitialized() {
+	f.FailNext(ole.NewError(SFalse))
+}
+
+// ChangedMode makes the next call to Initialize report RPC_E_CHANGED_MODE, as
+// if some other code had initialized the thread for the other apartment.
+func (f *Initializer) ChangedMode() {
+	f.FailNext(ole.NewError(RPCEChangedMode))
+}
+
+// SetDelay makes every call to Initialize take d, to simulate a slow start.
+func (f *Initializer) SetDelay(d time.Duration) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.delay = d
+}
+
+// Initialize implements comshim.Initializer.
+func (f *Initializer) Initialize(coinit uint32) error {
+	f.m.Lock()
+	delay := f.delay
+	var err error
+	if len(f.results) > 0 {
+		err = f.results[0]
+		f.results = f.results[1:]
+	}
+	if err == nil || hresult(err) == SFalse {
+		f.inits++ // Like CoInitializeEx, S_FALSE still needs uninitializing
+	}
+	f.coinit = coinit
+	f.m.Unlock()
+
+	time.Sleep(delay)
+	return err
+}
+
+// SetApartment makes ApartmentType report apt from now on, regardless of the
+// flags Initialize was called with, to simulate a thread that other code had
+// already initialized for another apartment.
+func (f *Initializer) SetApartment(apt comshim.ApartmentType) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.apartment = apt
+	f.forced = true
+}
+
+// ApartmentType implements comshim.ApartmentReporter. Unless SetApartment says
+// otherwise, it reports the apartment selected by the last call to
+// Initialize.
+func (f *Initializer) ApartmentType() (comshim.ApartmentType, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	switch {
+	case f.forced:
+		return f.apartment, nil
+	case f.coinit&ole.COINIT_APARTMENTTHREADED != 0:
+		return comshim.ApartmentSTA, nil
+	default:
+		return comshim.ApartmentMTA, nil
+	}
+}
+
+// Uninitialize implements comshim.Initializer.
+func (f *Initializer) Uninitialize() {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.uninits++
+}
+
+// Initialized returns the number of calls to Initialize that haven't been
+// balanced by a call to Uninitialize yet. It is negative if Uninitialize has
+// been called too often.
+func (f *Initializer) Initialized() int {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return f.inits - f.uninits
+}
+
+// hresult returns the HRESULT carried by err, or 0.
+func hresult(err error) uintptr {
+	if oerr, ok := err.(*ole.OleError); ok {
+		return oerr.Code()
+	}
+	return 0
+}
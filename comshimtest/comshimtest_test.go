@@ -0,0 +1,181 @@
+package comshimtest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/NozomiNetworks/go-comshim"
+	"github.com/NozomiNetworks/go-comshim/comshimtest"
+)
+
+func TestInitializer(t *testing.T) {
+	f := comshimtest.NewInitializer()
+	s := comshim.New(comshim.WithInitializer(f))
+
+	errInit := errors.New("injected failure")
+	f.FailNext(errInit)
+	if err := s.TryAdd(1); !errors.Is(err, errInit) {
+		t.Errorf("TryAdd returned %v, want %v", err, errInit)
+	}
+
+	f.AlreadyInitialized()
+	if err := s.TryAdd(1); !errors.Is(err, comshim.ErrAlreadyInitialized) {
+		t.Errorf("TryAdd on an initialized thread returned %v, want %v", err, comshim.ErrAlreadyInitialized)
+	}
+
+	f.ChangedMode()
+	var ierr *comshim.InitError
+	if err := s.TryAdd(1); !errors.As(err, &ierr) || ierr.HRESULT != comshimtest.RPCEChangedMode {
+		t.Errorf("TryAdd on a thread in the other apartment returned %v", err)
+	}
+	s.WaitDone()
+	if n := f.Initialized(); n != 0 {
+		t.Errorf("%d initializations are left after failed starts, want 0", n)
+	}
+
+	f.SetDelay(10 * time.Millisecond)
+	s.Add(1)
+	if n := f.Initialized(); n != 1 {
+		t.Errorf("%d initializations while running, want 1", n)
+	}
+	s.Done()
+	s.WaitDone()
+	if n := f.Initialized(); n != 0 {
+		t.Errorf("%d initializations are left after the shim stopped, want 0", n)
+	}
+}
+
+func TestStress(t *testing.T) {
+	comshimtest.Stress(t, comshim.New(), comshimtest.StressOptions{})
+}
+
+func TestStressWithFailures(t *testing.T) {
+	f := comshimtest.NewInitializer()
+	errInit := errors.New("injected failure")
+	for i := 0; i < 50; i++ {
+		f.FailNext(nil, errInit)
+	}
+	s := comshim.New(comshim.WithInitializer(f))
+	comshimtest.Stress(t, s, comshimtest.StressOptions{
+		Goroutines:  100,
+		MaxDelay:    100 * time.Microsecond,
+		IgnoreError: func(err error) bool { return errors.Is(err, errInit) },
+	})
+	if n := f.Initialized(); n != 0 {
+		t.Errorf("thread is initialized %d times after the stress test, want 0", n)
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	c := comshimtest.NewFakeClock()
+	start := c.Now()
+
+	fired := make(chan struct{})
+	c.AfterFunc(time.Minute, func() { close(fired) })
+	timer := c.NewTimer(time.Second)
+	stopped := c.NewTimer(time.Second)
+	if !stopped.Stop() {
+		t.Error("Stop of an armed timer reported that it wasn't armed")
+	}
+
+	c.Advance(59 * time.Second)
+	if got := c.Now(); !got.Equal(start.Add(59 * time.Second)) {
+		t.Errorf("Now returned %v after Advance, want %v", got, start.Add(59*time.Second))
+	}
+	select {
+	case <-fired:
+		t.Fatal("AfterFunc fired early")
+	default:
+	}
+	select {
+	case at := <-timer.C():
+		if !at.Equal(start.Add(time.Second)) {
+			t.Errorf("timer fired at %v, want %v", at, start.Add(time.Second))
+		}
+	default:
+		t.Error("timer did not fire")
+	}
+	select {
+	case <-stopped.C():
+		t.Error("stopped timer fired")
+	default:
+	}
+
+	c.Advance(time.Second)
+	<-fired
+}
+
+func TestFakeClockIdleTimeout(t *testing.T) {
+	c := comshimtest.NewFakeClock()
+	s := comshim.New(comshimtest.WithClock(c), comshim.WithIdleTimeout(time.Minute))
+
+	s.Add(1)
+	s.Done()
+	c.WaitTimers(1) // The thread lingers until the timeout
+
+	c.Advance(30 * time.Second)
+	if idle := s.IdleDuration(); idle != 30*time.Second {
+		t.Errorf("IdleDuration returned %v, want %v", idle, 30*time.Second)
+	}
+	if !s.IsRunning() {
+		t.Fatal("shim stopped before the idle timeout")
+	}
+
+	c.Advance(30 * time.Second)
+	s.WaitDone()
+	if s.IsRunning() {
+		t.Error("shim is still running after the idle timeout")
+	}
+}
+
+func TestFakeClockTaskTimeout(t *testing.T) {
+	c := comshimtest.NewFakeClock()
+	s := comshim.New(comshimtest.WithClock(c), comshim.WithTaskTimeout(time.Minute))
+	defer s.WaitDone()
+
+	release := make(chan struct{})
+	finished := make(chan error, 1)
+	go func() {
+		finished <- s.Run(func() error {
+			<-release
+			return nil
+		})
+	}()
+	c.WaitTimers(1) // The deadline of the function
+	select {
+	case err := <-finished:
+		t.Fatalf("Run returned %v before the timeout", err)
+	default:
+	}
+
+	c.Advance(time.Minute)
+	if err := <-finished; err != context.DeadlineExceeded {
+		t.Errorf("Run returned %v once the timeout passed, want %v", err, context.DeadlineExceeded)
+	}
+	close(release)
+}
+
+func TestFakeClockRetryBackoff(t *testing.T) {
+	c := comshimtest.NewFakeClock()
+	f := comshimtest.NewInitializer()
+	f.FailNext(errors.New("transient"))
+	s := comshim.New(comshimtest.WithClock(c), comshim.WithInitializer(f), comshim.WithInitRetry(2, time.Hour))
+
+	started := make(chan error, 1)
+	go func() { started <- s.TryAdd(1) }()
+	c.WaitTimers(1) // The backoff before the second attempt
+	select {
+	case err := <-started:
+		t.Fatalf("TryAdd returned %v before the backoff was over", err)
+	default:
+	}
+
+	c.Advance(time.Hour)
+	if err := <-started; err != nil {
+		t.Fatalf("TryAdd returned %v after the backoff", err)
+	}
+	s.Done()
+	s.WaitDone()
+}
@@ -0,0 +1,122 @@
+package comshimtest
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NozomiNetworks/go-comshim"
+)
+
+// StressOptions configures Stress. The zero value selects the defaults.
+type StressOptions struct {
+	Goroutines int           // How many goroutines to run at once; 1000 by default
+	Iterations int           // How many operations each goroutine performs; 100 by default
+	MaxDelay   time.Duration // The longest random pause between operations; none by default
+	Seed       int64         // Seeds the random choices; the current time by default
+
+	// IgnoreError reports whether an error returned by TryAdd or Run is
+	// expected, such as when the shim uses an Initializer that has been told
+	// to fail. By default every error fails the test.
+	IgnoreError func(error) bool
+}
+
+// Stress tortures s from many goroutines at once, which randomly take and give
+// back references with Add, TryAdd and Done, run functions with Run and wait
+// for the thread with WaitDone, while one more goroutine keeps taking the
+// counter from zero to one and back to make the thread stop and start over and
+// over. Run it with the race detector enabled. It fails tb if any operation
+// fails, if the counter is off once every goroutine has finished, or if the
+// shim's thread doesn't stop afterwards.
+//
+// s must not be used by anything else during the test. The seed is logged, so
+// that a failing sequence can be replayed by passing it in opts.
+func Stress(tb testing.TB, s *comshim.Shim, opts StressOptions) {
+	tb.Helper()
+	if opts.Goroutines <= 0 {
+		opts.Goroutines = 1000
+	}
+	if opts.Iterations <= 0 {
+		opts.Iterations = 100
+	}
+	if opts.Seed == 0 {
+		opts.Seed = time.Now().UnixNano()
+	}
+	tb.Logf("comshimtest: stress seed %d", opts.Seed)
+
+	check := func(err error) bool {
+		if err == nil {
+			return true
+		}
+		if opts.IgnoreError == nil || !opts.IgnoreError(err) {
+			tb.Error(err)
+		}
+		return false
+	}
+
+	start := make(chan struct{})
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Goroutines; i++ {
+		wg.Add(1)
+		rnd := rand.New(rand.NewSource(opts.Seed + int64(i)))
+		go func() {
+			defer wg.Done()
+			<-start
+			for j := 0; j < opts.Iterations; j++ {
+				if opts.MaxDelay > 0 {
+					time.Sleep(time.Duration(rnd.Int63n(int64(opts.MaxDelay))))
+				}
+				switch rnd.Intn(4) {
+				case 0:
+					if check(s.TryAdd(1)) {
+						s.Done()
+					}
+				case 1:
+					n := 1 + rnd.Intn(3)
+					if check(s.TryAdd(n)) {
+						for k := 0; k < n; k++ {
+							s.Done()
+						}
+					}
+				case 2:
+					check(s.Run(func() error { return nil }))
+				case 3:
+					s.WaitDoneTimeout(time.Duration(rnd.Int63n(int64(time.Millisecond))))
+				}
+			}
+		}()
+	}
+
+	// Forces the counter through zero while the others are busy
+	var oscillator sync.WaitGroup
+	oscillator.Add(1)
+	go func() {
+		defer oscillator.Done()
+		<-start
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if check(s.TryAdd(1)) {
+				s.Done()
+			}
+			s.WaitDoneTimeout(time.Millisecond)
+		}
+	}()
+
+	close(start)
+	wg.Wait()
+	close(stop)
+	oscillator.Wait()
+
+	if count := s.Count(); count != 0 {
+		tb.Errorf("counter is %d once every reference was given back, want 0", count)
+	}
+	if err := s.WaitDoneTimeout(10 * time.Second); err != nil {
+		tb.Errorf("shim's thread didn't stop after the stress test: %v", err)
+	}
+}
@@ -1,6 +1,9 @@
 package comshim
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	// ErrNegativeCounter is returned when the internal counter of a shim drops
@@ -8,9 +11,158 @@ var (
 	// for the same object.
 	ErrNegativeCounter = errors.New("component object model shim counter has dropped below zero")
 
-	// ErrAlreadyInitialized is returned when a shim finds itself on a thread
-	// that has already been initialized. This probably indicates that some
-	// previous goroutine failed to lock the OS thread or failed to call
-	// CoUninitialize when it should have.
+	// ErrNegativeDelta is returned by DoneN when it is asked to give back a
+	// negative number of references.
+	ErrNegativeDelta = errors.New("component object model shim cannot give back a negative number of references")
+
+	// ErrAlreadyInitialized is returned, wrapped in an *InitError, when a shim
+	// finds itself on a thread that has already been initialized. This
+	// probably indicates that some previous goroutine failed to lock the OS
+	// thread or failed to call CoUninitialize when it should have.
 	ErrAlreadyInitialized = errors.New("component object model shim thread has already been initialized")
+
+	// ErrStopped is returned by Run when the shim's thread was released before
+	// the function could be run. This may indicate that Done() has been called
+	// by someone who didn't call Add().
+	ErrStopped = errors.New("component object model shim thread stopped before the function could run")
+
+	// ErrTooManyHolders is returned by TryAdd when adding to the counter would
+	// take it above the limit set by WithMaxHolders.
+	ErrTooManyHolders = errors.New("component object model shim has too many holders")
+
+	// ErrClosed is returned when a shim is used after Close has been called.
+	ErrClosed = errors.New("component object model shim has been closed")
+
+	// ErrDraining is returned when adding to the counter of a shim that is
+	// being drained by Drain.
+	ErrDraining = errors.New("component object model shim is draining")
+
+	// ErrStaleGeneration is returned when a guard acquired before the counter
+	// last dropped to zero is released. Its reference was already given back
+	// by someone else, probably by an extra call to Done, so releasing it
+	// again would take away a reference held by somebody else.
+	ErrStaleGeneration = errors.New("component object model shim guard belongs to a previous generation")
+
+	// ErrNoClient is returned when a shim created with WithStrictOwnership is
+	// asked to take or give back a reference that isn't attributed to a
+	// Client.
+	ErrNoClient = errors.New("component object model shim only accepts references taken through a client")
+
+	// ErrNotSupported is returned when an operation doesn't apply to the
+	// apartment a shim or pool was configured for, such as scaling a pool of
+	// single-threaded apartments.
+	ErrNotSupported = errors.New("component object model shim does not support this operation for its apartment")
+
+	// ErrShimNotReady is returned by Run, Go and their variants on shims
+	// created with WithExplicitStart when the thread is neither running nor
+	// being started.
+	ErrShimNotReady = errors.New("component object model shim has not been started")
+
+	// ErrQueueFull is returned when a function is submitted to a shim whose
+	// queue already holds as many functions as WithQueueLimit allows.
+	ErrQueueFull = errors.New("component object model shim task queue is full")
+
+	// ErrStartTimeout is returned when the shim's thread isn't initialized
+	// within the time set by WithStartTimeout.
+	ErrStartTimeout = errors.New("component object model shim thread did not start in time")
+
+	// ErrWrongThread is returned, by shims created with WithStrictAffinity, in
+	// place of the result of a function that was about to run on a thread
+	// other than the one hosting the shim's apartment. It indicates a bug in
+	// the shim rather than in the caller.
+	ErrWrongThread = errors.New("component object model shim function was dispatched to the wrong thread")
+
+	// ErrApartmentMismatch is returned by AddStrict when the shim's thread
+	// isn't in the apartment the shim was configured for. Its reference has
+	// been given back by then.
+	ErrApartmentMismatch = errors.New("component object model shim apartment doesn't match the configured one")
+
+	// ErrWrongApartment is returned when the shim's thread turns out to belong
+	// to a different apartment than the one the shim was configured for.
+	ErrWrongApartment = errors.New("component object model shim thread is in the wrong apartment")
+
+	// ErrChangedMode matches an *InitError for RPC_E_CHANGED_MODE: the shim's
+	// thread had been initialized for the other concurrency model by some
+	// other code.
+	ErrChangedMode = errors.New("thread was already initialized for the other concurrency model by other code; " +
+		"make sure that code locks the OS thread and uninitializes COM, or use WithApartmentFallback")
+
+	// ErrOutOfMemory matches an *InitError for E_OUTOFMEMORY.
+	ErrOutOfMemory = errors.New("out of memory initializing COM; the process or system is low on resources")
+
+	// ErrInvalidArg matches an *InitError for E_INVALIDARG, which means that
+	// the flags passed to CoInitializeEx are invalid.
+	ErrInvalidArg = errors.New("invalid initialization flags; check the flags given to WithCoInitFlags")
+)
+
+// InitError is returned when a shim cannot be started because a function
+// initializing COM for it failed. It wraps the underlying error, usually an
+// *ole.OleError, so that errors.Is and errors.As see through it.
+type InitError struct {
+	Op      string // The function that failed, such as "CoInitializeEx"
+	HRESULT uint32 // The result the function returned, or 0 if there was none
+	Err     error  // The underlying error
+}
+
+// newInitError wraps err, the failure of the function op, in an *InitError.
+func newInitError(op string, err error) *InitError {
+	return &InitError{Op: op, HRESULT: uint32(hresultCode(err)), Err: err}
+}
+
+// Error implements the error interface. Failures with a known cause are
+// explained rather than described by the system's message for the HRESULT.
+func (e *InitError) Error() string {
+	err := e.Err
+	if known := e.known(); known != nil {
+		err = known
+	}
+	return fmt.Sprintf("component object model shim: %s failed (HRESULT 0x%08X): %v", e.Op, e.HRESULT, err)
+}
+
+// Is reports whether target is the error variable, such as ErrChangedMode,
+// that corresponds to the HRESULT of the failure.
+func (e *InitError) Is(target error) bool {
+	known := e.known()
+	return known != nil && target == known
+}
+
+// known returns the error variable that corresponds to the HRESULT returned by
+// CoInitializeEx or RoInitialize, if there is one.
+func (e *InitError) known() error {
+	if e.Op != "CoInitializeEx" && e.Op != "RoInitialize" {
+		return nil
+	}
+	switch e.HRESULT {
+	case rpcEChangedMode:
+		return ErrChangedMode
+	case eOutOfMemory:
+		return ErrOutOfMemory
+	case eInvalidArg:
+		return ErrInvalidArg
+	default:
+		return nil
+	}
+}
+
+// Unwrap returns the underlying error.
+func (e *InitError) Unwrap() error {
+	return e.Err
+}
+
+const (
+	// sFalse is the HRESULT returned by CoInitializeEx when the thread has
+	// already been initialized for the same concurrency model.
+	sFalse = 0x00000001
+
+	// rpcEChangedMode is the HRESULT returned by CoInitializeEx when the
+	// thread has already been initialized for a different concurrency model.
+	rpcEChangedMode = 0x80010106
+
+	eOutOfMemory = 0x8007000E
+	eInvalidArg  = 0x80070057
+
+	// rpcEWrongThread and coENotSupported are returned by some components
+	// that are activated from an apartment they don't support.
+	rpcEWrongThread = 0x8001010E
+	coENotSupported = 0x80004021
 )
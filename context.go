@@ -0,0 +1,36 @@
+package comshim
+
+import "context"
+
+// shimKey is the context key under which WithShim stores a shim.
+type shimKey struct{}
+
+// WithShim adds one to the counter of s and returns a copy of ctx that carries
+// s, for FromContext to find. The reference is given back once ctx is done, so
+// ctx must eventually be canceled or time out, as is the case for the context
+// of an HTTP request. This ties the lifetime of COM to a request without any
+// bookkeeping in the code handling it.
+//
+// If s is closed first, the reference is given back right away. If s cannot
+// be started, or ctx is done first, WithShim returns the error and ctx
+// unchanged.
+func WithShim(ctx context.Context, s *Shim) (context.Context, error) {
+	if err := s.holdContext(ctx, 1); err != nil {
+		return ctx, err
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.Closed():
+		}
+		s.done(nil)
+	}()
+	return context.WithValue(ctx, shimKey{}, s), nil
+}
+
+// FromContext returns the shim attached to ctx by WithShim, if any. The shim
+// holds a reference for as long as ctx isn't done.
+func FromContext(ctx context.Context) (s *Shim, ok bool) {
+	s, ok = ctx.Value(shimKey{}).(*Shim)
+	return s, ok
+}
@@ -1,34 +1,93 @@
 package comshim
 
 import (
+	"context"
+	"sync"
 	"sync/atomic"
-	"unsafe"
 )
 
 // Counter wraps an int64 atomic counter in a way that provides proper byte
-// alignment.
+// alignment, and lets callers wait for it to reach zero. It is the counter
+// behind every shim, and can be used on its own by code that coordinates
+// resources of its own with the same semantics. The zero value is a counter
+// at zero, ready to use. A Counter must not be copied after first use.
+//
+// The counter is backed by atomic.Int64, which the compiler always aligns to
+// 8 bytes. This keeps 64-bit atomic operations safe on 32-bit platforms such
+// as 386 and ARM regardless of where the counter is placed within a struct.
+//
+// Changing the counter costs an atomic operation and, unless somebody is
+// waiting in WaitZero, nothing else; in particular it never allocates.
 type Counter struct {
-	data [12]byte // Allocate 12 bytes and then use whichever 8 are properly aligned
+	value   atomic.Int64
+	waiting atomic.Bool   // Set while zero may have to be closed; only changed while holding m
+	m       sync.Mutex    // Protects zero
+	zero    chan struct{} // Closed when the counter reaches zero, if WaitZero is waiting
 }
 
 // Add will add the given delta value, which may be negative, to the atomic
 // counter and return the new value.
 func (c *Counter) Add(delta int64) int64 {
-	valuep := c.addr()
-	return atomic.AddInt64(valuep, delta)
+	value := c.value.Add(delta)
+	if value == 0 {
+		c.reachedZero()
+	}
+	return value
 }
 
 // Value returns the current value of the counter.
 func (c *Counter) Value() int64 {
-	valuep := c.addr()
-	return atomic.LoadInt64(valuep)
+	return c.value.Load()
+}
+
+// CompareAndSwap sets the counter to new if it currently holds old, and reports
+// whether it did.
+func (c *Counter) CompareAndSwap(old, new int64) (swapped bool) {
+	swapped = c.value.CompareAndSwap(old, new)
+	if swapped && new == 0 {
+		c.reachedZero()
+	}
+	return swapped
 }
 
-// addr returns the 64-bit aligned address of the counter's data. The alignment
-// is necessary for 64-bit operations on 32-bit compilers.
-func (c *Counter) addr() *int64 {
-	if uintptr(unsafe.Pointer(&c.data))%8 == 0 {
-		return (*int64)(unsafe.Pointer(&c.data))
+// WaitZero blocks until the counter is zero, returning right away if it
+// already is. The counter may have left zero again by the time WaitZero
+// returns; what it guarantees is that the counter was zero at some point after
+// it was called. If ctx is done first, WaitZero returns ctx.Err().
+func (c *Counter) WaitZero(ctx context.Context) error {
+	c.m.Lock()
+	// Announce the wait before looking at the value, so that an Add that
+	// takes the counter to zero in between sees it
+	c.waiting.Store(true)
+	if c.value.Load() == 0 {
+		c.waiting.Store(c.zero != nil)
+		c.m.Unlock()
+		return nil
+	}
+	if c.zero == nil {
+		c.zero = make(chan struct{})
+	}
+	zero := c.zero
+	c.m.Unlock()
+
+	select {
+	case <-zero:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reachedZero wakes up the callers of WaitZero, if any.
+func (c *Counter) reachedZero() {
+	if !c.waiting.Load() {
+		return
+	}
+	c.m.Lock()
+	if c.zero != nil {
+		close(c.zero)
+		c.zero = nil
 	}
-	return (*int64)(unsafe.Pointer(&c.data[4]))
+	c.waiting.Store(false)
+	c.m.Unlock()
 }
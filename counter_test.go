@@ -0,0 +1,89 @@
+package comshim
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// misaligned places a counter after a 4-byte field, which would leave a plain
+// int64 misaligned on 32-bit platforms.
+type misaligned struct {
+	_ uint32
+	c Counter
+}
+
+func TestCounterAlignment(t *testing.T) {
+	var values [3]misaligned
+	for i := range values {
+		if addr := uintptr(unsafe.Pointer(&values[i].c)); addr%8 != 0 {
+			t.Errorf("counter %d is at %#x, which is not 8-byte aligned", i, addr)
+		}
+	}
+}
+
+func TestCounterConcurrentAdd(t *testing.T) {
+	const goroutines, adds = 16, 1000
+
+	var m misaligned
+	wg := sync.WaitGroup{}
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < adds; j++ {
+				m.c.Add(2)
+				m.c.Add(-1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got, want := m.c.Value(), int64(goroutines*adds); got != want {
+		t.Errorf("counter is %d, want %d", got, want)
+	}
+}
+
+func TestCounterWaitZero(t *testing.T) {
+	var c Counter
+	if err := c.WaitZero(context.Background()); err != nil {
+		t.Errorf("WaitZero on a zero counter returned %v", err)
+	}
+
+	c.Add(2)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.WaitZero(ctx); err != context.DeadlineExceeded {
+		t.Errorf("WaitZero on a positive counter returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	waited := make(chan error, 1)
+	go func() { waited <- c.WaitZero(context.Background()) }()
+	c.Add(-1)
+	select {
+	case err := <-waited:
+		t.Fatalf("WaitZero returned %v before the counter reached zero", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	c.CompareAndSwap(1, 0)
+	if err := <-waited; err != nil {
+		t.Errorf("WaitZero returned %v", err)
+	}
+}
+
+func TestCounterWaitZeroRace(t *testing.T) {
+	// Every round crosses zero once, which must wake the waiter of that
+	// round however the two interleave
+	var c Counter
+	for i := 0; i < 1000; i++ {
+		c.Add(1)
+		waited := make(chan error, 1)
+		go func() { waited <- c.WaitZero(context.Background()) }()
+		c.Add(-1)
+		if err := <-waited; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
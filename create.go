@@ -0,0 +1,92 @@
+package comshim
+
+import (
+	"sync"
+
+	"github.com/go-ole/go-ole"
+)
+
+// CreateObject creates an instance of the class clsid on the shim's thread and
+// returns its iid interface. The shim holds a reference for as long as the
+// object is alive, so COM stays initialized while it is in use.
+//
+// The object must be released by calling the returned release function, which
+// releases it on the shim's thread and gives the reference back, rather than
+// by calling its Release method. If the shim is closed first, the object is
+// released as part of the teardown, as if it had been passed to Track.
+func (s *Shim) CreateObject(clsid, iid *ole.GUID) (obj *ole.IUnknown, release func(), err error) {
+	if err := s.hold(1); err != nil {
+		return nil, nil, err
+	}
+	err = s.Run(func() (err error) {
+		obj, err = ole.CreateInstance(clsid, iid)
+		if err != nil {
+			return err
+		}
+		if err := s.applyBlanket(obj); err != nil {
+			obj.Release()
+			obj = nil
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		s.done(nil)
+		return nil, nil, err
+	}
+	return obj, s.own(obj), nil
+}
+
+// CreateDispatch creates an instance of the class registered as progID, such
+// as "Scripting.Dictionary", on the shim's thread and returns its IDispatch
+// interface. Like CreateObject, the shim holds a reference until the returned
+// release function is called.
+func (s *Shim) CreateDispatch(progID string) (disp *ole.IDispatch, release func(), err error) {
+	if err := s.hold(1); err != nil {
+		return nil, nil, err
+	}
+	err = s.Run(func() error {
+		clsid, err := ole.CLSIDFromProgID(progID)
+		if err != nil {
+			return err
+		}
+		unknown, err := ole.CreateInstance(clsid, ole.IID_IUnknown)
+		if err != nil {
+			return err
+		}
+		defer unknown.Release()
+		disp, err = unknown.QueryInterface(ole.IID_IDispatch)
+		if err != nil {
+			return err
+		}
+		if err := s.applyBlanket(&disp.IUnknown); err != nil {
+			disp.Release()
+			disp = nil
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		s.done(nil)
+		return nil, nil, err
+	}
+	return disp, s.own(disp), nil
+}
+
+// own tracks obj, which holds one of the shim's references, and returns a
+// function that releases it on the shim's thread and then gives the reference
+// back. The function only has an effect the first time it is called.
+func (s *Shim) own(obj Releaser) func() {
+	untrack := s.Track(obj)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			// If the shim was closed, the object was released at teardown
+			s.Run(func() error {
+				untrack()
+				return nil
+			})
+			s.done(nil)
+		})
+	}
+}
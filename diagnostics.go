@@ -0,0 +1,136 @@
+package comshim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ole/go-ole"
+)
+
+// Diagnostics returns a human-readable report of everything that can be safely
+// determined about the shim right now. It is intended to be logged when the
+// shim is suspected of being stuck.
+//
+// Diagnostics never calls into COM and never waits on the shim's locks, so it
+// can be called even if the COM thread is hung.
+func (s *Shim) Diagnostics() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "state: %s\n", s.diagnosticState())
+	fmt.Fprintf(&b, "apartment: %s\n", s.apartmentName())
+	fmt.Fprintf(&b, "thread: %d\n", s.ThreadID())
+	fmt.Fprintf(&b, "counter: %d\n", s.Count())
+	fmt.Fprintf(&b, "starts: %d\n", s.starts.Value())
+	if running, ok := s.taskRunning(); ok {
+		fmt.Fprintf(&b, "task: running for %s\n", running)
+	} else {
+		fmt.Fprintf(&b, "task: none\n")
+	}
+	fmt.Fprintf(&b, "queued tasks: %s\n", s.diagnosticQueueDepth())
+	if since := s.IdleSince(); !since.IsZero() {
+		fmt.Fprintf(&b, "idle: %s (since %s)\n", s.IdleDuration(), since.Format(time.RFC3339Nano))
+	} else {
+		fmt.Fprintf(&b, "idle: no\n")
+	}
+	return b.String()
+}
+
+// Report is a snapshot of the state of a shim, returned by DebugReport. It
+// can be serialized with encoding/json, for example to serve it on an
+// internal debugging endpoint.
+type Report struct {
+	State     string        // As reported by Diagnostics
+	Apartment string        // The apartment the thread is initialized for
+	ThreadID  uint32        // The OS thread hosting the apartment, or 0
+	Count     int64         // The current value of the counter
+	Uptime    time.Duration // How long the apartment has been up this time, or 0
+	IdleSince time.Time     // When the counter last dropped to zero, or the zero time while in use
+	Busy      bool          // Whether the thread is running a function
+	BusyFor   time.Duration // How long the function has been running
+	Stats     Stats
+	Holders   []HolderInfo `json:",omitempty"` // Only with leak tracking
+}
+
+// DebugReport returns a snapshot of the state of the shim, including the
+// callers holding references if leak tracking is enabled. Like Diagnostics, it
+// never calls into COM and never waits on the shim's locks.
+//
+// DebugReport is the shim's snapshot: there is no separate Snapshot method,
+// and the health package serves this report as its JSON body.
+func (s *Shim) DebugReport() Report {
+	r := Report{
+		State:     s.diagnosticState(),
+		Apartment: s.apartmentName(),
+		ThreadID:  s.ThreadID(),
+		Count:     s.Count(),
+		IdleSince: s.IdleSince(),
+		Stats:     s.Stats(),
+		Holders:   s.OutstandingHolders(),
+	}
+	r.BusyFor, r.Busy = s.taskRunning()
+	if since := s.upSince.Load(); since != 0 {
+		r.Uptime = time.Duration(s.now().UnixNano() - since)
+	}
+	return r
+}
+
+// taskRunning reports whether the thread is running a function, and for how
+// long.
+func (s *Shim) taskRunning() (running time.Duration, ok bool) {
+	since := s.busySince.Load()
+	if since == 0 {
+		return 0, false
+	}
+	return time.Duration(s.now().UnixNano() - since), true
+}
+
+// diagnosticState describes the state of the shim's goroutine without
+// blocking. If the start lock is held by someone else the state is reported
+// as unknown rather than waiting for it.
+func (s *Shim) diagnosticState() string {
+	if s.IsRunning() {
+		if s.draining.Load() {
+			return "running (draining)"
+		}
+		if s.Count() == 0 {
+			return "running (idle)"
+		}
+		return "running"
+	}
+
+	if !s.startAccess.TryRLock() {
+		return "unknown (start lock held)"
+	}
+	starting := s.starting != nil
+	s.startAccess.RUnlock()
+	if starting {
+		return "starting"
+	}
+	if s.closed.Load() {
+		return "closed"
+	}
+	return "stopped"
+}
+
+// apartmentName describes the apartment the shim's thread is initialized for.
+func (s *Shim) apartmentName() string {
+	name := "multithreaded"
+	if s.settings().coinit&ole.COINIT_APARTMENTTHREADED != 0 {
+		name = "single-threaded"
+	}
+	if s.settings().winRT {
+		name += " (Windows Runtime)"
+	}
+	return name
+}
+
+// diagnosticQueueDepth returns the number of tasks waiting to run, or unknown
+// if the signal lock is held by someone else.
+func (s *Shim) diagnosticQueueDepth() string {
+	if !s.signalAccess.TryRLock() {
+		return "unknown (signal lock held)"
+	}
+	defer s.signalAccess.RUnlock()
+	return strconv.Itoa(len(s.tasks))
+}
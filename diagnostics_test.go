@@ -0,0 +1,77 @@
+package comshim_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NozomiNetworks/go-comshim"
+	"github.com/NozomiNetworks/go-comshim/comshimtest"
+)
+
+func TestDiagnosticsStopped(t *testing.T) {
+	report := comshim.New().Diagnostics()
+	for _, want := range []string{"state: stopped\n", "apartment: multithreaded\n", "counter: 0\n", "starts: 0\n", "task: none\n", "queued tasks: 0\n", "idle: no\n"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("diagnostics report is missing %q:\n%s", want, report)
+		}
+	}
+}
+
+func TestDiagnosticsRunningTask(t *testing.T) {
+	c := comshimtest.NewFakeClock()
+	s := comshim.New(comshimtest.WithClock(c))
+	defer s.WaitDone()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	finished := make(chan error, 1)
+	go func() {
+		finished <- s.Run(func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+	c.Advance(10 * time.Second)
+
+	if report := s.Diagnostics(); !strings.Contains(report, "task: running for ") {
+		t.Errorf("diagnostics report doesn't show the running task:\n%s", report)
+	}
+	if r := s.DebugReport(); !r.Busy || r.BusyFor != 10*time.Second {
+		t.Errorf("debug report shows busy %v for %v, want a task running for 10s", r.Busy, r.BusyFor)
+	}
+	close(release)
+	if err := <-finished; err != nil {
+		t.Fatal(err)
+	}
+	// The thread clears the task shortly after handing over its result
+	deadline := time.Now().Add(5 * time.Second)
+	for s.DebugReport().Busy {
+		if time.Now().After(deadline) {
+			t.Fatal("debug report still shows a task running after it finished")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDebugReport(t *testing.T) {
+	s := comshim.New(comshim.WithLeakTracking(true))
+	defer s.WaitDone()
+	s.Add(1)
+	defer s.Done()
+
+	r := s.DebugReport()
+	if r.State != "running" || r.Count != 1 || r.Uptime <= 0 || !r.IdleSince.IsZero() || len(r.Holders) != 1 {
+		t.Errorf("debug report of a running shim is %+v", r)
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"State":"running"`) {
+		t.Errorf("JSON report %s does not contain the state", b)
+	}
+}
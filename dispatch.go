@@ -0,0 +1,43 @@
+package comshim
+
+import (
+	"github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+)
+
+// CallMethod calls the method name of disp with args on the shim's thread, and
+// returns its result. Automation objects living in a single-threaded
+// apartment, such as those created by CreateDispatch on a shim created by
+// NewSTA, misbehave when called from any other thread.
+//
+// The result must be cleared with VariantClear once it is no longer needed.
+// If it holds an interface, that interface belongs to the shim's thread too,
+// so the result should be cleared from Run.
+func (s *Shim) CallMethod(disp *ole.IDispatch, name string, args ...interface{}) (result *ole.VARIANT, err error) {
+	err = s.Run(func() (err error) {
+		result, err = oleutil.CallMethod(disp, name, args...)
+		return err
+	})
+	return result, err
+}
+
+// GetProperty gets the property name of disp on the shim's thread, like
+// CallMethod. args are passed to indexed properties.
+func (s *Shim) GetProperty(disp *ole.IDispatch, name string, args ...interface{}) (result *ole.VARIANT, err error) {
+	err = s.Run(func() (err error) {
+		result, err = oleutil.GetProperty(disp, name, args...)
+		return err
+	})
+	return result, err
+}
+
+// PutProperty sets the property name of disp on the shim's thread, like
+// CallMethod. The last of args is the new value; any others are passed to
+// indexed properties.
+func (s *Shim) PutProperty(disp *ole.IDispatch, name string, args ...interface{}) (result *ole.VARIANT, err error) {
+	err = s.Run(func() (err error) {
+		result, err = oleutil.PutProperty(disp, name, args...)
+		return err
+	})
+	return result, err
+}
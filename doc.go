@@ -12,6 +12,29 @@
 // used to determine the ongoing need for the shim to stay in place. Once the
 // counter reaches 0, the thread is released and COM may be deinitialized.
 //
+// Some components, such as Office automation servers, can only be used from a
+// single-threaded apartment. Shims created with NewSTA initialize their thread
+// as an STA instead and pump window messages while the counter is above 0.
+//
+// On Windows 8 and later, shims created with NewMTAUsage keep the MTA alive
+// with CoIncrementMTAUsage instead, so no OS thread has to be locked at all.
+//
+// Objects with thread affinity are used from the shim's own thread by passing
+// functions to Run, or to RunResult to get a value back:
+//
+//	name, err := comshim.RunResult(s, func() (string, error) {
+//		v, err := oleutil.GetProperty(disp, "Name")
+//		if err != nil {
+//			return "", err
+//		}
+//		defer ole.VariantClear(v)
+//		return v.ToString(), nil
+//	})
+//
+// The package builds on every platform. Outside of Windows there is no COM, so
+// initialization does nothing, but counters, threads and Run behave the same
+// way. This lets cross-platform code use a shim without build tags of its own.
+//
 // The comshim package is designed to allow COM-based libraries to hide the
 // threading requirements of COM from the user. COM interfaces can be hidden
 // behind idomatic Go structures that increment the counter with calls to
@@ -0,0 +1,70 @@
+package comshim
+
+import "context"
+
+// Start starts the shim's thread right away and keeps it running until Close
+// is called, regardless of the counter, so that the cost of initializing COM
+// is paid up front rather than by the first caller of Add. Add and Done keep
+// working as usual; they just never bring the thread down. WaitDone does not
+// return until the shim has been closed.
+//
+// If the shim can't be started, Start returns the error and the shim goes
+// back to starting on demand.
+func (s *Shim) Start(ctx context.Context) error {
+	s.keepAlive.Store(true)
+	if err := s.holdContext(ctx, 0); err != nil {
+		s.keepAlive.Store(s.settings().permanent)
+		return err
+	}
+	return nil
+}
+
+// WithEagerStart makes New call Start before returning, so that the shim's
+// thread is initialized by the time it is first used. If the start fails, the
+// error is available from Err and the shim starts on demand instead.
+func WithEagerStart() Option {
+	return func(c *config) {
+		c.eagerStart = true
+	}
+}
+
+// WithPermanent keeps the shim's thread running until Close once it has been
+// started, regardless of the counter, so that always-on services don't pay for
+// uninitializing and initializing COM again each time the counter touches
+// zero. Unlike WithEagerStart the thread is still started on demand, by the
+// first Add. The counter keeps tracking the references held, for Count and
+// the diagnostics, but WaitDone does not return until the shim has been
+// closed.
+func WithPermanent() Option {
+	return func(c *config) {
+		c.permanent = true
+	}
+}
+
+// held reports whether the shim's apartment must be kept, because the counter
+// is positive, Start has been called or the shim is permanent.
+func (s *Shim) held() bool {
+	return s.c.Value() > 0 || s.keepAlive.Load()
+}
+
+// WithExplicitStart stops Run, Go and their variants from starting the shim's
+// thread themselves, for callers that want a function submitted too early to
+// fail rather than to pay for initializing COM. The thread is then only
+// started by Add, TryAdd, Acquire, Start and the like. A function submitted
+// to Run or Go meets the shim in one of three states:
+//
+//   - Not started: nobody holds a reference and no start is in progress, so
+//     the function is rejected with ErrShimNotReady.
+//   - Starting: a start is in progress, so the function waits for it like a
+//     caller of TryAddContext, bounded by its context, and runs once COM is
+//     initialized. If the start fails, its error is returned instead.
+//   - Ready: the thread is up, including while it lingers for WithIdleTimeout,
+//     so the function is queued right away.
+//
+// Without this option, a function submitted in the first state starts the
+// thread, and the states are otherwise handled the same way.
+func WithExplicitStart() Option {
+	return func(c *config) {
+		c.explicitStart = true
+	}
+}
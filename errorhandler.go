@@ -0,0 +1,58 @@
+package comshim
+
+import "os"
+
+// Action tells the shim what to do about an error it cannot return to the
+// caller, such as a failed start in Add or an extra call to Done.
+type Action int
+
+const (
+	// ActionPanic panics with the error. This is the default.
+	ActionPanic Action = iota
+
+	// ActionLog logs the error and carries on as if the call had not been
+	// made: the counter is left unchanged.
+	ActionLog
+
+	// ActionAbort logs the error and terminates the process with exit code 2,
+	// without giving deferred functions or recover a chance to run.
+	ActionAbort
+)
+
+// WithErrorHandler sets a function that decides what happens when Add,
+// Acquire or Done fail, when TryAdd would drop the counter below zero, and
+// when Guard.Release finds a stale guard, which by default all panic. The
+// function receives the error, such as ErrNegativeCounter or an *InitError,
+// and returns the Action to take. It is
+// called on the goroutine that made the failing call.
+//
+// Libraries that embed a shim can use it to make sure a bug elsewhere never
+// panics in their callers. Note that when a failed Add is merely logged, the
+// caller goes on without a reference, so its matching Done fails in turn.
+//
+// WithNegativeCounterErrors takes precedence for ErrNegativeCounter in TryAdd
+// and Done.
+func WithErrorHandler(fn func(error) Action) Option {
+	return func(c *config) {
+		c.errorHandler = fn
+	}
+}
+
+// handleError deals with err, which the caller has no way to return, as
+// decided by the handler set by WithErrorHandler. It only returns if the
+// error is to be ignored.
+func (s *Shim) handleError(err error) {
+	action := ActionPanic
+	if s.settings().errorHandler != nil {
+		action = s.settings().errorHandler(err)
+	}
+	switch action {
+	case ActionLog:
+		s.log().Error("comshim: ignoring error", "error", err)
+	case ActionAbort:
+		s.log().Error("comshim: aborting", "error", err)
+		os.Exit(2)
+	default:
+		panic(err)
+	}
+}
@@ -0,0 +1,68 @@
+package comshim
+
+import (
+	"fmt"
+
+	"github.com/go-ole/go-ole"
+)
+
+// Event Tracing for Windows levels, corresponding to the TRACE_LEVEL
+// constants.
+const (
+	etwLevelError   = 2
+	etwLevelInfo    = 4
+	etwLevelVerbose = 5
+)
+
+// WithETW makes the shim write Event Tracing for Windows events to the
+// provider identified by provider, so that tools such as WPA and xperf can
+// line up its activity with the rest of a system trace. The shim writes an
+// event for each lifecycle event delivered to subscribers (see EventKind),
+// each time the counter rises from zero, and for each function run on its
+// thread, along with how long it took.
+//
+// The events are written with EventWriteString, so any consumer enabling the
+// provider can show them as text messages. The provider is registered by New
+// and stays registered for the life of the process. If registering it fails
+// the error is logged and the shim works without tracing. On other platforms
+// the option has no effect.
+func WithETW(provider ole.GUID) Option {
+	return func(c *config) {
+		c.etwProvider = &provider
+	}
+}
+
+// startETW registers the provider set by WithETW, if any. It is called by New.
+func (s *Shim) startETW() {
+	if s.settings().etwProvider == nil {
+		return
+	}
+	handle, err := eventRegister(s.settings().etwProvider)
+	if err != nil {
+		s.log().Error("comshim: registering the ETW provider failed", "provider", s.settings().etwProvider.String(), "error", err)
+		return
+	}
+	s.etwHandle = handle
+}
+
+// traceETW writes a message to the ETW provider, if the shim has one.
+func (s *Shim) traceETW(level uint8, format string, args ...interface{}) {
+	if s.etwHandle == 0 {
+		return
+	}
+	eventWriteString(s.etwHandle, level, "comshim: "+fmt.Sprintf(format, args...))
+}
+
+// traceEvent writes a lifecycle event to the ETW provider, if the shim has
+// one.
+func (s *Shim) traceEvent(kind EventKind, err error) {
+	switch {
+	case s.etwHandle == 0:
+	case err != nil:
+		s.traceETW(etwLevelError, "%v: %v", kind, err)
+	case kind == EventStarting:
+		s.traceETW(etwLevelVerbose, "%v", kind)
+	default:
+		s.traceETW(etwLevelInfo, "%v", kind)
+	}
+}
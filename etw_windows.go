@@ -0,0 +1,45 @@
+//go:build windows
+
+package comshim
+
+import (
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modadvapi32 = windows.NewLazySystemDLL("advapi32.dll")
+
+	procEventRegister    = modadvapi32.NewProc("EventRegister")
+	procEventWriteString = modadvapi32.NewProc("EventWriteString")
+)
+
+// eventRegister registers the ETW provider identified by provider.
+func eventRegister(provider *ole.GUID) (handle uint64, err error) {
+	r, _, _ := procEventRegister.Call(
+		uintptr(unsafe.Pointer(provider)),
+		0,
+		0,
+		uintptr(unsafe.Pointer(&handle)))
+	if r != 0 {
+		return 0, windows.Errno(r)
+	}
+	return handle, nil
+}
+
+// eventWriteString writes msg as an event of the given level to the provider
+// registered as handle. Failures are ignored, as there is nobody to tell.
+func eventWriteString(handle uint64, level uint8, msg string) {
+	p, err := windows.UTF16PtrFromString(msg)
+	if err != nil {
+		return
+	}
+	if unsafe.Sizeof(uintptr(0)) == 8 {
+		procEventWriteString.Call(uintptr(handle), uintptr(level), 0, uintptr(unsafe.Pointer(p)))
+		return
+	}
+	// REGHANDLE and ULONGLONG keyword are passed as two words each on 32-bit
+	procEventWriteString.Call(uintptr(handle), uintptr(handle>>32), uintptr(level), 0, 0, uintptr(unsafe.Pointer(p)))
+}
@@ -0,0 +1,194 @@
+package comshim
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// EventKind identifies a step in the lifecycle of a shim.
+type EventKind int
+
+// The lifecycle events delivered to subscribers.
+const (
+	// EventStarting is delivered when the shim begins to start its thread.
+	EventStarting EventKind = iota + 1
+
+	// EventInitialized is delivered once the shim's thread has been
+	// initialized and COM can be used.
+	EventInitialized
+
+	// EventInitFailed is delivered when the shim could not be started. The
+	// event carries the error.
+	EventInitFailed
+
+	// EventDraining is delivered when the counter drops to zero or the shim is
+	// closed, so that the thread is about to be released.
+	EventDraining
+
+	// EventUninitialized is delivered once COM has been uninitialized and the
+	// thread released.
+	EventUninitialized
+
+	// EventExpired is delivered when the lifetime set by WithMaxLifetime is
+	// over, right before the shim is closed.
+	EventExpired
+
+	// EventPanic is delivered when the shim's goroutine has recovered from a
+	// panic, before the thread is uninitialized. The event carries the
+	// *PanicError, which Err reports as well.
+	EventPanic
+)
+
+// String returns the name of the event kind.
+func (k EventKind) String() string {
+	switch k {
+	case EventStarting:
+		return "starting"
+	case EventInitialized:
+		return "initialized"
+	case EventInitFailed:
+		return "init failed"
+	case EventDraining:
+		return "draining"
+	case EventUninitialized:
+		return "uninitialized"
+	case EventExpired:
+		return "expired"
+	case EventPanic:
+		return "panic"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes a step in the lifecycle of a shim.
+type Event struct {
+	Kind EventKind
+	Time time.Time
+	Err  error // The failure, for EventInitFailed and EventPanic
+}
+
+// subscribers holds the listeners registered with Subscribe and the events
+// that haven't been delivered to them yet.
+type subscribers struct {
+	m         sync.Mutex
+	cond      sync.Cond
+	listeners map[int]func(Event)
+	next      int     // The identifier of the next listener
+	queue     []Event // Events waiting to be delivered
+	running   bool    // Whether a goroutine is delivering events
+	queued    uint64  // The number of events queued so far
+	delivered uint64  // The number of queued events delivered or dropped so far
+}
+
+// Subscribe registers fn to be called with every lifecycle event of the shim
+// from now on, until unsubscribe is called. Events are delivered one at a
+// time, in order, on a goroutine of their own, so fn may call the shim's
+// methods; a slow fn delays later events but never the shim itself.
+func (s *Shim) Subscribe(fn func(Event)) (unsubscribe func()) {
+	b := &s.subscribers
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.listeners == nil {
+		b.listeners = make(map[int]func(Event))
+		b.cond.L = &b.m
+	}
+	id := b.next
+	b.next++
+	b.listeners[id] = fn
+	if !b.running {
+		b.running = true
+		go b.dispatch()
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.m.Lock()
+			defer b.m.Unlock()
+			delete(b.listeners, id)
+			b.cond.Broadcast() // Lets the dispatcher stop if this was the last
+		})
+	}
+}
+
+// emit queues an event for the subscribers, if there are any. It never blocks,
+// so it may be called while holding the shim's locks.
+func (s *Shim) emit(kind EventKind, err error) {
+	s.traceEvent(kind, err)
+
+	b := &s.subscribers
+	b.m.Lock()
+	defer b.m.Unlock()
+	if len(b.listeners) == 0 {
+		return
+	}
+	b.queue = append(b.queue, Event{Kind: kind, Time: s.now(), Err: err})
+	b.queued++
+	b.cond.Broadcast()
+}
+
+// dispatch delivers queued events until there are no listeners left.
+func (b *subscribers) dispatch() {
+	b.m.Lock()
+	defer b.m.Unlock()
+	for len(b.listeners) > 0 {
+		if len(b.queue) == 0 {
+			b.cond.Wait()
+			continue
+		}
+		ev := b.queue[0]
+		b.queue = b.queue[1:]
+		listeners := make([]func(Event), 0, len(b.listeners))
+		for _, fn := range b.listeners {
+			listeners = append(listeners, fn)
+		}
+
+		b.m.Unlock()
+		for _, fn := range listeners {
+			fn(ev)
+		}
+		b.m.Lock()
+		b.delivered++
+		b.cond.Broadcast() // Lets flush return
+	}
+	b.queue = nil
+	b.delivered = b.queued // Nobody is left to deliver them to
+	b.running = false
+	b.cond.Broadcast()
+}
+
+// flush waits until the events queued so far have been delivered to the
+// listeners, or dropped because none are left.
+func (b *subscribers) flush() {
+	b.m.Lock()
+	defer b.m.Unlock()
+	for target := b.queued; b.delivered < target; {
+		b.cond.Wait()
+	}
+}
+
+// WaitForEvent blocks until the shim delivers an event of the given kind, for
+// example to let a test wait for the shim to restart. Only events emitted
+// after the call count: it doesn't look at the shim's current state, so
+// waiting for EventInitialized on a running shim waits for the next start.
+// Since it subscribes before waiting, an event emitted as it is called is
+// never missed. If ctx is done first, WaitForEvent returns ctx.Err().
+func (s *Shim) WaitForEvent(ctx context.Context, kind EventKind) error {
+	seen := make(chan struct{})
+	var once sync.Once
+	unsubscribe := s.Subscribe(func(ev Event) {
+		if ev.Kind == kind {
+			once.Do(func() { close(seen) })
+		}
+	})
+	defer unsubscribe()
+
+	select {
+	case <-seen:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,63 @@
+//go:build go1.23
+
+package comshim
+
+import (
+	"iter"
+	"sync"
+)
+
+// AllEvents returns an iterator over the lifecycle events of the shim from
+// now on, for use with range:
+//
+//	for ev := range s.AllEvents() {
+//		log.Println(ev.Kind)
+//	}
+//
+// The iterator subscribes to the shim when the loop starts. Once the shim has
+// been closed, it waits for the shim's goroutine to terminate, yields the
+// events that are left, including the final EventDraining and
+// EventUninitialized, and returns. Breaking out of the loop unsubscribes. It
+// is only available when building with Go 1.23 or later; use Subscribe
+// otherwise.
+func (s *Shim) AllEvents() iter.Seq[Event] {
+	return func(yield func(Event) bool) {
+		var m sync.Mutex
+		var queue []Event
+		wake := make(chan struct{}, 1)
+		unsubscribe := s.Subscribe(func(ev Event) {
+			m.Lock()
+			queue = append(queue, ev)
+			m.Unlock()
+			select {
+			case wake <- struct{}{}:
+			default: // Already woken
+			}
+		})
+		defer unsubscribe()
+
+		for closed := false; ; {
+			m.Lock()
+			events := queue
+			queue = nil
+			m.Unlock()
+			for _, ev := range events {
+				if !yield(ev) {
+					return
+				}
+			}
+			if closed {
+				return
+			}
+			select {
+			case <-wake:
+			case <-s.Closed():
+				// The goroutine emits its last events before terminating,
+				// and the flush hands them to us
+				s.WaitDone()
+				s.subscribers.flush()
+				closed = true
+			}
+		}
+	}
+}
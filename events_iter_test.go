@@ -0,0 +1,91 @@
+//go:build go1.23
+
+package comshim_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NozomiNetworks/go-comshim"
+)
+
+// cycle starts and stops s until stop is closed, since an iterator only
+// subscribes once its loop has started.
+func cycle(s *comshim.Shim, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		s.Add(1)
+		s.Done()
+		s.WaitDone()
+	}
+}
+
+func TestAllEvents(t *testing.T) {
+	s := comshim.New()
+	stop := make(chan struct{})
+	cycled := make(chan struct{})
+	go func() {
+		defer close(cycled)
+		cycle(s, stop)
+	}()
+
+	n := 0
+	for ev := range s.AllEvents() {
+		if n++; n == 1 {
+			// Closing the shim ends the loop
+			close(stop)
+			<-cycled
+			if err := s.Close(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if ev.Kind == 0 {
+			t.Errorf("the iterator yielded %+v", ev)
+		}
+	}
+
+	// The events of the shutdown are yielded before the loop ends
+	s = comshim.New()
+	stop = make(chan struct{})
+	cycled = make(chan struct{})
+	go func() {
+		defer close(cycled)
+		cycle(s, stop)
+	}()
+	var kinds []comshim.EventKind
+	for ev := range s.AllEvents() {
+		if len(kinds) == 0 {
+			close(stop)
+			<-cycled
+			s.Add(1)
+			go s.Close(context.Background())
+		}
+		kinds = append(kinds, ev.Kind)
+	}
+	s.Done()
+	if len(kinds) < 2 || kinds[len(kinds)-2] != comshim.EventDraining || kinds[len(kinds)-1] != comshim.EventUninitialized {
+		t.Errorf("closing the shim yielded %v, want it to end with %v and %v", kinds, comshim.EventDraining, comshim.EventUninitialized)
+	}
+
+	// Breaking out of the loop unsubscribes, which TestMain checks by
+	// looking for leaked goroutines
+	s = comshim.New()
+	defer s.WaitDone()
+	stop = make(chan struct{})
+	cycled = make(chan struct{})
+	go func() {
+		defer close(cycled)
+		cycle(s, stop)
+	}()
+	for ev := range s.AllEvents() {
+		if ev.Kind == comshim.EventInitialized {
+			break
+		}
+	}
+	close(stop)
+	<-cycled
+}
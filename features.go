@@ -0,0 +1,39 @@
+package comshim
+
+// Features reports which of the optional functions of the operating system
+// the shim relies on for some of its features are available. Agents that run
+// on everything from Windows Server 2008 R2 onwards can use it to tell which
+// of those features are active. Outside of Windows every field is false.
+type Features struct {
+	// MTAUsage is set if CoIncrementMTAUsage is available, from Windows 8 on.
+	// Without it NewMTAUsage falls back to a thread of its own.
+	MTAUsage bool
+
+	// WinRT is set if RoInitialize is available, from Windows 8 on. Without
+	// it shims created by NewWinRT fail to start.
+	WinRT bool
+
+	// ThreadNames is set if SetThreadDescription is available, from Windows
+	// 10 version 1607 on. Without it WithThreadName has no effect.
+	ThreadNames bool
+
+	// AgileReferences is set if RoGetAgileReference is available, from
+	// Windows 8.1 on. Without it Shim.AgileRef fails, and RegisterInterface
+	// has to be used instead.
+	AgileReferences bool
+}
+
+// SupportedFeatures detects which optional functions the running operating
+// system provides. The shim uses the same detection to decide which
+// strategies it can use.
+//
+// The name Capabilities is taken by the authentication capabilities of
+// SecurityConfig and BlanketConfig, hence SupportedFeatures.
+func SupportedFeatures() Features {
+	return Features{
+		MTAUsage:        mtaUsageSupported(),
+		WinRT:           winRTSupported(),
+		ThreadNames:     threadNamesSupported(),
+		AgileReferences: agileReferencesSupported(),
+	}
+}
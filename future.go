@@ -0,0 +1,107 @@
+package comshim
+
+import (
+	"context"
+	"runtime"
+)
+
+// Future is the pending result of a function submitted with Go.
+type Future struct {
+	done  chan struct{} // Closed once value and err are set
+	value interface{}
+	err   error
+}
+
+// Go queues fn to run on the shim's thread, like Run, but returns right away
+// instead of waiting for it to finish. The result is retrieved with the
+// returned Future. Functions submitted with Go and Run are run in the order
+// they were submitted, so a pipeline can queue several calls and carry on with
+// other work in the meantime.
+//
+// The shim holds a reference until fn has finished, starting the thread if
+// necessary; Go waits for the start.
+func (s *Shim) Go(fn func() (interface{}, error)) *Future {
+	return s.GoContext(context.Background(), fn)
+}
+
+// GoContext is like Go, but if ctx is done before fn has started it will not
+// be run at all, and the Future reports ctx.Err(). Likewise, if the shim is
+// closed before fn has finished, the Future reports ErrClosed, and fn isn't
+// run if it hasn't started yet.
+func (s *Shim) GoContext(ctx context.Context, fn func() (interface{}, error)) *Future {
+	f := &Future{done: make(chan struct{})}
+	if err := ctx.Err(); err != nil {
+		f.finish(nil, err)
+		return f
+	}
+	if err := s.holdTask(ctx); err != nil {
+		f.finish(nil, err)
+		return f
+	}
+
+	var value interface{} // Only read once the task has reported back
+	t := &task{
+		fn: func() (err error) {
+			value, err = fn()
+			return err
+		},
+		done: make(chan error, 1),
+	}
+	if s.usingMTAUsage() {
+		// There is no dedicated thread; any thread in the implicit MTA will do
+		go func() {
+			defer s.done(nil)
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			err := t.call()
+			f.finish(value, err)
+		}()
+		return f
+	}
+	if err := s.enqueue(t); err != nil {
+		s.done(nil)
+		f.finish(nil, err)
+		return f
+	}
+
+	go func() {
+		defer s.done(nil)
+		select {
+		case err := <-t.done:
+			f.finish(value, err)
+		case <-ctx.Done():
+			t.abandoned.Store(true)
+			f.finish(nil, ctx.Err())
+		case <-s.Closed():
+			t.abandoned.Store(true)
+			f.finish(nil, ErrClosed)
+		}
+	}()
+	return f
+}
+
+// finish records the result of the future.
+func (f *Future) finish(value interface{}, err error) {
+	if err != nil {
+		value = nil
+	}
+	f.value, f.err = value, err
+	close(f.done)
+}
+
+// Wait waits for the function to finish and returns its result. If fn
+// panicked, the panic is returned as a *PanicError. If ctx is done first, Wait
+// returns ctx.Err(), and the function carries on regardless.
+func (f *Future) Wait(ctx context.Context) (interface{}, error) {
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Done returns a channel that is closed once the result is available.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
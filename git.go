@@ -0,0 +1,111 @@
+package comshim
+
+import (
+	"time"
+
+	"github.com/go-ole/go-ole"
+)
+
+// Cookie identifies an interface registered in the COM Global Interface Table.
+type Cookie uint32
+
+// gitCookies holds the time at which each interface that a shim registered in
+// the Global Interface Table, and hasn't revoked yet, was registered.
+type gitCookies map[Cookie]time.Time
+
+// RegisterInterface registers unknown in the process-wide Global Interface
+// Table (GIT) and returns a cookie that can be passed to other goroutines.
+// Registration happens on the shim's thread, which should be the thread that
+// obtained unknown, typically within a call to Run. The GIT holds its own
+// reference to unknown until the cookie is revoked with RevokeInterface.
+func (s *Shim) RegisterInterface(unknown *ole.IUnknown) (Cookie, error) {
+	var cookie Cookie
+	err := s.Run(func() (err error) {
+		cookie, err = gitRegisterInterface(unknown)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	s.signalAccess.Lock()
+	if s.gitCookies == nil {
+		s.gitCookies = make(gitCookies)
+	}
+	if _, ok := s.gitCookies[cookie]; !ok {
+		s.gitCount.Add(1)
+	}
+	s.gitCookies[cookie] = s.now()
+	s.signalAccess.Unlock()
+	return cookie, nil
+}
+
+// GetInterface returns a pointer to the interface registered under cookie that
+// is valid in the apartment of the calling thread, marshaling it if
+// necessary. The caller must release it when done.
+//
+// GetInterface runs on the calling goroutine rather than on the shim's
+// thread. Unless the caller has locked its goroutine to a thread with its own
+// apartment, the result belongs to the multithreaded apartment, which the
+// shim keeps alive for the duration of the call. It must be kept alive, for
+// example with Add, for as long as the result is in use.
+func (s *Shim) GetInterface(cookie Cookie) (*ole.IUnknown, error) {
+	var unknown *ole.IUnknown
+	err := s.With(func() (err error) {
+		unknown, err = gitGetInterface(cookie)
+		return err
+	})
+	return unknown, err
+}
+
+// RevokeInterface removes the interface registered under cookie from the
+// Global Interface Table and releases the table's reference to it.
+func (s *Shim) RevokeInterface(cookie Cookie) error {
+	err := s.Run(func() error {
+		return revokeInterface(cookie)
+	})
+	if err != nil {
+		return err
+	}
+	s.signalAccess.Lock()
+	s.forgetCookie(cookie)
+	s.signalAccess.Unlock()
+	return nil
+}
+
+// WithAutoRevokeGIT sets whether the interfaces the shim registered in the
+// Global Interface Table, and that are still registered when the apartment
+// comes down, are revoked before COM is uninitialized. It is on by default;
+// turn it off if cookies are handed to code that outlives the apartment and
+// revokes them itself.
+func WithAutoRevokeGIT(revoke bool) Option {
+	return func(c *config) {
+		c.keepGIT = !revoke
+	}
+}
+
+// revokeInterface is gitRevokeInterface, unless replaced by a test.
+var revokeInterface = gitRevokeInterface
+
+// forgetCookie stops tracking cookie. It must be called while holding
+// signalAccess.
+func (s *Shim) forgetCookie(cookie Cookie) {
+	if _, ok := s.gitCookies[cookie]; ok {
+		delete(s.gitCookies, cookie)
+		s.gitCount.Add(-1)
+	}
+}
+
+// revokeGIT revokes the interfaces that are still registered in the GIT,
+// unless disabled with WithAutoRevokeGIT. It must be called while holding
+// signalAccess, from a thread that is still initialized for COM.
+func (s *Shim) revokeGIT() {
+	if s.settings().keepGIT {
+		return
+	}
+	for cookie := range s.gitCookies {
+		if err := revokeInterface(cookie); err != nil {
+			s.log().Warn("comshim: revoking an interface registered in the GIT failed", "cookie", cookie, "error", err)
+		}
+		s.forgetCookie(cookie)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package comshim
+
+import "github.com/go-ole/go-ole"
+
+func gitRegisterInterface(unknown *ole.IUnknown) (Cookie, error) {
+	return 0, ole.NewError(ole.E_NOTIMPL)
+}
+
+func gitGetInterface(cookie Cookie) (*ole.IUnknown, error) {
+	return nil, ole.NewError(ole.E_NOTIMPL)
+}
+
+func gitRevokeInterface(cookie Cookie) error {
+	return ole.NewError(ole.E_NOTIMPL)
+}
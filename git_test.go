@@ -0,0 +1,55 @@
+package comshim
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRevokeGITAtTeardown(t *testing.T) {
+	defer func() { revokeInterface = gitRevokeInterface }()
+	for _, auto := range []bool{true, false} {
+		var m sync.Mutex
+		revoked := make(map[Cookie]int)
+		revokeInterface = func(cookie Cookie) error {
+			m.Lock()
+			revoked[cookie]++
+			m.Unlock()
+			return nil
+		}
+
+		s := New(WithAutoRevokeGIT(auto))
+		s.Add(1)
+		// Registering needs a real GIT, so the cookies are recorded as
+		// RegisterInterface would
+		s.signalAccess.Lock()
+		s.gitCookies = make(gitCookies)
+		for cookie := Cookie(1); cookie <= 3; cookie++ {
+			s.gitCookies[cookie] = s.now()
+			s.gitCount.Add(1)
+		}
+		s.signalAccess.Unlock()
+		if err := s.RevokeInterface(2); err != nil {
+			t.Fatal(err)
+		}
+		if n := s.Stats().GITCookies; n != 2 {
+			t.Errorf("Stats reports %d GIT cookies after revoking one of three, want 2", n)
+		}
+		s.Done()
+		s.WaitDone()
+
+		want := map[Cookie]int{2: 1}
+		if auto {
+			want = map[Cookie]int{1: 1, 2: 1, 3: 1}
+		}
+		m.Lock()
+		for cookie := Cookie(1); cookie <= 3; cookie++ {
+			if revoked[cookie] != want[cookie] {
+				t.Errorf("with auto revoke %v, cookie %d was revoked %d times, want %d", auto, cookie, revoked[cookie], want[cookie])
+			}
+		}
+		m.Unlock()
+		if n, want := s.Stats().GITCookies, int64(3-len(want)); n != want {
+			t.Errorf("with auto revoke %v, Stats reports %d GIT cookies after teardown, want %d", auto, n, want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+//go:build windows
+
+package comshim
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+)
+
+var (
+	clsidStdGlobalInterfaceTable = ole.NewGUID("{00000323-0000-0000-C000-000000000046}")
+	iidIGlobalInterfaceTable     = ole.NewGUID("{00000146-0000-0000-C000-000000000046}")
+)
+
+type iGlobalInterfaceTable struct {
+	vtbl *iGlobalInterfaceTableVtbl
+}
+
+type iGlobalInterfaceTableVtbl struct {
+	ole.IUnknownVtbl
+	RegisterInterfaceInGlobal uintptr
+	RevokeInterfaceFromGlobal uintptr
+	GetInterfaceFromGlobal    uintptr
+}
+
+// globalInterfaceTable calls fn with the process-wide Global Interface Table.
+// The calling thread must be initialized for COM.
+func globalInterfaceTable(fn func(git *iGlobalInterfaceTable) error) error {
+	unknown, err := ole.CreateInstance(clsidStdGlobalInterfaceTable, iidIGlobalInterfaceTable)
+	if err != nil {
+		return err
+	}
+	defer unknown.Release()
+	return fn((*iGlobalInterfaceTable)(unsafe.Pointer(unknown)))
+}
+
+func gitRegisterInterface(unknown *ole.IUnknown) (cookie Cookie, err error) {
+	err = globalInterfaceTable(func(git *iGlobalInterfaceTable) error {
+		hr, _, _ := syscall.SyscallN(
+			git.vtbl.RegisterInterfaceInGlobal,
+			uintptr(unsafe.Pointer(git)),
+			uintptr(unsafe.Pointer(unknown)),
+			uintptr(unsafe.Pointer(ole.IID_IUnknown)),
+			uintptr(unsafe.Pointer(&cookie)))
+		return hresultError(hr)
+	})
+	return cookie, err
+}
+
+func gitGetInterface(cookie Cookie) (unknown *ole.IUnknown, err error) {
+	err = globalInterfaceTable(func(git *iGlobalInterfaceTable) error {
+		hr, _, _ := syscall.SyscallN(
+			git.vtbl.GetInterfaceFromGlobal,
+			uintptr(unsafe.Pointer(git)),
+			uintptr(cookie),
+			uintptr(unsafe.Pointer(ole.IID_IUnknown)),
+			uintptr(unsafe.Pointer(&unknown)))
+		return hresultError(hr)
+	})
+	return unknown, err
+}
+
+func gitRevokeInterface(cookie Cookie) error {
+	return globalInterfaceTable(func(git *iGlobalInterfaceTable) error {
+		hr, _, _ := syscall.SyscallN(
+			git.vtbl.RevokeInterfaceFromGlobal,
+			uintptr(unsafe.Pointer(git)),
+			uintptr(cookie))
+		return hresultError(hr)
+	})
+}
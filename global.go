@@ -1,6 +1,24 @@
 package comshim
 
-var global = New()
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var (
+	globalOnce sync.Once
+	globalShim *Shim
+)
+
+// global returns the process-wide shim used by the package-level functions,
+// creating it on first use.
+func global() *Shim {
+	globalOnce.Do(func() {
+		globalShim = New()
+	})
+	return globalShim
+}
 
 // Add adds delta, which may be negative, to the counter of a global shim. As
 // long as the counter is greater than zero, at least one thread is guaranteed
@@ -13,7 +31,7 @@ var global = New()
 //
 // If the shim cannot be created for some reason, Add panics.
 func Add(delta int) {
-	global.Add(delta)
+	global().Add(delta)
 }
 
 // TryAdd adds delta, which may be negative, to the counter of a global shim. As
@@ -27,15 +45,64 @@ func Add(delta int) {
 //
 // If the shim cannot be created for some reason, TryAdd returns an error.
 func TryAdd(delta int) error {
-	return global.TryAdd(delta)
+	return global().TryAdd(delta)
+}
+
+// TryAddContext is like TryAdd, but gives up waiting for the global shim to
+// start if ctx is done first. In that case delta is taken back off the counter
+// and ctx.Err() is returned.
+func TryAddContext(ctx context.Context, delta int) error {
+	return global().TryAddContext(ctx, delta)
 }
 
 // Done decrements the counter of a global shim.
+//
+// If the counter is already zero, Done panics.
 func Done() {
-	global.Done()
+	global().Done()
+}
+
+// TryDone decrements the counter of a global shim. Unlike Done it never panics:
+// if the counter is already zero it is left unchanged and ErrNegativeCounter
+// is returned.
+func TryDone() error {
+	return global().TryDone()
+}
+
+// Do adds one to the counter of a global shim, calls fn and then calls Done,
+// even if fn panics. It returns the error from fn, or the error that prevented
+// the shim from starting.
+func Do(fn func() error) error {
+	return global().With(fn)
 }
 
-// Waits all go routines to be terminated
+// WaitDone waits for the goroutine of the global shim to terminate, which
+// happens once its counter has dropped to zero.
 func WaitDone() {
-	global.WaitDone()
+	global().WaitDone()
+}
+
+// WaitDoneContext is like WaitDone, but gives up once ctx is done, in which
+// case it returns ctx.Err().
+func WaitDoneContext(ctx context.Context) error {
+	return global().WaitDoneContext(ctx)
+}
+
+// WaitDoneTimeout is like WaitDone, but gives up after d, in which case it
+// returns context.DeadlineExceeded.
+func WaitDoneTimeout(d time.Duration) error {
+	return global().WaitDoneTimeout(d)
+}
+
+// WaitZero blocks until the counter of the global shim is zero, or until ctx
+// is done, in which case it returns ctx.Err(). See Shim.WaitZero.
+func WaitZero(ctx context.Context) error {
+	return global().WaitZero(ctx)
+}
+
+// PinCurrentGoroutine locks the calling goroutine to its OS thread and
+// initializes COM on it for apt, counting the goroutine as one of the global
+// shim's until unpin is called. See Shim.PinCurrentGoroutine.
+func PinCurrentGoroutine(apt ApartmentType) (unpin func(), err error) {
+	return global().PinCurrentGoroutine(apt)
 }
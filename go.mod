@@ -5,6 +5,5 @@ go 1.20
 require (
 	github.com/go-ole/go-ole v1.3.0
 	go.uber.org/goleak v1.3.0
+	golang.org/x/sys v0.22.0
 )
-
-require golang.org/x/sys v0.22.0 // indirect
@@ -0,0 +1,66 @@
+// Package group ties the lifetime of a comshim.Shim to groups of goroutines
+// such as golang.org/x/sync/errgroup, without depending on them:
+//
+//	g, ctx := errgroup.WithContext(ctx)
+//	stop := group.DrainOnCancel(ctx, s)
+//	defer stop()
+//	for _, host := range hosts {
+//		host := host
+//		group.Go(g, s, func() error {
+//			return scan(ctx, host) // COM stays initialized until it returns
+//		})
+//	}
+//	err := g.Wait()
+//
+// Every function started with Go holds a reference to the shim while it runs.
+// Once the group's context is cancelled, whether because a function failed,
+// the process received a signal or Wait returned, the shim is drained: the
+// functions still running finish, no new references are handed out, and the
+// shim is closed when the last one is given back.
+package group
+
+import (
+	"context"
+	"sync"
+
+	"github.com/NozomiNetworks/go-comshim"
+)
+
+// Goer is a group of goroutines. *errgroup.Group implements it.
+type Goer interface {
+	Go(fn func() error)
+}
+
+// Go starts fn in g, with a reference to s held from before it starts until
+// it returns, so that COM is initialized for as long as fn runs. If s can't
+// be started, or is draining, the resulting error is reported to g as fn's
+// error and fn isn't called.
+func Go(g Goer, s *comshim.Shim, fn func() error) {
+	if err := s.TryAdd(1); err != nil {
+		g.Go(func() error { return err })
+		return
+	}
+	g.Go(func() error {
+		defer s.Done()
+		return fn()
+	})
+}
+
+// DrainOnCancel drains s once ctx is done (see comshim.Shim.Drain). Calling
+// the returned function before then, or closing s, stops watching ctx.
+// It doesn't wait for the drain to finish.
+func DrainOnCancel(ctx context.Context, s *comshim.Shim) (stop func()) {
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Drain(context.Background())
+		case <-stopped:
+		case <-s.Closed():
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stopped) })
+	}
+}
@@ -0,0 +1,81 @@
+package group_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NozomiNetworks/go-comshim"
+	"github.com/NozomiNetworks/go-comshim/group"
+)
+
+// errGroup is a minimal stand-in for errgroup.Group.
+type errGroup struct {
+	wg     sync.WaitGroup
+	once   sync.Once
+	err    error
+	cancel func()
+}
+
+func (g *errGroup) Go(fn func() error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if err := fn(); err != nil {
+			g.once.Do(func() {
+				g.err = err
+				g.cancel()
+			})
+		}
+	}()
+}
+
+func (g *errGroup) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	return g.err
+}
+
+func TestGo(t *testing.T) {
+	s := comshim.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	g := &errGroup{cancel: cancel}
+	stop := group.DrainOnCancel(ctx, s)
+	defer stop()
+
+	errFn := errors.New("fn failed")
+	for i := 0; i < 10; i++ {
+		i := i
+		group.Go(g, s, func() error {
+			if !s.IsRunning() {
+				t.Error("function started in the group runs without the shim")
+			}
+			if i == 5 {
+				return errFn
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != errFn {
+		t.Errorf("Wait returned %v, want %v", err, errFn)
+	}
+
+	// The cancelled context drains the shim in the background, which closes
+	// it once idle
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := s.TryAdd(1)
+		if err == comshim.ErrClosed {
+			break
+		}
+		if err == nil {
+			s.Done()
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("TryAdd after the group's context was cancelled returned %v, want %v", err, comshim.ErrClosed)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,108 @@
+package comshim
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sync/atomic"
+)
+
+// Guard represents a single reference to a shim. It is returned by Acquire
+// and must be released exactly once with Release, which makes it harder to
+// leak or double-release references than with Add and Done.
+type Guard struct {
+	shim       *Shim
+	generation uint64 // The generation of the shim when the guard was acquired
+	released   atomic.Bool
+}
+
+// Acquire adds one to the counter for the shim and returns a guard that takes
+// it away again when released.
+//
+// If the shim cannot be created for some reason, Acquire panics.
+func (s *Shim) Acquire() *Guard {
+	g, err := s.TryAcquire()
+	if err != nil {
+		s.handleError(err)
+		g = &Guard{shim: s} // Holds nothing, so releasing it does nothing
+		g.released.Store(true)
+	}
+	return g
+}
+
+// TryAcquire adds one to the counter for the shim and returns a guard that
+// takes it away again when released.
+//
+// If the shim cannot be created for some reason, TryAcquire returns an error.
+func (s *Shim) TryAcquire() (*Guard, error) {
+	if err := s.unowned(); err != nil {
+		return nil, err
+	}
+	g := &Guard{shim: s}
+	if err := s.tryAddContext(context.Background(), 1, g, false); err != nil {
+		return nil, err
+	}
+	g.generation = s.generation.Load() // Can't change while the guard is held
+	runtime.SetFinalizer(g, (*Guard).leaked)
+	return g, nil
+}
+
+// Release gives the guard's reference back to the shim. Only the first call
+// has any effect; later calls are logged and otherwise ignored.
+//
+// If the counter has dropped to zero since the guard was acquired, the
+// reference is not given back, and ErrStaleGeneration is handled as decided
+// by WithErrorHandler, which panics by default.
+func (g *Guard) Release() {
+	if err := g.TryRelease(); err != nil {
+		g.shim.handleError(err)
+	}
+}
+
+// TryRelease is like Release, but returns an error wrapping
+// ErrStaleGeneration instead of handling it.
+func (g *Guard) TryRelease() error {
+	if g.released.Swap(true) {
+		g.shim.log().Debug("comshim: guard released more than once")
+		return nil
+	}
+	runtime.SetFinalizer(g, nil)
+	if current := g.shim.generation.Load(); current != g.generation {
+		return fmt.Errorf("%w: acquired in generation %d, released in generation %d",
+			ErrStaleGeneration, g.generation, current)
+	}
+	g.shim.done(g)
+	return nil
+}
+
+// Generation returns the generation of the shim at the time the guard was
+// acquired. See Shim.Generation.
+func (g *Guard) Generation() uint64 {
+	return g.generation
+}
+
+// Close is like TryRelease, so that a guard can be used as an io.Closer.
+func (g *Guard) Close() error {
+	return g.TryRelease()
+}
+
+// NewRef adds one to the counter for the shim and returns a handle that takes
+// it away again when closed. Closing the handle more than once has no further
+// effect. The handle is a *Guard, which lets the shim be managed by helpers
+// built around io.Closer.
+//
+// If the shim cannot be created for some reason, NewRef returns an error.
+func (s *Shim) NewRef() (io.Closer, error) {
+	g, err := s.TryAcquire()
+	if err != nil {
+		return nil, err // Not a nil *Guard, which would be a non-nil io.Closer
+	}
+	return g, nil
+}
+
+// leaked is called by the garbage collector when a guard that was never
+// released becomes unreachable. Its reference can never be given back.
+func (g *Guard) leaked() {
+	g.shim.log().Warn("comshim: guard was garbage collected without being released")
+}
@@ -0,0 +1,98 @@
+package comshim_test
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/NozomiNetworks/go-comshim"
+)
+
+const leakedGuardMessage = "comshim: guard was garbage collected without being released"
+
+func hasMessage(msgs []string, want string) bool {
+	for _, msg := range msgs {
+		if msg == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGuardReleaseIsIdempotent(t *testing.T) {
+	s := comshim.New()
+	defer s.WaitDone()
+
+	g := s.Acquire()
+	if count := s.Count(); count != 1 {
+		t.Errorf("counter is %d after Acquire, want 1", count)
+	}
+	g.Release()
+	g.Release()
+	if count := s.Count(); count != 0 {
+		t.Errorf("counter is %d after releasing twice, want 0", count)
+	}
+}
+
+func TestGuardLeakIsDetected(t *testing.T) {
+	s := comshim.New()
+	logger := &recordingLogger{}
+	s.SetLogger(logger)
+
+	func() { s.Acquire() }() // Leaked on purpose
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !hasMessage(logger.Messages(), leakedGuardMessage) {
+		if time.Now().After(deadline) {
+			t.Fatalf("leaked guard was not reported: %q", logger.Messages())
+		}
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Clean up the reference the guard leaked
+	s.Done()
+	s.WaitDone()
+}
+
+func TestNewRef(t *testing.T) {
+	s := comshim.New()
+	defer s.WaitDone()
+
+	ref, err := s.NewRef()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := s.Count(); count != 1 {
+		t.Errorf("counter is %d with a reference open, want 1", count)
+	}
+	for i := 0; i < 2; i++ {
+		if err := ref.Close(); err != nil {
+			t.Errorf("Close returned %v", err)
+		}
+	}
+	if count := s.Count(); count != 0 {
+		t.Errorf("counter is %d after closing twice, want 0", count)
+	}
+}
+
+func TestGuardFromPreviousGeneration(t *testing.T) {
+	s := comshim.New(comshim.WithNegativeCounterErrors())
+	defer s.WaitDone()
+
+	g := s.Acquire()
+	s.Done() // An extra release by someone else ends the generation
+	s.Add(1)
+	defer s.Done()
+
+	if g.Generation() == s.Generation() {
+		t.Fatalf("generation %d didn't change when the counter rose from zero again", s.Generation())
+	}
+	if err := g.TryRelease(); !errors.Is(err, comshim.ErrStaleGeneration) {
+		t.Errorf("TryRelease of a stale guard returned %v, want %v", err, comshim.ErrStaleGeneration)
+	}
+	if count := s.Count(); count != 1 {
+		t.Errorf("counter is %d after releasing a stale guard, want 1", count)
+	}
+}
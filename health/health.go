@@ -0,0 +1,82 @@
+// Package health serves the state of a comshim.Shim to HTTP health checks,
+// so that the core package doesn't have to import net/http:
+//
+//	http.Handle("/healthz", health.HealthHandler(s))
+//
+// A GET answers 200 OK while the shim is Healthy and 503 Service Unavailable
+// otherwise, with the shim's comshim.Report, as returned by DebugReport, as
+// the JSON body. The shim's
+// thread isn't involved unless asked for with the ping query parameter, as in
+// /healthz?ping=1 or /healthz?ping=250ms, in which case the thread must also
+// answer a Ping in time.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/NozomiNetworks/go-comshim"
+)
+
+// DefaultPingTimeout is how long the thread has to answer a ping whose
+// timeout isn't given as a duration.
+const DefaultPingTimeout = time.Second
+
+// Status is the JSON body served by HealthHandler.
+type Status struct {
+	Healthy bool
+	Error   string `json:",omitempty"` // Why the shim isn't healthy
+	Report  comshim.Report
+}
+
+// HealthHandler returns a handler reporting the health of s. The shim is
+// healthy if s.Healthy reports so and, if a ping was asked for, its thread
+// answers in time. An earlier failure that a later start recovered from
+// doesn't count.
+func HealthHandler(s *comshim.Shim) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := check(r, s)
+		st := Status{Healthy: err == nil, Report: s.DebugReport()}
+		code := http.StatusOK
+		if err != nil {
+			st.Error = err.Error()
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(st) // The status code has been sent already
+	})
+}
+
+// check returns why s isn't healthy, or nil.
+func check(r *http.Request, s *comshim.Shim) error {
+	if !s.Healthy() {
+		select {
+		case <-s.Closed():
+			return comshim.ErrClosed
+		default:
+		}
+		if err := s.Err(); err != nil {
+			return err
+		}
+		// The shim has recovered in the meantime
+	}
+	ping := r.URL.Query().Get("ping")
+	if ping == "" {
+		return nil
+	}
+	timeout, err := time.ParseDuration(ping)
+	if err != nil || timeout <= 0 {
+		timeout = DefaultPingTimeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	if err := s.Ping(ctx); !errors.Is(err, comshim.ErrStopped) {
+		return err
+	}
+	return nil // There is no thread to ping until the shim is used
+}
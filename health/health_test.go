@@ -0,0 +1,96 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/NozomiNetworks/go-comshim"
+	"github.com/NozomiNetworks/go-comshim/comshimtest"
+	"github.com/NozomiNetworks/go-comshim/health"
+)
+
+// get requests target from h and decodes the status it serves.
+func get(t *testing.T, h http.Handler, target string) (int, health.Status) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	var st health.Status
+	if err := json.NewDecoder(rec.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, st
+}
+
+func TestHealthHandlerHealthy(t *testing.T) {
+	s := comshim.New()
+	defer s.WaitDone()
+	s.Add(1)
+	defer s.Done()
+
+	h := health.HealthHandler(s)
+	for _, target := range []string{"/healthz", "/healthz?ping=1", "/healthz?ping=5s"} {
+		code, st := get(t, h, target)
+		if code != http.StatusOK || !st.Healthy || st.Error != "" {
+			t.Errorf("GET %s answered %d with %+v, want a healthy 200", target, code, st)
+		}
+		if st.Report.State != "running" || st.Report.Count != 1 {
+			t.Errorf("GET %s reported %+v for a running shim", target, st.Report)
+		}
+	}
+}
+
+func TestHealthHandlerUnhealthy(t *testing.T) {
+	init := comshimtest.NewInitializer()
+	init.FailNext(errors.New("no COM for you"))
+	s := comshim.New(comshim.WithInitializer(init))
+	defer s.WaitDone()
+	if err := s.TryAdd(1); err == nil {
+		t.Fatal("TryAdd succeeded despite the failing initializer")
+	}
+
+	h := health.HealthHandler(s)
+	code, st := get(t, h, "/healthz")
+	if code != http.StatusServiceUnavailable || st.Healthy || st.Error == "" {
+		t.Errorf("failed shim answered %d with %+v, want an unhealthy 503", code, st)
+	}
+
+	s = comshim.New()
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	code, st = get(t, health.HealthHandler(s), "/healthz")
+	if code != http.StatusServiceUnavailable || st.Healthy || st.Report.State != "closed" {
+		t.Errorf("closed shim answered %d with %+v, want an unhealthy 503", code, st)
+	}
+}
+
+func TestHealthHandlerRecovered(t *testing.T) {
+	init := comshimtest.NewInitializer()
+	init.FailNext(errors.New("transient"))
+	s := comshim.New(comshim.WithInitializer(init))
+	defer s.WaitDone()
+	h := health.HealthHandler(s)
+
+	if err := s.TryAdd(1); err == nil {
+		t.Fatal("TryAdd succeeded despite the failing initializer")
+	}
+	if code, st := get(t, h, "/healthz"); code != http.StatusServiceUnavailable || st.Healthy {
+		t.Errorf("failed shim answered %d with %+v, want an unhealthy 503", code, st)
+	}
+
+	// The next start succeeds, while Err still reports the earlier failure
+	if err := s.TryAdd(1); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Done()
+	if s.Err() == nil {
+		t.Fatal("Err doesn't report the earlier failure")
+	}
+	if code, st := get(t, h, "/healthz?ping=1"); code != http.StatusOK || !st.Healthy || st.Error != "" {
+		t.Errorf("recovered shim answered %d with %+v, want a healthy 200", code, st)
+	}
+}
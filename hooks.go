@@ -0,0 +1,132 @@
+package comshim
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// WithInitHook adds a function to call on the shim's thread every time it has
+// been initialized for COM, before any function passed to Run. It is the
+// place to call CoInitializeSecurity with settings WithSecurity doesn't cover,
+// to create objects that live as long as the thread, or to register class
+// objects. Hooks are called in the order they were added.
+//
+// If a hook returns an error, the remaining hooks are skipped, COM is
+// uninitialized and the start fails with that error, which TryAdd returns. A
+// hook that panics fails the start the same way, with the panic recovered as
+// a *PanicError. Before COM is uninitialized, the teardown hooks matching the
+// init hooks that succeeded are called, so that their work is undone.
+//
+// A shim created by NewMTAUsage that holds an MTA usage cookie has no thread
+// of its own. Its hooks are called on the goroutine that starts the shim, with
+// its OS thread locked, which is then in the implicit MTA.
+func WithInitHook(fn func() error) Option {
+	return func(c *config) {
+		c.initHooks = append(c.initHooks, fn)
+	}
+}
+
+// runInitHooks creates the event set by WithNamedEvent and calls the
+// functions added with WithInitHook. If either fails, the event is closed
+// again.
+func (s *Shim) runInitHooks() error {
+	if err := s.holdNamedEvent(); err != nil {
+		return err
+	}
+	for i, fn := range s.settings().initHooks {
+		if err := callInitHook(fn); err != nil {
+			s.log().Error("comshim: init hook failed", "error", err)
+			s.undoInitHooks(i)
+			s.dropNamedEvent()
+			return err
+		}
+	}
+	return nil
+}
+
+// undoInitHooks calls the teardown hooks matching the first n init hooks, in
+// reverse order, once a later init hook has failed.
+func (s *Shim) undoInitHooks(n int) {
+	hooks := s.settings().teardownHooks
+	if n > len(hooks) {
+		n = len(hooks)
+	}
+	for i := n - 1; i >= 0; i-- {
+		s.callTeardownHook(hooks[i])
+	}
+}
+
+// callInitHook calls fn, converting a panic into a *PanicError.
+func callInitHook(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return fn()
+}
+
+// WithTeardownHook adds a function to call on the shim's thread every time it
+// is about to be uninitialized, after the counter has dropped to zero and the
+// queued functions have run, but before tracked objects are released and COM
+// is uninitialized. It is the place to release objects created by an init
+// hook, to unadvise sinks and to revoke registrations. Hooks are called in the
+// reverse order they were added, so that each undoes the work of the init hook
+// added in the same position. If an init hook fails, only the hooks matching
+// the init hooks before it are called.
+//
+// A hook that panics is recovered and logged, and the remaining hooks are
+// still called.
+//
+// The hooks are called while the shim is stopping, and must not call Run or
+// any other method of the shim that waits for its thread. A shim holding an
+// MTA usage cookie calls them before releasing it, on the goroutine that
+// releases it, with its OS thread locked.
+func WithTeardownHook(fn func()) Option {
+	return func(c *config) {
+		c.teardownHooks = append(c.teardownHooks, fn)
+	}
+}
+
+// runTeardownHooks calls the functions added with WithTeardownHook, unless
+// they have already been called for the thread that p belongs to. The debt is
+// cleared first, so that a panicking hook isn't called again by recoverPanic.
+func (s *Shim) runTeardownHooks(p *progress) {
+	if p.tornDown {
+		return
+	}
+	p.tornDown = true
+	s.callTeardownHooks()
+}
+
+// callTeardownHooks calls the functions added with WithTeardownHook, then
+// closes the event held for WithNamedEvent.
+func (s *Shim) callTeardownHooks() {
+	defer s.dropNamedEvent()
+	for i := len(s.settings().teardownHooks) - 1; i >= 0; i-- {
+		s.callTeardownHook(s.settings().teardownHooks[i])
+	}
+}
+
+// callTeardownHook calls fn, logging a panic rather than letting it skip the
+// hooks that remain.
+func (s *Shim) callTeardownHook(fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.log().Error("comshim: teardown hook panicked", "panic", r)
+		}
+	}()
+	fn()
+}
+
+// teardownMTAUsage calls the teardown hooks of a shim holding an MTA usage
+// cookie, which has no thread of its own.
+func (s *Shim) teardownMTAUsage() {
+	if len(s.settings().teardownHooks) == 0 {
+		s.dropNamedEvent()
+		return
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	s.callTeardownHooks()
+}
@@ -0,0 +1,101 @@
+package comshim
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NozomiNetworks/go-comshim/internal/clock"
+)
+
+func TestIdleTimeoutReusesThread(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	s := New(WithIdleTimeout(timeout))
+
+	for i := 0; i < 3; i++ {
+		s.Add(1)
+		s.Done()
+		if !s.IsRunning() {
+			t.Fatal("shim released its thread before the idle timeout expired")
+		}
+	}
+	if starts := s.starts.Value(); starts != 1 {
+		t.Errorf("shim started %d times, want 1", starts)
+	}
+
+	begin := time.Now()
+	s.WaitDone()
+	if elapsed := time.Since(begin); elapsed < timeout/2 {
+		t.Errorf("shim released its thread after %v, want about %v", elapsed, timeout)
+	}
+	if s.IsRunning() {
+		t.Error("shim is still running after the idle timeout expired")
+	}
+}
+
+func TestHysteresis(t *testing.T) {
+	const period = time.Minute
+	const cycles = 20
+	c := clock.NewFake()
+	s := New(WithHysteresis(period), withClock(c))
+
+	// The counter keeps dipping to zero for much less than the period
+	for i := 0; i < cycles; i++ {
+		s.Add(2)
+		s.Done()
+		s.Done()
+		c.Advance(time.Second)
+	}
+	if st := s.Stats(); st.Starts != 1 || st.TeardownsAvoided != cycles-1 {
+		t.Errorf("oscillating shim started %d times and avoided %d teardowns, want 1 and %d", st.Starts, st.TeardownsAvoided, cycles-1)
+	}
+
+	// A sustained idle period releases the thread, and nothing shorter does
+	c.WaitTimers(1)
+	c.Advance(period - 2*time.Second)
+	if !s.IsRunning() {
+		t.Fatal("shim released its thread before the period was over")
+	}
+	c.Advance(time.Second)
+	s.WaitDone()
+
+	s.Add(1)
+	s.Done()
+	c.WaitTimers(1)
+	c.Advance(period)
+	s.WaitDone()
+	if st := s.Stats(); st.Starts != 2 || st.TeardownsAvoided != cycles-1 {
+		t.Errorf("after a sustained idle period, shim started %d times and avoided %d teardowns, want 2 and %d", st.Starts, st.TeardownsAvoided, cycles-1)
+	}
+}
+
+func TestTeardownPolicyRace(t *testing.T) {
+	for _, policy := range []TeardownPolicy{TeardownRestart, TeardownReuse} {
+		s := New(WithTeardownPolicy(policy))
+
+		// Every Run crosses zero on its own, racing with the release of the
+		// thread started by the others
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 200; j++ {
+					if err := s.Run(func() error { return nil }); err != nil {
+						t.Errorf("Run with policy %d returned %v", policy, err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		s.WaitDone()
+
+		if s.IsRunning() || s.Count() != 0 {
+			t.Errorf("shim with policy %d is running %v with count %d after WaitDone", policy, s.IsRunning(), s.Count())
+		}
+		if starts, cycles := s.starts.Value(), int64(4*200); starts < 1 || starts > cycles {
+			t.Errorf("shim with policy %d started %d times for %d cycles", policy, starts, cycles)
+		}
+	}
+}
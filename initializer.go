@@ -0,0 +1,35 @@
+package comshim
+
+// Initializer stands in for the functions a shim uses to initialize and
+// uninitialize COM on its threads, CoInitializeEx and CoUninitialize or their
+// Windows Runtime counterparts. The comshimtest package provides one that
+// simulates failures, so that code using a shim can test its error handling
+// without a real COM stack.
+type Initializer interface {
+	// Initialize initializes the calling thread for the apartment selected by
+	// coinit, the flags passed to CoInitializeEx. Like CoInitializeEx it
+	// returns an *ole.OleError with the code S_FALSE if the thread had
+	// already been initialized, in which case Uninitialize is still called.
+	Initialize(coinit uint32) error
+
+	// Uninitialize undoes a call to Initialize on the calling thread.
+	Uninitialize()
+}
+
+// ApartmentReporter may be implemented by an Initializer to stand in for
+// CoGetApartmentType as well.
+type ApartmentReporter interface {
+	// ApartmentType returns the apartment of the calling thread.
+	ApartmentType() (ApartmentType, error)
+}
+
+// WithInitializer makes the shim initialize and uninitialize its threads with
+// i instead of calling COM. It is meant for tests. The shim doesn't check
+// which apartment its thread ended up in when starting it while i is in use,
+// but AddStrict and VerifyApartment do, asking i if it implements
+// ApartmentReporter.
+func WithInitializer(i Initializer) Option {
+	return func(c *config) {
+		c.initializer = i
+	}
+}
@@ -0,0 +1,190 @@
+//go:build windows && integration
+
+// The tests in this file exercise the shim against the real COM runtime of
+// the machine, including components that may not be installed everywhere.
+// Run them with
+//
+//	go test -tags integration -race .
+
+package comshim_test
+
+import (
+	"errors"
+	"testing"
+	"unsafe"
+
+	"github.com/NozomiNetworks/go-comshim"
+	"github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+	"golang.org/x/sys/windows"
+)
+
+const (
+	coENotInitialized           = 0x800401F0 // CO_E_NOTINITIALIZED
+	aptTypeQualifierImplicitMTA = 1          // APTTYPEQUALIFIER_IMPLICIT_MTA
+)
+
+var procCoGetApartmentType = windows.NewLazySystemDLL("ole32.dll").NewProc("CoGetApartmentType")
+
+// uninitialized reports an error unless the calling thread is no longer
+// initialized for COM. A thread that merely belongs to the implicit MTA,
+// because some other thread of the process keeps the MTA alive, counts as
+// uninitialized.
+func uninitialized() error {
+	var apt, qualifier int32
+	hr, _, _ := procCoGetApartmentType.Call(uintptr(unsafe.Pointer(&apt)), uintptr(unsafe.Pointer(&qualifier)))
+	if uint32(hr) == coENotInitialized {
+		return nil
+	}
+	if hr == 0 && comshim.ApartmentType(apt) == comshim.ApartmentMTA && qualifier == aptTypeQualifierImplicitMTA {
+		return nil
+	}
+	return errors.New("thread is still initialized for COM after teardown: apartment " +
+		comshim.ApartmentType(apt).String() + ", " + ole.NewError(hr).String())
+}
+
+// comInitializer initializes threads with the real CoInitializeEx, and checks
+// with CoGetApartmentType that every thread is left uninitialized once the
+// shim is done with it. If preinitialize is set, it first initializes each
+// thread once more, as a DLL that doesn't clean up after itself would, so
+// that the shim's own call returns S_FALSE.
+type comInitializer struct {
+	preinitialize bool
+	teardown      chan error // Receives the result of the check after each teardown
+}
+
+func newCOMInitializer(preinitialize bool) *comInitializer {
+	return &comInitializer{preinitialize: preinitialize, teardown: make(chan error, 1)}
+}
+
+func (i *comInitializer) Initialize(coinit uint32) error {
+	if i.preinitialize {
+		if err := ole.CoInitializeEx(0, coinit); err != nil {
+			return err
+		}
+	}
+	return ole.CoInitializeEx(0, coinit)
+}
+
+func (i *comInitializer) Uninitialize() {
+	ole.CoUninitialize()
+	if i.preinitialize {
+		ole.CoUninitialize() // Cleaning up after the DLL
+	}
+	i.teardown <- uninitialized()
+}
+
+func TestIntegrationInitCycles(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		init := newCOMInitializer(false)
+		s := comshim.New(comshim.WithInitializer(init))
+		want := comshim.ApartmentMTA
+		if i%2 == 1 {
+			s = comshim.NewSTA(comshim.WithInitializer(init))
+			want = comshim.ApartmentSTA
+		}
+
+		s.Add(1)
+		if apt, err := s.VerifyApartment(); err != nil || apt != want {
+			t.Fatalf("cycle %d: VerifyApartment returned %v, %v; want %v", i, apt, err, want)
+		}
+		s.Done()
+		s.WaitDone()
+		if err := <-init.teardown; err != nil {
+			t.Fatalf("cycle %d: %v", i, err)
+		}
+	}
+}
+
+func TestIntegrationWMILocator(t *testing.T) {
+	s := comshim.New()
+	defer s.WaitDone()
+
+	err := s.Run(func() error {
+		unknown, err := oleutil.CreateObject("WbemScripting.SWbemLocator")
+		if err != nil {
+			return err
+		}
+		defer unknown.Release()
+		locator, err := unknown.QueryInterface(ole.IID_IDispatch)
+		if err != nil {
+			return err
+		}
+		defer locator.Release()
+
+		service, err := oleutil.CallMethod(locator, "ConnectServer", ".", `root\cimv2`)
+		if err != nil {
+			return err
+		}
+		return service.Clear()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIntegrationScriptingDictionaryOnSTA(t *testing.T) {
+	s := comshim.NewSTA()
+	s.Add(1)
+	defer s.WaitDone()
+	defer s.Done()
+
+	if apt, err := s.VerifyApartment(); err != nil {
+		t.Fatalf("VerifyApartment returned %v, %v", apt, err)
+	}
+	err := s.Run(func() error {
+		unknown, err := oleutil.CreateObject("Scripting.Dictionary")
+		if err != nil {
+			return err
+		}
+		defer unknown.Release()
+		dict, err := unknown.QueryInterface(ole.IID_IDispatch)
+		if err != nil {
+			return err
+		}
+		defer dict.Release()
+
+		if _, err := oleutil.CallMethod(dict, "Add", "key", "value"); err != nil {
+			return err
+		}
+		item, err := oleutil.GetProperty(dict, "Item", "key")
+		if err != nil {
+			return err
+		}
+		defer item.Clear()
+		if got := item.ToString(); got != "value" {
+			t.Errorf("Item returned %q, want %q", got, "value")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIntegrationPreinitializedThread(t *testing.T) {
+	init := newCOMInitializer(true)
+	s := comshim.New(comshim.WithInitializer(init))
+	if err := s.TryAdd(1); !errors.Is(err, comshim.ErrAlreadyInitialized) {
+		t.Errorf("TryAdd on a preinitialized thread returned %v, want %v", err, comshim.ErrAlreadyInitialized)
+	}
+	s.WaitDone()
+	if err := <-init.teardown; err != nil {
+		t.Fatal(err)
+	}
+
+	// Reusing the existing initialization still balances the shim's own call
+	init = newCOMInitializer(true)
+	s = comshim.New(comshim.WithInitializer(init), comshim.WithReuseExistingInit(true))
+	if err := s.TryAdd(1); err != nil {
+		t.Fatalf("TryAdd reusing the existing initialization returned %v", err)
+	}
+	if err := s.Run(func() error { return nil }); err != nil {
+		t.Errorf("Run reusing the existing initialization returned %v", err)
+	}
+	s.Done()
+	s.WaitDone()
+	if err := <-init.teardown; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,71 @@
+// Package clock holds the clock and timers of a comshim.Shim, and a fake
+// clock that only moves when told to. It is internal so that the fake can be
+// shared by comshim's own tests and the comshimtest package without making
+// the abstraction part of comshim's API.
+package clock
+
+import "time"
+
+// Clock tells the time for a shim: when the counter last dropped to zero, how
+// long the apartment has been up, how long the watchdog has been waiting and
+// when events happened.
+type Clock interface {
+	Now() time.Time
+}
+
+// Scheduler arms the timers of a shim: the idle timeout, the watchdog, the
+// backoff between start attempts, the start timeout, RunTimeout, the lifetime
+// set by WithMaxLifetime, DLL garbage collection, and the deadlines of
+// WithTaskTimeout and WaitDoneTimeout.
+type Scheduler interface {
+	// AfterFunc calls f in its own goroutine once d has elapsed, unless the
+	// returned timer is stopped first.
+	AfterFunc(d time.Duration, f func()) Timer
+
+	// NewTimer returns a timer that sends the time on its channel once d
+	// has elapsed.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a timer armed by a Scheduler. It behaves like a time.Timer.
+type Timer interface {
+	// C returns the channel on which the time is sent, or nil for a timer
+	// created by AfterFunc.
+	C() <-chan time.Time
+
+	// Stop prevents the timer from firing, and reports whether it did.
+	Stop() bool
+
+	// Reset makes the timer fire after d, and reports whether it had been
+	// active.
+	Reset(d time.Duration) bool
+}
+
+// Option returns a comshim.Option that makes a shim use c, and c as its
+// Scheduler too if it is one. It is set by the comshim package, which
+// comshimtest relies on to install a fake clock.
+var Option func(c Clock) any
+
+// System is the Clock and Scheduler of the time package.
+type System struct{}
+
+func (System) Now() time.Time {
+	return time.Now()
+}
+
+func (System) AfterFunc(d time.Duration, f func()) Timer {
+	return systemTimer{time.AfterFunc(d, f)}
+}
+
+func (System) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+// systemTimer is a Timer of the time package.
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
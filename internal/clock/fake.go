@@ -0,0 +1,146 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a Clock and Scheduler whose time only moves when Advance is called.
+type Fake struct {
+	m      sync.Mutex
+	armed  sync.Cond    // Broadcast when a timer is armed
+	now    time.Time    // The current time
+	timers []*fakeTimer // Armed timers
+}
+
+// epoch is the time a Fake starts at.
+var epoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// NewFake returns a fake clock set to midnight UTC on January 1, 2000.
+func NewFake() *Fake {
+	c := &Fake{now: epoch}
+	c.armed.L = &c.m
+	return c
+}
+
+// Now returns the current time of the clock.
+func (c *Fake) Now() time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d, firing the timers that become due
+// along the way in order. Functions passed to AfterFunc are called in
+// goroutines of their own, so they may still be running when Advance returns.
+func (c *Fake) Advance(d time.Duration) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	end := c.now.Add(d)
+	for len(c.timers) > 0 && !c.timers[0].when.After(end) {
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		t.armed = false
+		c.now = t.when
+		t.fire(c.now)
+	}
+	c.now = end
+}
+
+// WaitTimers blocks until at least n timers are armed, which lets a test wait
+// for a shim to arm the timer it wants to fire with Advance.
+func (c *Fake) WaitTimers(n int) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for len(c.timers) < n {
+		c.armed.Wait()
+	}
+}
+
+// AfterFunc implements Scheduler.
+func (c *Fake) AfterFunc(d time.Duration, f func()) Timer {
+	t := &fakeTimer{clock: c, f: f}
+	t.Reset(d)
+	return t
+}
+
+// NewTimer implements Scheduler.
+func (c *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// arm adds t to the armed timers, which must be called while holding m.
+func (c *Fake) arm(t *fakeTimer) {
+	t.armed = true
+	c.timers = append(c.timers, t)
+	sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].when.Before(c.timers[j].when) })
+	c.armed.Broadcast()
+}
+
+// disarm removes t from the armed timers, which must be called while holding
+// m.
+func (c *Fake) disarm(t *fakeTimer) {
+	for i, armed := range c.timers {
+		if armed == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			break
+		}
+	}
+	t.armed = false
+}
+
+// fakeTimer is a timer of a Fake. Its fields are protected by the m of its
+// clock.
+type fakeTimer struct {
+	clock *Fake
+	when  time.Time
+	armed bool
+	f     func()         // Called when the timer fires, for AfterFunc
+	c     chan time.Time // Receives the time when the timer fires, for NewTimer
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.m.Lock()
+	defer t.clock.m.Unlock()
+	armed := t.armed
+	if armed {
+		t.clock.disarm(t)
+	}
+	return armed
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.m.Lock()
+	defer t.clock.m.Unlock()
+	armed := t.armed
+	if armed {
+		t.clock.disarm(t)
+	}
+	if d <= 0 {
+		t.fire(t.clock.now)
+		return armed
+	}
+	t.when = t.clock.now.Add(d)
+	t.clock.arm(t)
+	return armed
+}
+
+// fire delivers the expiry of t at now, which must be called while holding
+// the m of its clock.
+func (t *fakeTimer) fire(now time.Time) {
+	if t.f != nil {
+		go t.f()
+		return
+	}
+	select {
+	case t.c <- now:
+	default: // Like a time.Timer, the channel holds at most one value
+	}
+}
@@ -0,0 +1,112 @@
+package comshim
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HolderInfo describes a reference to a shim that has not been released yet.
+// It is reported by OutstandingHolders.
+type HolderInfo struct {
+	Count int       // How much the holder added to the counter
+	Since time.Time // When the reference was taken
+	Guard bool      // Whether the reference was taken by Acquire
+	Stack string    // The call stack of the holder, outside of this package
+}
+
+// holder is an outstanding reference recorded by leak tracking.
+type holder struct {
+	count int
+	since time.Time
+	owner *Guard
+	pcs   []uintptr
+}
+
+// holders records the outstanding references to a shim.
+type holders struct {
+	m    sync.Mutex
+	list []*holder // In the order the references were taken
+}
+
+// track records that delta was added to the counter on behalf of owner, which
+// is nil for plain calls to Add and Done. It does nothing unless leak tracking
+// is enabled.
+//
+// Done can't tell which Add it balances, so a release without an owner retires
+// the most recent references that also have no owner.
+func (s *Shim) track(delta int, owner *Guard) {
+	if !s.settings().leakTracking || delta == 0 {
+		return
+	}
+	h := &s.holders
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if delta > 0 {
+		pcs := make([]uintptr, 32)
+		pcs = pcs[:runtime.Callers(2, pcs)]
+		h.list = append(h.list, &holder{count: delta, since: s.now(), owner: owner, pcs: pcs})
+		return
+	}
+
+	remaining := -delta
+	for i := len(h.list) - 1; i >= 0 && remaining > 0; i-- {
+		e := h.list[i]
+		if e.owner != owner {
+			continue
+		}
+		n := e.count
+		if n > remaining {
+			n = remaining
+		}
+		e.count -= n
+		remaining -= n
+		if e.count == 0 {
+			h.list = append(h.list[:i], h.list[i+1:]...)
+		}
+	}
+}
+
+// OutstandingHolders reports the references to the shim that have not been
+// released yet, oldest first. It returns nil unless the shim was created with
+// WithLeakTracking.
+func (s *Shim) OutstandingHolders() []HolderInfo {
+	h := &s.holders
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	var infos []HolderInfo
+	for _, e := range h.list {
+		infos = append(infos, HolderInfo{
+			Count: e.count,
+			Since: e.since,
+			Guard: e.owner != nil,
+			Stack: formatStack(e.pcs),
+		})
+	}
+	return infos
+}
+
+// formatStack formats the program counters in pcs like a goroutine trace,
+// leaving out the frames that belong to this package.
+func formatStack(pcs []uintptr) string {
+	var b strings.Builder
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, packagePath+".") {
+			fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		}
+		if !more {
+			break
+		}
+	}
+	return b.String()
+}
+
+// packagePath is the import path of this package, as it appears in function
+// names.
+const packagePath = "github.com/NozomiNetworks/go-comshim"
@@ -0,0 +1,55 @@
+package comshim_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NozomiNetworks/go-comshim"
+)
+
+func TestOutstandingHolders(t *testing.T) {
+	s := comshim.New(comshim.WithLeakTracking(true))
+	defer s.WaitDone()
+
+	s.Add(2)
+	g := s.Acquire()
+
+	holders := s.OutstandingHolders()
+	if len(holders) != 2 {
+		t.Fatalf("got %d holders, want 2: %+v", len(holders), holders)
+	}
+	if holders[0].Count != 2 || holders[0].Guard {
+		t.Errorf("first holder is %+v, want a count of 2 from Add", holders[0])
+	}
+	if holders[1].Count != 1 || !holders[1].Guard {
+		t.Errorf("second holder is %+v, want a count of 1 from Acquire", holders[1])
+	}
+	for _, h := range holders {
+		if !strings.HasPrefix(h.Stack, "github.com/NozomiNetworks/go-comshim_test.TestOutstandingHolders\n") {
+			t.Errorf("holder stack does not start at the caller:\n%s", h.Stack)
+		}
+	}
+
+	// Releasing the guard must not retire the plain reference, and vice versa
+	s.Done()
+	g.Release()
+	holders = s.OutstandingHolders()
+	if len(holders) != 1 || holders[0].Count != 1 || holders[0].Guard {
+		t.Errorf("after releasing the guard and one Add, holders are %+v", holders)
+	}
+
+	s.Done()
+	if holders := s.OutstandingHolders(); len(holders) != 0 {
+		t.Errorf("holders remain after the counter reached zero: %+v", holders)
+	}
+}
+
+func TestOutstandingHoldersDisabled(t *testing.T) {
+	s := comshim.New()
+	s.Add(1)
+	if holders := s.OutstandingHolders(); holders != nil {
+		t.Errorf("got holders without leak tracking: %+v", holders)
+	}
+	s.Done()
+	s.WaitDone()
+}
@@ -0,0 +1,66 @@
+package comshim
+
+import (
+	"time"
+
+	"github.com/NozomiNetworks/go-comshim/internal/clock"
+)
+
+// WithDLLGarbageCollection makes the shim's thread call CoFreeUnusedLibrariesEx
+// every interval while it is running, between functions passed to Run. This
+// lets COM unload in-process servers that are no longer in use, which keeps
+// the working set of long-running processes from growing. Libraries are
+// unloaded after the default delay chosen by COM.
+//
+// The option has no effect on shims holding an MTA usage cookie, as they don't
+// have a thread of their own.
+func WithDLLGarbageCollection(interval time.Duration) Option {
+	return func(c *config) {
+		c.libraryInterval = interval
+	}
+}
+
+// libraryCollector keeps track of when the shim's thread should next call
+// CoFreeUnusedLibrariesEx.
+type libraryCollector struct {
+	next  time.Time
+	timer clock.Timer // Wakes the thread when next is reached
+}
+
+// collectLibraries calls CoFreeUnusedLibrariesEx if the interval set by
+// WithDLLGarbageCollection has passed since the previous call, and makes sure
+// that the thread is woken up for the next one. It must be called by the
+// shim's goroutine while holding signalAccess, which is released during the
+// call; it reports whether that happened, in which case the state of the shim
+// may have changed.
+func (s *Shim) collectLibraries(p *progress, c *libraryCollector) bool {
+	interval := s.settings().libraryInterval
+	if interval <= 0 {
+		return false
+	}
+	if c.timer == nil {
+		c.next = s.now().Add(interval)
+		c.timer = s.settings().scheduler.AfterFunc(interval, s.wake)
+		return false
+	}
+	if s.now().Before(c.next) {
+		return false
+	}
+
+	s.signalAccess.Unlock()
+	p.locked = false
+	coFreeUnusedLibraries()
+	s.signalAccess.Lock()
+	p.locked = true
+
+	c.next = s.now().Add(interval)
+	c.timer.Reset(interval)
+	return true
+}
+
+// stop stops waking the thread.
+func (c *libraryCollector) stop() {
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !windows
+
+package comshim
+
+// coFreeUnusedLibraries unloads in-process servers that are no longer in use.
+func coFreeUnusedLibraries() {}
@@ -0,0 +1,45 @@
+package comshim
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCollectLibraries(t *testing.T) {
+	const interval = 5 * time.Millisecond
+	s := New(WithDLLGarbageCollection(interval))
+
+	var c libraryCollector
+	var p progress
+	s.signalAccess.Lock()
+	p.locked = true
+	defer s.signalAccess.Unlock()
+	defer c.stop()
+
+	if s.collectLibraries(&p, &c) {
+		t.Error("libraries were collected before the first interval passed")
+	}
+	first := c.next
+	if s.collectLibraries(&p, &c) {
+		t.Error("libraries were collected again before the interval passed")
+	}
+
+	time.Sleep(interval)
+	if !s.collectLibraries(&p, &c) {
+		t.Error("libraries were not collected after the interval passed")
+	}
+	if !p.locked || !c.next.After(first) {
+		t.Errorf("after collecting, locked is %v and the next collection is at %v, want after %v", p.locked, c.next, first)
+	}
+}
+
+func TestWithDLLGarbageCollectionKeepsRunning(t *testing.T) {
+	s := New(WithDLLGarbageCollection(time.Millisecond))
+	s.Add(1)
+	time.Sleep(20 * time.Millisecond)
+	if err := s.Run(func() error { return nil }); err != nil {
+		t.Errorf("Run while collecting libraries returned %v", err)
+	}
+	s.Done()
+	s.WaitDone()
+}
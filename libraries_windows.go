@@ -0,0 +1,13 @@
+//go:build windows
+
+package comshim
+
+var procCoFreeUnusedLibrariesEx = modole32.NewProc("CoFreeUnusedLibrariesEx")
+
+// unloadDelayDefault makes CoFreeUnusedLibrariesEx use the default delay.
+const unloadDelayDefault = 0xFFFFFFFF
+
+// coFreeUnusedLibraries unloads in-process servers that are no longer in use.
+func coFreeUnusedLibraries() {
+	procCoFreeUnusedLibrariesEx.Call(unloadDelayDefault, 0)
+}
@@ -0,0 +1,40 @@
+package comshim
+
+import (
+	"context"
+	"time"
+)
+
+// WithMaxLifetime limits how long the shim can be used, which is useful for
+// short-lived tools that must not be kept waiting by a leaked reference. Once
+// d has passed since New, the shim is closed regardless of its counter: the
+// functions already queued still run, but TryAdd and Run return ErrClosed from
+// then on. A warning is logged and subscribers receive EventExpired first.
+//
+// Closing the shim before then stops the timer.
+func WithMaxLifetime(d time.Duration) Option {
+	return func(c *config) {
+		c.maxLifetime = d
+	}
+}
+
+// startLifetime arms the timer for the lifetime set by WithMaxLifetime, if
+// any. It is called by New.
+func (s *Shim) startLifetime() {
+	if s.settings().maxLifetime <= 0 {
+		return
+	}
+	s.startAccess.Lock()
+	s.lifetime = s.settings().scheduler.AfterFunc(s.settings().maxLifetime, s.expire)
+	s.startAccess.Unlock()
+}
+
+// expire closes the shim once its lifetime is over.
+func (s *Shim) expire() {
+	if s.closed.Load() {
+		return
+	}
+	s.log().Warn("comshim: maximum lifetime reached, closing", "lifetime", s.settings().maxLifetime, "count", s.Count())
+	s.emit(EventExpired, nil)
+	s.Close(context.Background())
+}
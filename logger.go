@@ -0,0 +1,54 @@
+package comshim
+
+// Logger receives messages about the lifecycle of a shim. It is satisfied by
+// *slog.Logger.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// loggerHolder gives every value stored in Shim.logger the same concrete type,
+// as required by atomic.Value.
+type loggerHolder struct {
+	Logger
+}
+
+// nopLogger discards everything it receives.
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...any) {}
+func (nopLogger) Info(msg string, args ...any)  {}
+func (nopLogger) Warn(msg string, args ...any)  {}
+func (nopLogger) Error(msg string, args ...any) {}
+
+// SetLogger replaces the logger that receives the shim's lifecycle messages.
+// It may be called at any time, including while the shim is running. Passing
+// nil discards all messages, which is the default.
+func (s *Shim) SetLogger(l Logger) {
+	if l == nil {
+		l = nopLogger{}
+	}
+	s.logger.Store(loggerHolder{l})
+}
+
+// log returns the shim's current logger.
+func (s *Shim) log() Logger {
+	if h, ok := s.logger.Load().(loggerHolder); ok {
+		return h.Logger
+	}
+	return nopLogger{}
+}
+
+// logging reports whether the shim has a logger that doesn't discard
+// everything. Hot paths check it before building arguments, which would
+// otherwise be allocated for nothing.
+func (s *Shim) logging() bool {
+	h, ok := s.logger.Load().(loggerHolder)
+	if !ok {
+		return false
+	}
+	_, nop := h.Logger.(nopLogger)
+	return !nop
+}
@@ -0,0 +1,80 @@
+package comshim_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/NozomiNetworks/go-comshim"
+)
+
+// recordingLogger remembers the messages it receives.
+type recordingLogger struct {
+	m        sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) record(msg string) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.messages = append(l.messages, msg)
+}
+
+func (l *recordingLogger) Messages() []string {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return append([]string(nil), l.messages...)
+}
+
+func (l *recordingLogger) Debug(msg string, args ...any) { l.record(msg) }
+func (l *recordingLogger) Info(msg string, args ...any)  { l.record(msg) }
+func (l *recordingLogger) Warn(msg string, args ...any)  { l.record(msg) }
+func (l *recordingLogger) Error(msg string, args ...any) { l.record(msg) }
+
+func TestSetLogger(t *testing.T) {
+	s := comshim.New()
+
+	first := &recordingLogger{}
+	s.SetLogger(first)
+	s.Add(1)
+	s.Done()
+	s.WaitDone()
+
+	second := &recordingLogger{}
+	s.SetLogger(second)
+	s.Add(1)
+	s.Done()
+	s.WaitDone()
+
+	// Counter rose, thread started, initialized, counter dropped, released
+	if got := len(first.Messages()); got != 5 {
+		t.Errorf("first logger received %d messages, want 5: %q", got, first.Messages())
+	}
+	if got := len(second.Messages()); got != 5 {
+		t.Errorf("second logger received %d messages, want 5: %q", got, second.Messages())
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	s := comshim.New(comshim.WithLogger(logger))
+	s.Add(1)
+	s.Done()
+	s.WaitDone()
+
+	want := []string{
+		"comshim: counter rose from zero",
+		"comshim: thread started",
+		"comshim: thread initialized for COM",
+		"comshim: counter dropped to zero",
+		"comshim: thread uninitialized and released",
+	}
+	got := logger.Messages()
+	if len(got) != len(want) {
+		t.Fatalf("logger received %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("message %d is %q, want %q", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package comshim
+
+import (
+	"runtime"
+
+	"github.com/go-ole/go-ole"
+)
+
+// ManagedDispatch ties the lifetime of an IDispatch interface to a reference
+// to the shim whose thread it belongs to. It is returned by Wrap.
+type ManagedDispatch struct {
+	shim    *Shim
+	disp    *ole.IDispatch
+	release func()
+}
+
+// Wrap takes over disp, which must have been obtained on the shim's thread,
+// and adds one to the counter for the shim until the returned ManagedDispatch
+// is closed. Closing it releases disp on the shim's thread and then gives the
+// reference back, so that COM can't be uninitialized while the object is
+// still in use. If the shim is closed first, disp is released as part of the
+// teardown, as if it had been passed to Track.
+//
+// If the ManagedDispatch becomes unreachable without having been closed, its
+// leak is logged, but neither disp nor the reference can be released at that
+// point.
+//
+// If the shim cannot be started, Wrap fails like Add. If the handler set by
+// WithErrorHandler lets it carry on, disp is released right away and the
+// ManagedDispatch holds nothing.
+func (s *Shim) Wrap(disp *ole.IDispatch) *ManagedDispatch {
+	if err := s.hold(1); err != nil {
+		s.handleError(err)
+		disp.Release()
+		return &ManagedDispatch{shim: s, release: func() {}}
+	}
+	return s.manage(disp, s.own(disp))
+}
+
+// manage wraps disp, which holds a reference given back by release.
+func (s *Shim) manage(disp *ole.IDispatch, release func()) *ManagedDispatch {
+	m := &ManagedDispatch{shim: s, disp: disp, release: release}
+	runtime.SetFinalizer(m, (*ManagedDispatch).leaked)
+	return m
+}
+
+// Dispatch returns the wrapped interface. It must not be released directly,
+// and must not be used after Close.
+func (m *ManagedDispatch) Dispatch() *ole.IDispatch {
+	return m.disp
+}
+
+// Shim returns the shim whose thread the wrapped interface belongs to, for
+// running other code that uses it.
+func (m *ManagedDispatch) Shim() *Shim {
+	return m.shim
+}
+
+// CallMethod calls the method name of the wrapped interface on the shim's
+// thread. See Shim.CallMethod.
+func (m *ManagedDispatch) CallMethod(name string, args ...interface{}) (*ole.VARIANT, error) {
+	return m.shim.CallMethod(m.disp, name, args...)
+}
+
+// GetProperty gets the property name of the wrapped interface on the shim's
+// thread. See Shim.GetProperty.
+func (m *ManagedDispatch) GetProperty(name string, args ...interface{}) (*ole.VARIANT, error) {
+	return m.shim.GetProperty(m.disp, name, args...)
+}
+
+// PutProperty sets the property name of the wrapped interface on the shim's
+// thread. See Shim.PutProperty.
+func (m *ManagedDispatch) PutProperty(name string, args ...interface{}) (*ole.VARIANT, error) {
+	return m.shim.PutProperty(m.disp, name, args...)
+}
+
+// Close releases the wrapped interface on the shim's thread and gives the
+// reference back. Only the first call has any effect. It always returns nil,
+// and lets a ManagedDispatch be used as an io.Closer.
+func (m *ManagedDispatch) Close() error {
+	runtime.SetFinalizer(m, nil)
+	m.release()
+	return nil
+}
+
+// leaked is called by the garbage collector when a ManagedDispatch that was
+// never closed becomes unreachable.
+func (m *ManagedDispatch) leaked() {
+	m.shim.log().Warn("comshim: wrapped IDispatch was garbage collected without being closed")
+}
@@ -0,0 +1,67 @@
+package comshim
+
+import (
+	"context"
+	"fmt"
+)
+
+// ApartmentManager routes functions to a thread of the apartment they need:
+// the multithreaded apartment, kept by a single shim, or one of several
+// single-threaded apartments, each on a thread of its own. This suits
+// processes that mix components living in the MTA, such as WMI, with ones
+// that require an STA, such as Office automation.
+//
+// Threads are only started once a function is routed to them, and released
+// again when they are idle, like those of any shim.
+type ApartmentManager struct {
+	mta *Shim
+	sta *Pool
+}
+
+// NewApartmentManager returns a manager with up to staThreads single-threaded
+// apartments, at least one. opts apply to every thread and must not select the
+// concurrency model with WithCoInitFlags.
+func NewApartmentManager(staThreads int, opts ...Option) *ApartmentManager {
+	return &ApartmentManager{
+		mta: New(opts...),
+		sta: newLazyPool(staThreads, NewSTA, opts...),
+	}
+}
+
+// MTA returns the shim that keeps the manager's multithreaded apartment.
+func (m *ApartmentManager) MTA() *Shim {
+	return m.mta
+}
+
+// Run runs fn on a thread in the apartment apt, which must be ApartmentMTA or
+// ApartmentSTA, and returns its result. STA functions go to the least busy of
+// the single-threaded apartments, so objects created by fn in one of them
+// must not be used by later functions directly; pass them on with AgileRef or
+// RegisterInterface instead.
+func (m *ApartmentManager) Run(apt ApartmentType, fn func() error) error {
+	return m.RunContext(context.Background(), apt, fn)
+}
+
+// RunContext is like Run, but stops waiting and returns ctx.Err() if ctx is
+// done before fn has finished. If fn has not started by then it will not be
+// run at all.
+func (m *ApartmentManager) RunContext(ctx context.Context, apt ApartmentType, fn func() error) error {
+	switch apt {
+	case ApartmentMTA:
+		return m.mta.RunContext(ctx, fn)
+	case ApartmentSTA:
+		return m.sta.SubmitContext(ctx, fn)
+	default:
+		return fmt.Errorf("component object model shim cannot run functions in apartment %v", apt)
+	}
+}
+
+// Close releases every thread of the manager and waits for them to
+// uninitialize COM, like Shim.Close. It returns the first error encountered.
+func (m *ApartmentManager) Close(ctx context.Context) error {
+	err := m.mta.Close(ctx)
+	if serr := m.sta.Close(ctx); err == nil {
+		err = serr
+	}
+	return err
+}
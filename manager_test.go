@@ -0,0 +1,49 @@
+package comshim_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NozomiNetworks/go-comshim"
+)
+
+func TestApartmentManager(t *testing.T) {
+	m := comshim.NewApartmentManager(2)
+
+	for _, apt := range []comshim.ApartmentType{comshim.ApartmentMTA, comshim.ApartmentSTA} {
+		ran := false
+		if err := m.Run(apt, func() error {
+			ran = true
+			return nil
+		}); err != nil {
+			t.Errorf("Run in the %v returned %v", apt, err)
+		}
+		if !ran {
+			t.Errorf("Run in the %v didn't call the function", apt)
+		}
+	}
+	if err := m.Run(comshim.ApartmentNA, func() error { return nil }); err == nil {
+		t.Error("Run in the neutral apartment succeeded")
+	}
+
+	if err := m.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if m.MTA().IsRunning() {
+		t.Error("MTA is still running after Close")
+	}
+}
+
+func TestThreadingModelApartment(t *testing.T) {
+	for model, want := range map[comshim.ThreadingModel]comshim.ApartmentType{
+		comshim.ThreadingModelSingle:    comshim.ApartmentSTA,
+		comshim.ThreadingModelApartment: comshim.ApartmentSTA,
+		comshim.ThreadingModelFree:      comshim.ApartmentMTA,
+		comshim.ThreadingModelBoth:      comshim.ApartmentMTA,
+		comshim.ThreadingModelNeutral:   comshim.ApartmentMTA,
+	} {
+		if apt := model.Apartment(); apt != want {
+			t.Errorf("objects of the %v threading model are run in the %v, want the %v", model, apt, want)
+		}
+	}
+}
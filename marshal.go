@@ -0,0 +1,81 @@
+package comshim
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/go-ole/go-ole"
+)
+
+// errUnmarshaled is returned when a MarshaledInterface is used after it has
+// been unmarshaled or released.
+var errUnmarshaled = errors.New("component object model shim interface has already been unmarshaled")
+
+// MarshaledInterface is an interface marshaled into a stream with
+// CoMarshalInterThreadInterfaceInStream, so that it can be unmarshaled once, on
+// another thread. It works on every version of Windows, unlike AgileRef.
+type MarshaledInterface struct {
+	shim   *Shim
+	m      sync.Mutex
+	stream *ole.IUnknown // Nil once unmarshaled or released
+}
+
+// MarshalForThread marshals unknown so that another goroutine can use it with
+// Unmarshal. Marshaling runs on the shim's thread, which should be the thread
+// that obtained unknown, typically within a call to Run.
+func (s *Shim) MarshalForThread(unknown *ole.IUnknown) (*MarshaledInterface, error) {
+	var stream *ole.IUnknown
+	err := s.Run(func() (err error) {
+		stream, err = coMarshalInterThreadInterfaceInStream(unknown)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &MarshaledInterface{shim: s, stream: stream}, nil
+}
+
+// Unmarshal returns a pointer to the marshaled interface that is valid in the
+// apartment of the calling thread. The caller must release it when done. It
+// can only be called once, as unmarshaling consumes the stream.
+//
+// Like GetInterface, Unmarshal runs on the calling goroutine, and the result
+// belongs to the multithreaded apartment unless the caller has locked its
+// goroutine to a thread with an apartment of its own.
+func (m *MarshaledInterface) Unmarshal() (*ole.IUnknown, error) {
+	stream, err := m.take()
+	if err != nil {
+		return nil, err
+	}
+	var unknown *ole.IUnknown
+	err = m.shim.With(func() (err error) {
+		unknown, err = coGetInterfaceAndReleaseStream(stream)
+		return err
+	})
+	return unknown, err
+}
+
+// Release discards the marshaled interface without unmarshaling it, releasing
+// the reference held by the stream. It does nothing if the interface has
+// already been unmarshaled or released.
+func (m *MarshaledInterface) Release() error {
+	stream, err := m.take()
+	if err != nil {
+		return nil
+	}
+	return m.shim.With(func() error {
+		return releaseMarshalStream(stream)
+	})
+}
+
+// take returns the stream, which may only be used once.
+func (m *MarshaledInterface) take() (*ole.IUnknown, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	stream := m.stream
+	if stream == nil {
+		return nil, errUnmarshaled
+	}
+	m.stream = nil
+	return stream, nil
+}
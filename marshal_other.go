@@ -0,0 +1,19 @@
+//go:build !windows
+
+package comshim
+
+import "github.com/go-ole/go-ole"
+
+func coMarshalInterThreadInterfaceInStream(unknown *ole.IUnknown) (*ole.IUnknown, error) {
+	return nil, ole.NewError(ole.E_NOTIMPL)
+}
+
+func coGetInterfaceAndReleaseStream(stream *ole.IUnknown) (*ole.IUnknown, error) {
+	return nil, ole.NewError(ole.E_NOTIMPL)
+}
+
+// releaseMarshalStream releases the marshaled data in stream, and then the
+// stream itself.
+func releaseMarshalStream(stream *ole.IUnknown) error {
+	return ole.NewError(ole.E_NOTIMPL)
+}
@@ -0,0 +1,45 @@
+//go:build windows
+
+package comshim
+
+import (
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+)
+
+var (
+	procCoMarshalInterThreadInterfaceInStream = modole32.NewProc("CoMarshalInterThreadInterfaceInStream")
+	procCoGetInterfaceAndReleaseStream        = modole32.NewProc("CoGetInterfaceAndReleaseStream")
+	procCoReleaseMarshalData                  = modole32.NewProc("CoReleaseMarshalData")
+)
+
+func coMarshalInterThreadInterfaceInStream(unknown *ole.IUnknown) (stream *ole.IUnknown, err error) {
+	hr, _, _ := procCoMarshalInterThreadInterfaceInStream.Call(
+		uintptr(unsafe.Pointer(ole.IID_IUnknown)),
+		uintptr(unsafe.Pointer(unknown)),
+		uintptr(unsafe.Pointer(&stream)))
+	if err := hresultError(hr); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+func coGetInterfaceAndReleaseStream(stream *ole.IUnknown) (unknown *ole.IUnknown, err error) {
+	hr, _, _ := procCoGetInterfaceAndReleaseStream.Call(
+		uintptr(unsafe.Pointer(stream)),
+		uintptr(unsafe.Pointer(ole.IID_IUnknown)),
+		uintptr(unsafe.Pointer(&unknown)))
+	if err := hresultError(hr); err != nil {
+		return nil, err
+	}
+	return unknown, nil
+}
+
+// releaseMarshalStream releases the marshaled data in stream, and then the
+// stream itself.
+func releaseMarshalStream(stream *ole.IUnknown) error {
+	defer stream.Release()
+	hr, _, _ := procCoReleaseMarshalData.Call(uintptr(unsafe.Pointer(stream)))
+	return hresultError(hr)
+}
@@ -0,0 +1,68 @@
+// Package metrics exposes the usage of a comshim.Shim in the shape expected by
+// metrics libraries such as Prometheus and OpenTelemetry, without depending on
+// any of them. Gauges and counters are read when they are collected; start
+// durations are pushed to a histogram as they happen. With Prometheus:
+//
+//	prometheus.MustRegister(
+//		prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: "comshim_holders"}, metrics.Holders(s)),
+//		prometheus.NewCounterFunc(prometheus.CounterOpts{Name: "comshim_init_failures_total"}, metrics.InitFailures(s)),
+//		initDuration, // A prometheus.Histogram
+//	)
+//	stop := metrics.ObserveInitDurations(s, func(seconds float64, err error) {
+//		initDuration.Observe(seconds)
+//	})
+//	defer stop()
+//
+// OpenTelemetry's observable gauges and counters can call the same functions
+// from their callbacks, and its histograms can be recorded from the function
+// passed to ObserveInitDurations.
+package metrics
+
+import (
+	"time"
+
+	"github.com/NozomiNetworks/go-comshim"
+)
+
+// Holders returns a function that reports the current value of the counter of
+// s, the number of references holding its apartment, for use as a gauge.
+func Holders(s *comshim.Shim) func() float64 {
+	return func() float64 { return float64(s.Count()) }
+}
+
+// PeakHolders returns a function that reports the highest value the counter of
+// s has reached, for use as a gauge.
+func PeakHolders(s *comshim.Shim) func() float64 {
+	return func() float64 { return float64(s.Stats().PeakCount) }
+}
+
+// Starts returns a function that reports how many times s has started its
+// thread, for use as a counter.
+func Starts(s *comshim.Shim) func() float64 {
+	return func() float64 { return float64(s.Stats().Starts) }
+}
+
+// InitFailures returns a function that reports how many starts of s have
+// failed, for use as a counter.
+func InitFailures(s *comshim.Shim) func() float64 {
+	return func() float64 { return float64(s.Stats().InitFailures) }
+}
+
+// ObserveInitDurations calls observe with the time, in seconds, that every
+// start of s takes from now on, including any retries, along with the error
+// if the start failed. It is meant to feed a histogram. observe is called on a
+// goroutine of its own, one start at a time, until stop is called.
+func ObserveInitDurations(s *comshim.Shim, observe func(seconds float64, err error)) (stop func()) {
+	var started time.Time
+	return s.Subscribe(func(ev comshim.Event) {
+		switch ev.Kind {
+		case comshim.EventStarting:
+			started = ev.Time
+		case comshim.EventInitialized, comshim.EventInitFailed:
+			if !started.IsZero() {
+				observe(ev.Time.Sub(started).Seconds(), ev.Err)
+				started = time.Time{}
+			}
+		}
+	})
+}
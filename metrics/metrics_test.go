@@ -0,0 +1,57 @@
+package metrics_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/NozomiNetworks/go-comshim"
+	"github.com/NozomiNetworks/go-comshim/comshimtest"
+	"github.com/NozomiNetworks/go-comshim/metrics"
+)
+
+func TestObserveInitDurations(t *testing.T) {
+	f := comshimtest.NewInitializer()
+	f.SetDelay(10 * time.Millisecond)
+	s := comshim.New(comshim.WithInitializer(f))
+
+	type observation struct {
+		seconds float64
+		err     error
+	}
+	observed := make(chan observation, 2)
+	stop := metrics.ObserveInitDurations(s, func(seconds float64, err error) {
+		observed <- observation{seconds, err}
+	})
+	defer stop()
+
+	s.Add(1)
+	if n := metrics.Holders(s)(); n != 1 {
+		t.Errorf("holders gauge is %v, want 1", n)
+	}
+	s.Done()
+	s.WaitDone()
+
+	errInit := errors.New("injected failure")
+	f.FailNext(errInit)
+	if err := s.TryAdd(1); err == nil {
+		t.Fatal("TryAdd succeeded despite the injected failure")
+	}
+	if n := metrics.InitFailures(s)(); n != 1 {
+		t.Errorf("init failures counter is %v, want 1", n)
+	}
+
+	for _, want := range []error{nil, errInit} {
+		select {
+		case o := <-observed:
+			if o.seconds < 0.01 {
+				t.Errorf("observed a start of %vs, want at least 0.01s", o.seconds)
+			}
+			if !errors.Is(o.err, want) {
+				t.Errorf("observed a start that failed with %v, want %v", o.err, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a start to be observed")
+		}
+	}
+}
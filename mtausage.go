@@ -0,0 +1,134 @@
+package comshim
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// NewMTAUsage returns a new shim that keeps the multithreaded apartment alive
+// with CoIncrementMTAUsage where the operating system supports it (Windows 8
+// and later), so that no OS thread has to be locked for the purpose. On older
+// systems it falls back to the thread-locked goroutine used by New.
+//
+// While the shim holds an MTA usage cookie, every thread that hasn't
+// initialized COM itself belongs to the implicit MTA. Run therefore calls its
+// function on the calling goroutine, with its OS thread locked, rather than
+// queueing it for a dedicated thread.
+func NewMTAUsage(opts ...Option) *Shim {
+	return New(append([]Option{WithMTAUsageCookie()}, opts...)...)
+}
+
+// WithMTAUsageCookie makes the shim keep the multithreaded apartment alive
+// with an MTA usage cookie, like a shim created by NewMTAUsage.
+//
+// A process may combine such shims with ones that initialize a thread of their
+// own. Each keeps the MTA alive independently of the others: releasing the
+// cookie with CoDecrementMTAUsage doesn't uninitialize any thread, and a
+// thread calling CoUninitialize doesn't release any cookie, so COM stays
+// usable for as long as any of them is held. Since an MTA kept alive by a
+// cookie can't be told apart from one initialized by the host, shims created
+// with WithAdoptHostApartment don't adopt the MTA while a shim of this process
+// holds a cookie, and start a thread of their own instead.
+func WithMTAUsageCookie() Option {
+	return func(c *config) {
+		c.mtaUsage = true
+	}
+}
+
+// mtaCookies is the number of MTA usage cookies held by the shims of the
+// process.
+var mtaCookies atomic.Int64
+
+// usingMTAUsage reports whether the shim, or the shim owning its shared
+// thread, currently holds an MTA usage cookie or has adopted the host's MTA,
+// so that there is no thread of its own.
+func (s *Shim) usingMTAUsage() bool {
+	if s.settings().shared != nil {
+		return s.settings().shared.usingMTAUsage()
+	}
+	s.signalAccess.RLock()
+	defer s.signalAccess.RUnlock()
+	return s.cookie != 0 || s.adopted
+}
+
+// startMTAUsage takes the place of the shim's goroutine when an MTA usage
+// cookie is used to keep the apartment alive.
+func (s *Shim) startMTAUsage() error {
+	defer s.end()
+
+	cookie, err := coIncrementMTAUsage()
+	if err != nil {
+		s.log().Error("comshim: CoIncrementMTAUsage failed", "error", err)
+		return newInitError("CoIncrementMTAUsage", err)
+	}
+
+	if s.settings().security != nil {
+		// This thread is in the implicit MTA now that the cookie is held
+		runtime.LockOSThread()
+		err := initializeSecurity(*s.settings().security)
+		runtime.UnlockOSThread()
+		if err != nil {
+			s.log().Error("comshim: CoInitializeSecurity failed", "error", err)
+			coDecrementMTAUsage(cookie)
+			return newInitError("CoInitializeSecurity", err)
+		}
+	}
+	if len(s.settings().initHooks) > 0 || s.settings().namedEvent != "" {
+		runtime.LockOSThread()
+		err := s.runInitHooks()
+		runtime.UnlockOSThread()
+		if err != nil {
+			coDecrementMTAUsage(cookie)
+			return err
+		}
+	}
+
+	s.signalAccess.Lock()
+	s.cookie = cookie
+	mtaCookies.Add(1)
+	s.setRunning(true)
+	if !s.held() || s.closed.Load() {
+		// Every reference was dropped, or the shim was closed, while the
+		// cookie was being taken
+		s.releaseMTAUsage()
+	}
+	s.signalAccess.Unlock()
+
+	s.log().Debug("comshim: acquired MTA usage cookie")
+	return nil
+}
+
+// idleMTAUsage is called when the counter drops to zero while an MTA usage
+// cookie is held. The cookie is released, either right away or, if the shim
+// has an idle timeout, once the timeout expires without the counter having
+// become positive again. It must be called while holding signalAccess.
+func (s *Shim) idleMTAUsage() {
+	if s.settings().idleTimeout <= 0 {
+		s.releaseMTAUsage()
+		return
+	}
+	s.settings().scheduler.AfterFunc(s.settings().idleTimeout, func() {
+		s.signalAccess.Lock()
+		defer s.signalAccess.Unlock()
+		if s.cookie == 0 || s.c.Value() > 0 || s.IdleDuration() < s.settings().idleTimeout {
+			return // Still in use, or a later timer is responsible
+		}
+		s.releaseMTAUsage()
+	})
+}
+
+// releaseMTAUsage releases the shim's MTA usage cookie. It must be called while
+// holding signalAccess.
+func (s *Shim) releaseMTAUsage() {
+	s.setRunning(false)
+	s.teardownMTAUsage()
+	s.reportUnreleased()
+	s.releaseTracked()
+	s.revokeGIT()
+	if err := coDecrementMTAUsage(s.cookie); err != nil {
+		s.setErr(err)
+	}
+	mtaCookies.Add(-1)
+	s.cookie = 0
+	s.emit(EventUninitialized, nil)
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package comshim
+
+import "github.com/go-ole/go-ole"
+
+// mtaUsageSupported reports whether the operating system provides
+// CoIncrementMTAUsage and CoDecrementMTAUsage.
+func mtaUsageSupported() bool {
+	return false
+}
+
+func coIncrementMTAUsage() (cookie uintptr, err error) {
+	return 0, ole.NewError(ole.E_NOTIMPL)
+}
+
+func coDecrementMTAUsage(cookie uintptr) error {
+	return ole.NewError(ole.E_NOTIMPL)
+}
@@ -0,0 +1,35 @@
+//go:build windows
+
+package comshim
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modole32 = windows.NewLazySystemDLL("ole32.dll")
+
+	procCoIncrementMTAUsage = modole32.NewProc("CoIncrementMTAUsage")
+	procCoDecrementMTAUsage = modole32.NewProc("CoDecrementMTAUsage")
+)
+
+// mtaUsageSupported reports whether the operating system provides
+// CoIncrementMTAUsage and CoDecrementMTAUsage.
+func mtaUsageSupported() bool {
+	return procCoIncrementMTAUsage.Find() == nil && procCoDecrementMTAUsage.Find() == nil
+}
+
+func coIncrementMTAUsage() (cookie uintptr, err error) {
+	hr, _, _ := procCoIncrementMTAUsage.Call(uintptr(unsafe.Pointer(&cookie)))
+	if err = hresultError(hr); err != nil {
+		return 0, err
+	}
+	return cookie, nil
+}
+
+func coDecrementMTAUsage(cookie uintptr) error {
+	hr, _, _ := procCoDecrementMTAUsage.Call(cookie)
+	return hresultError(hr)
+}
@@ -0,0 +1,48 @@
+package comshim
+
+// WithNamedEvent makes the shim hold a named Windows event for as long as COM
+// is initialized, so that sibling processes such as watchdogs and updaters
+// can tell whether the process currently holds COM resources before
+// restarting it. Such a process checks by opening the event with OpenEvent:
+// the event only exists while some process holds a handle to it. The name may
+// carry a Global\ or Local\ prefix, and ExternalCookie returns it.
+//
+// The event is created on the shim's thread, before the hooks added with
+// WithInitHook are called, and closed after the teardown hooks have run. If
+// creating it fails the start fails with that error. On other platforms the
+// option has no effect.
+func WithNamedEvent(name string) Option {
+	return func(c *config) {
+		c.namedEvent = name
+	}
+}
+
+// ExternalCookie returns the name of the event set by WithNamedEvent, or "" if
+// the shim doesn't hold one.
+func (s *Shim) ExternalCookie() string {
+	return s.settings().namedEvent
+}
+
+// holdNamedEvent creates the event set by WithNamedEvent, if any and if it
+// isn't already held.
+func (s *Shim) holdNamedEvent() error {
+	if s.settings().namedEvent == "" || s.eventHandle != 0 {
+		return nil
+	}
+	handle, err := createNamedEvent(s.settings().namedEvent)
+	if err != nil {
+		s.log().Error("comshim: creating the named event failed", "name", s.settings().namedEvent, "error", err)
+		return err
+	}
+	s.eventHandle = handle
+	return nil
+}
+
+// dropNamedEvent closes the event held for WithNamedEvent, if any.
+func (s *Shim) dropNamedEvent() {
+	if s.eventHandle == 0 {
+		return
+	}
+	closeNamedEvent(s.eventHandle)
+	s.eventHandle = 0
+}
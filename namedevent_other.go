@@ -0,0 +1,11 @@
+//go:build !windows
+
+package comshim
+
+// createNamedEvent creates the event called name.
+func createNamedEvent(name string) (uintptr, error) {
+	return 0, nil
+}
+
+// closeNamedEvent closes a handle returned by createNamedEvent.
+func closeNamedEvent(handle uintptr) {}
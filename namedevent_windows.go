@@ -0,0 +1,25 @@
+//go:build windows
+
+package comshim
+
+import "golang.org/x/sys/windows"
+
+// createNamedEvent creates, or opens if another process already holds it, the
+// event called name.
+func createNamedEvent(name string) (uintptr, error) {
+	p, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return 0, err
+	}
+	handle, err := windows.CreateEvent(nil, 1, 1, p)
+	if handle == 0 {
+		return 0, err
+	}
+	// ERROR_ALREADY_EXISTS only means that some sibling holds it too
+	return uintptr(handle), nil
+}
+
+// closeNamedEvent closes a handle returned by createNamedEvent.
+func closeNamedEvent(handle uintptr) {
+	windows.CloseHandle(windows.Handle(handle))
+}
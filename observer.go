@@ -0,0 +1,54 @@
+package comshim
+
+import (
+	"context"
+	"time"
+)
+
+// TaskInfo describes a function that ran on the shim's thread, as reported to
+// the observer set by WithTaskObserver.
+type TaskInfo struct {
+	Label     string        // As given to DoLabeled, or empty
+	QueueWait time.Duration // How long the function waited for the thread
+	Exec      time.Duration // How long the function ran
+	Err       error         // What the function returned
+}
+
+// WithTaskObserver makes the shim report every function it runs on its
+// thread to observe once the function has returned, for example to find out
+// which COM calls dominate latency. Label the functions with DoLabeled to
+// tell them apart.
+//
+// observe is called on the shim's thread, after the result has been handed to
+// the caller and with none of the shim's locks held. The next function waits
+// for it, so it should be quick. On shims holding an MTA usage cookie (see
+// NewMTAUsage) it is called on the goroutine that ran the function instead.
+// Functions abandoned before they started aren't reported, while the empty
+// functions sent by Ping and the watchdog are, without a label.
+func WithTaskObserver(observe func(TaskInfo)) Option {
+	return func(c *config) {
+		c.taskObserver = observe
+	}
+}
+
+// DoLabeled runs fn on the shim's thread like Run, labeling it for the
+// observer set by WithTaskObserver. It returns an error if fn couldn't be run,
+// or a *PanicError if it panicked.
+func (s *Shim) DoLabeled(label string, fn func()) error {
+	return s.runContext(context.Background(), &task{
+		fn:    func() error { fn(); return nil },
+		label: label,
+	})
+}
+
+// observe reports t to the task observer, if there is one.
+func (s *Shim) observe(t *task, started time.Time, err error) {
+	if s.settings().taskObserver == nil {
+		return
+	}
+	info := TaskInfo{Label: t.label, Exec: s.now().Sub(started), Err: err}
+	if !t.queued.IsZero() {
+		info.QueueWait = started.Sub(t.queued)
+	}
+	s.settings().taskObserver(info)
+}
@@ -0,0 +1,59 @@
+package comshim
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// OnceOnThread returns a function that runs fn on the shim's thread the first
+// time it is called, and returns the error of that call every time after that,
+// however many goroutines call it at once. Callers arriving while fn is
+// running wait for it to finish.
+//
+// If fn doesn't get to run, because the shim can't be started or Run gave up
+// on it before it started, the error is returned but not remembered, and the
+// next call tries again. If Run gives up on fn while it is running, as with
+// WithTaskTimeout, that call returns context.DeadlineExceeded and later calls
+// wait for fn and return its error. fn runs once in total, not once per start
+// of the thread; per-thread setup belongs in an init hook (see WithInitHook).
+func (s *Shim) OnceOnThread(fn func() error) func() error {
+	var (
+		m       sync.Mutex
+		done    bool
+		err     error
+		pending chan error // Receives the error of fn if Run stopped waiting for it
+	)
+	return func() error {
+		m.Lock()
+		defer m.Unlock()
+		if !done && pending != nil {
+			err, done = <-pending, true
+		}
+		if done {
+			return err
+		}
+
+		// Whichever of fn and this goroutine claims the call first decides
+		// whether fn runs, so a task Run has given up on can't start late
+		var claimed atomic.Bool
+		result := make(chan error, 1)
+		runErr := s.Run(func() error {
+			if !claimed.CompareAndSwap(false, true) {
+				return nil
+			}
+			err := (&task{fn: fn}).call()
+			result <- err
+			return err
+		})
+		if claimed.CompareAndSwap(false, true) {
+			return runErr
+		}
+		select {
+		case err = <-result:
+			done = true
+		default:
+			pending = result
+		}
+		return runErr
+	}
+}
@@ -0,0 +1,297 @@
+package comshim
+
+import (
+	"time"
+
+	"github.com/NozomiNetworks/go-comshim/internal/clock"
+	"github.com/go-ole/go-ole"
+)
+
+// Option adjusts the behavior of a shim. Options are passed to New and the
+// other constructors, and are applied in order.
+type Option func(*config)
+
+// config holds the settings of a shim that are fixed at construction.
+type config struct {
+	coinit    uint32 // The flags passed to CoInitializeEx
+	mtaUsage  bool   // Keep the MTA alive with CoIncrementMTAUsage where supported
+	adoptHost bool   // Use the MTA of the host process if it has one
+	winRT     bool   // Initialize with RoInitialize instead of CoInitializeEx
+	shared    *Shim  // The shim owning the thread this one runs on, if any
+	manifest  string // The manifest of an activation context to activate on the thread
+	security  *SecurityConfig
+	blanket   *BlanketConfig // Applied to the proxies returned by the creation helpers, if set
+
+	etwProvider *ole.GUID // The ETW provider to write events to, if any
+	namedEvent  string    // The name of an event to hold while COM is initialized, if any
+	token       uintptr   // The token the thread impersonates, if any
+
+	initializer   Initializer    // Replaces COM initialization, if set
+	initHooks     []func() error // Called on the thread after it has been initialized
+	teardownHooks []func()       // Called on the thread before it is uninitialized
+
+	teardownPolicy TeardownPolicy // What happens to an Add racing with the release of the thread
+
+	threadName     string          // The description given to the thread, if any
+	threadPriority *ThreadPriority // The priority given to the thread, if any
+	threadAffinity uintptr         // The processors the thread may run on, or 0 for any
+
+	eagerStart      bool          // Start the thread in New and keep it until Close
+	permanent       bool          // Keep the thread until Close once it has started
+	explicitStart   bool          // Only start the thread for references, not for Run or Go
+	maxLifetime     time.Duration // How long after New to close the shim, or 0 for never
+	idleTimeout     time.Duration // How long to keep the apartment after the counter hits zero
+	hysteresis      time.Duration // How long the counter must stay at zero since it last changed
+	libraryInterval time.Duration // How often to call CoFreeUnusedLibrariesEx, or 0 for never
+
+	negativeCounterErrors bool // Report underflows as errors instead of panicking
+
+	stopChannel <-chan struct{} // Closes the shim once it is closed, if set
+
+	logger Logger // The initial logger, if any
+
+	clock     clock.Clock     // Tells the time
+	scheduler clock.Scheduler // Arms timers
+
+	leakTracking    bool // Record the callers holding references
+	strictOwnership bool // Only accept references taken through a Client
+	maxHolders      int  // The highest value the counter may reach, or 0 for no limit
+	keepGIT         bool // Leave the interfaces registered in the GIT at teardown
+
+	queueLimit     int            // How many functions may wait for the thread, or 0 for no limit
+	taskTimeout    time.Duration  // How long Run waits for a function, or 0 for no limit
+	taskObserver   func(TaskInfo) // Told about every function run on the thread, if set
+	strictAffinity bool           // Check that every function runs on the shim's thread
+
+	apartmentFallback bool // Retry on a fresh thread if the first one was already initialized
+	reuseExistingInit bool // Adopt a thread that is already initialized for the same apartment
+
+	panicHandler      func(*PanicError)     // Called when the shim's goroutine panics
+	unreleasedHandler func([]TrackedObject) // Called when uninitializing with references outstanding
+	errorHandler      func(error) Action    // Decides what to do about errors that can't be returned
+
+	watchdogInterval time.Duration               // How often to ping the thread, or 0 for never
+	onStall          func(stalled time.Duration) // Called when a ping doesn't come back
+
+	initAttempts  int           // How many times to try starting the shim
+	startTimeout  time.Duration // How long callers wait for a start, or 0 for no limit
+	initBackoff   time.Duration // How long to wait before the first retry
+	stickyFailure bool          // Keep returning the error of a failed start until Reset
+}
+
+func defaultConfig() config {
+	return config{
+		coinit:    ole.COINIT_MULTITHREADED,
+		clock:     clock.System{},
+		scheduler: clock.System{},
+	}
+}
+
+// settings returns the shim's current configuration, which must not be
+// modified. Reinitialize replaces it as a whole.
+func (s *Shim) settings() *config {
+	return s.cfg.Load()
+}
+
+// WithCoInitFlags sets the flags that the shim's thread passes to
+// CoInitializeEx, such as
+//
+//	ole.COINIT_MULTITHREADED | ole.COINIT_DISABLE_OLE1DDE
+//
+// The flags must include the concurrency model. If they include
+// COINIT_APARTMENTTHREADED the thread runs a window message pump, just like
+// one created by NewSTA. The default is COINIT_MULTITHREADED.
+func WithCoInitFlags(flags uint32) Option {
+	return func(c *config) {
+		c.coinit = flags
+	}
+}
+
+// WithSecurity causes the shim to call CoInitializeSecurity with the given
+// settings right after COM has been initialized on its thread. WMI and DCOM
+// clients typically need this to raise the impersonation level.
+//
+// CoInitializeSecurity applies to the whole process and may only be called
+// once, so the settings of the first shim to start win. Later starts of any
+// shim do not call it again. If some other code in the process has already
+// called it, starting the shim fails with the resulting RPC_E_TOO_LATE error.
+func WithSecurity(cfg SecurityConfig) Option {
+	return func(c *config) {
+		c.security = &cfg
+	}
+}
+
+// WithNegativeCounterErrors stops the shim from panicking when its counter
+// would drop below zero, which usually means Done has been called once too
+// often. Instead the counter is left unchanged, the problem is logged and
+// TryAdd returns ErrNegativeCounter. Done has no way to report the error, so
+// it only logs it.
+//
+// This is useful in libraries, where an extra call to Done made by some other
+// component should not take the whole process down.
+func WithNegativeCounterErrors() Option {
+	return func(c *config) {
+		c.negativeCounterErrors = true
+	}
+}
+
+// WithIdleTimeout keeps the shim's apartment initialized for d after its
+// counter drops to zero. If the counter becomes positive again within that
+// time, the existing thread is reused instead of paying for a new thread and
+// another round of CoInitializeEx and CoUninitialize. WaitDone waits for the
+// idle timeout to expire.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.idleTimeout = d
+	}
+}
+
+// WithHysteresis keeps the shim's apartment initialized until its counter has
+// stayed at zero for d since it last changed, so that a workload whose
+// counter keeps dipping to zero in the middle of a burst reuses one thread
+// instead of starting and stopping a new one each time. Every Add and Done
+// starts the period over, while a sustained idle period still releases the
+// thread. Combined with WithIdleTimeout, the apartment is kept until both
+// periods are over. Stats reports how many teardowns were avoided, and
+// WaitDone waits for the period to expire. It applies to shims with a thread
+// of their own.
+func WithHysteresis(d time.Duration) Option {
+	return func(c *config) {
+		c.hysteresis = d
+	}
+}
+
+// WithLogger sets the logger that receives the shim's lifecycle messages from
+// the moment it is created: thread start, the result of CoInitializeEx,
+// transitions of the counter to and from zero, and the release of the thread.
+// A *slog.Logger can be passed directly. The logger can later be replaced with
+// SetLogger.
+func WithLogger(l Logger) Option {
+	return func(c *config) {
+		c.logger = l
+	}
+}
+
+// WithLeakTracking makes the shim record the call stack of every Add, TryAdd,
+// Acquire and With that is still holding a reference, so that OutstandingHolders
+// can tell which component forgot to call Done. Capturing stacks is costly, so
+// this is meant for debugging.
+func WithLeakTracking(enabled bool) Option {
+	return func(c *config) {
+		c.leakTracking = enabled
+	}
+}
+
+// WithInitRetry makes the shim try to start up to attempts times when
+// initializing its thread fails, such as when CoInitializeEx reports a
+// transient error during process startup. It waits backoff before the first
+// retry and doubles the wait before each one after that. Callers of TryAdd
+// only see the error of the last attempt.
+//
+// Panics are never retried, and retrying stops if every caller waiting for the
+// shim has given up.
+func WithInitRetry(attempts int, backoff time.Duration) Option {
+	return func(c *config) {
+		c.initAttempts = attempts
+		c.initBackoff = backoff
+	}
+}
+
+// WithApartmentFallback protects the shim from dependencies that initialize
+// COM on a thread and never uninitialize it. If the shim's thread turns out to
+// be initialized already, either for the same concurrency model or, causing
+// RPC_E_CHANGED_MODE, for the other one, the thread is thrown away and the
+// shim tries once more on a fresh thread.
+func WithApartmentFallback() Option {
+	return func(c *config) {
+		c.apartmentFallback = true
+	}
+}
+
+// WithPanicHandler sets a function that is called, on the shim's goroutine,
+// whenever that goroutine recovers from a panic. The same error is also
+// available from Err. If the panic happened after the thread had been
+// initialized, the thread is restarted afterwards as long as the counter is
+// still positive.
+func WithPanicHandler(fn func(*PanicError)) Option {
+	return func(c *config) {
+		c.panicHandler = fn
+	}
+}
+
+// WithReuseExistingInit controls what happens when the shim's thread turns out
+// to have been initialized for the same apartment already, so that
+// CoInitializeEx returns S_FALSE. By default this is treated as a bug in some
+// other code and ErrAlreadyInitialized is returned. If reuse is true the shim
+// adopts the existing initialization instead; at teardown it only undoes its
+// own call to CoInitializeEx, leaving the earlier initialization in place.
+//
+// This takes precedence over WithApartmentFallback for the S_FALSE case.
+func WithReuseExistingInit(reuse bool) Option {
+	return func(c *config) {
+		c.reuseExistingInit = reuse
+	}
+}
+
+// WithMaxHolders limits the counter of the shim to n, which bounds the number
+// of goroutines that can use COM through it at the same time. Adding to the
+// counter beyond the limit makes TryAdd return ErrTooManyHolders, and Add
+// panic; WaitAdd waits for room instead. A limit of zero or less means no
+// limit, which is the default.
+func WithMaxHolders(n int) Option {
+	return func(c *config) {
+		c.maxHolders = n
+	}
+}
+
+// WithStickyFailure makes a failure to start the shim permanent: once a start
+// has failed, TryAdd returns the same error without trying again, until Reset
+// is called. By default every TryAdd on a stopped shim makes a new attempt.
+func WithStickyFailure() Option {
+	return func(c *config) {
+		c.stickyFailure = true
+	}
+}
+
+// WithStartTimeout bounds how long TryAdd waits for the shim's thread to be
+// initialized, such as when the thread is starved by the scheduler or held up
+// by the loader lock. If the start takes longer than d, TryAdd takes its delta
+// back off the counter and returns ErrStartTimeout. The start carries on in the
+// background, and the thread is released once it is up if nobody is holding
+// it by then, so a later TryAdd can simply try again. A d of zero or less
+// means no timeout, which is the default.
+//
+// Add panics, and Acquire too, as they do for any other failure to start.
+func WithStartTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.startTimeout = d
+	}
+}
+
+// WithQueueLimit bounds the number of functions waiting to run on the shim's
+// thread to n. Once that many are queued, Run and the other methods that queue
+// functions return ErrQueueFull right away instead of letting the queue grow,
+// which gives callers a clean signal to back off when a COM provider slows
+// down. Functions passed to RunPriority, and the pings of Ping, are never
+// rejected. A limit of zero or less means no limit, which is the default.
+func WithQueueLimit(n int) Option {
+	return func(c *config) {
+		c.queueLimit = n
+	}
+}
+
+// WithTaskTimeout bounds how long Run, RunPriority and their context variants
+// wait for a function, including the time it spends waiting for the thread to
+// start and for the functions queued ahead of it. Once d has passed they
+// return context.DeadlineExceeded, and the function is skipped if it hasn't
+// started yet. A function that has started is left to finish; use RunTimeout
+// to give up on the thread itself. A d of zero or less means no timeout, which
+// is the default.
+//
+// Shims holding an MTA usage cookie run functions on the calling goroutine,
+// which can't be given up on once the function has started.
+func WithTaskTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.taskTimeout = d
+	}
+}
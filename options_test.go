@@ -0,0 +1,38 @@
+package comshim_test
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/NozomiNetworks/go-comshim"
+	"github.com/go-ole/go-ole"
+)
+
+func TestWithCoInitFlags(t *testing.T) {
+	s := comshim.New(comshim.WithCoInitFlags(ole.COINIT_MULTITHREADED | ole.COINIT_DISABLE_OLE1DDE))
+	defer s.WaitDone()
+
+	if err := s.TryAdd(1); err != nil {
+		t.Fatal(err)
+	}
+	s.Done()
+}
+
+func TestWithCoInitFlagsSelectsApartment(t *testing.T) {
+	s := comshim.New(comshim.WithCoInitFlags(ole.COINIT_APARTMENTTHREADED))
+	if report := s.Diagnostics(); !strings.Contains(report, "apartment: single-threaded\n") {
+		t.Errorf("shim created with COINIT_APARTMENTTHREADED is not an STA:\n%s", report)
+	}
+}
+
+func TestSupportedFeatures(t *testing.T) {
+	features := comshim.SupportedFeatures()
+	if runtime.GOOS != "windows" && features != (comshim.Features{}) {
+		t.Errorf("SupportedFeatures reported %+v outside of Windows", features)
+	}
+	// SetThreadDescription arrived in a later version than CoIncrementMTAUsage
+	if features.ThreadNames && !features.MTAUsage {
+		t.Errorf("SupportedFeatures reported %+v, with thread names but no MTA usage", features)
+	}
+}
@@ -0,0 +1,145 @@
+package comshim
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// PanicError is returned when the shim's goroutine recovers from a panic. It
+// carries the value passed to panic and the stack of the goroutine at the
+// time of the panic.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+// Error implements the error interface.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("component object model shim goroutine panicked: %v", e.Value)
+}
+
+// progress records how far the shim's goroutine has gotten, so that a
+// recovered panic undoes exactly what has been done so far.
+type progress struct {
+	initialized bool        // CoInitializeEx returned S_OK or S_FALSE and CoUninitialize is still owed
+	locked      bool        // signalAccess is held
+	signaled    bool        // The initialization result has been sent to run()
+	tornDown    bool        // The teardown hooks have been called
+	detached    atomic.Bool // Set by poison once the shim has given up on the thread
+}
+
+// signal sends the result of initialization to run(). It must be called at
+// most once per goroutine; init must be buffered.
+func (p *progress) signal(init chan<- error, err error) {
+	init <- err
+	close(init)
+	p.signaled = true
+}
+
+// uninitializeThread undoes the initialization of the shim's thread if it is
+// still owed. The debt is cleared first, so that a panic in the middle of
+// CoUninitialize can't make recoverPanic call it a second time.
+func (s *Shim) uninitializeThread(p *progress) {
+	if !p.initialized {
+		return
+	}
+	p.initialized = false
+	s.uninitialize()
+}
+
+// recoverPanic recovers from a panic on the shim's goroutine. The panic is
+// recorded so that it can be retrieved via Err and passed to the handler set
+// by WithPanicHandler, and the thread is uninitialized if necessary. If run()
+// is still waiting for initialization to finish, the panic is returned to it
+// as an error; otherwise the thread is restarted while the counter is still
+// positive.
+//
+// recoverPanic must be deferred directly by the goroutine.
+func (s *Shim) recoverPanic(p *progress, init chan<- error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	err := &PanicError{Value: r, Stack: debug.Stack()}
+	s.setErr(err)
+	s.emit(EventPanic, err)
+
+	if !p.locked {
+		s.signalAccess.Lock()
+	}
+	if p.detached.Load() {
+		// The shim has moved on to another thread, which is left alone
+		s.uninitializeThread(p)
+		s.signalAccess.Unlock()
+		s.log().Error("comshim: recovered from panic on abandoned thread", "error", err, "stack", string(err.Stack))
+		return
+	}
+	if s.current == p {
+		s.current = nil
+	}
+	s.setRunning(false)
+	s.threadID.Store(0)
+	s.stopWatchdog()
+	s.abandonTasks()
+	if p.initialized && p.signaled {
+		s.runTeardownHooks(p) // The thread was up and running
+	}
+	if s.pump != nil {
+		s.pump.close()
+		s.pump = nil
+	}
+	if p.initialized {
+		s.reportUnreleased()
+		s.releaseTracked()
+		s.revokeGIT()
+		s.uninitializeThread(p)
+		s.emit(EventUninitialized, nil)
+	}
+	s.signalAccess.Unlock()
+
+	// A panic during initialization is reported to the callers waiting for
+	// the start. Otherwise the thread was in use, and is brought back up for
+	// the references that are still held.
+	restart := p.signaled
+	if !p.signaled {
+		p.signal(init, err)
+	}
+
+	// The logger and the handler may be the source of the panic, so each is
+	// given its own chance to fail without taking the process down.
+	func() {
+		defer func() { _ = recover() }()
+		s.log().Error("comshim: recovered from panic", "error", err, "stack", string(err.Stack))
+	}()
+	if s.settings().panicHandler != nil {
+		func() {
+			defer func() { _ = recover() }()
+			s.settings().panicHandler(err)
+		}()
+	}
+
+	if restart {
+		s.begin() // Before this goroutine ends, so that WaitDone keeps waiting
+		go s.restart()
+	}
+}
+
+// restart starts the shim's goroutine again after it was brought down by a
+// panic, as long as references to the shim are still held and nobody else has
+// started it already. The caller must have called begin on its behalf.
+func (s *Shim) restart() {
+	s.startAccess.Lock()
+	if s.closed.Load() || s.starting != nil || s.running.Load() || !s.held() {
+		s.startAccess.Unlock()
+		s.end()
+		return
+	}
+	st := &startup{done: make(chan struct{})}
+	s.starting = st
+	s.startAccess.Unlock()
+
+	s.log().Warn("comshim: restarting thread after panic")
+	s.start(st)
+}
@@ -0,0 +1,101 @@
+package comshim_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/NozomiNetworks/go-comshim"
+)
+
+// panickingLogger panics when the shim's thread reports that it has been
+// initialized.
+type panickingLogger struct {
+	recordingLogger
+}
+
+func (l *panickingLogger) Debug(msg string, args ...any) {
+	if msg == "comshim: thread initialized for COM" {
+		panic("injected panic: " + msg)
+	}
+}
+
+func TestPanicOnShimGoroutineIsRecovered(t *testing.T) {
+	s := comshim.New()
+	s.SetLogger(&panickingLogger{})
+
+	err := s.TryAdd(1)
+	if count := s.Count(); count != 0 {
+		t.Errorf("counter is %d after a failed TryAdd, want 0", count)
+	}
+
+	var perr *comshim.PanicError
+	if !errors.As(err, &perr) {
+		t.Fatalf("TryAdd returned %v, want a *PanicError", err)
+	}
+	if len(perr.Stack) == 0 {
+		t.Error("the recovered panic has no stack")
+	}
+	if s.IsRunning() {
+		t.Error("the shim reports running after its goroutine panicked")
+	}
+	if got := s.WaitDoneErr(); got != err {
+		t.Errorf("WaitDoneErr returned %v, want %v", got, err)
+	}
+}
+
+func TestEventPanic(t *testing.T) {
+	s := comshim.New()
+	events := make(chan comshim.Event, 16)
+	unsubscribe := s.Subscribe(func(ev comshim.Event) { events <- ev })
+	defer unsubscribe()
+	s.SetLogger(&panickingLogger{})
+
+	if err := s.TryAdd(1); err == nil {
+		t.Fatal("TryAdd succeeded although initialization panicked")
+	}
+	s.WaitDone()
+	for {
+		select {
+		case ev := <-events:
+			if ev.Kind != comshim.EventPanic {
+				continue
+			}
+			var perr *comshim.PanicError
+			if !errors.As(ev.Err, &perr) || ev.Err != s.Err() {
+				t.Errorf("panic event carries %v, want the *PanicError reported by Err, %v", ev.Err, s.Err())
+			}
+			return
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the panic event")
+		}
+	}
+}
+
+func TestWithStickyFailure(t *testing.T) {
+	s := comshim.New(comshim.WithStickyFailure())
+	s.SetLogger(&panickingLogger{})
+	first := s.TryAdd(1)
+	if first == nil {
+		t.Fatal("TryAdd succeeded although initialization panicked")
+	}
+	s.WaitDone()
+
+	s.SetLogger(nil)
+	if err := s.TryAdd(1); err != first {
+		t.Errorf("TryAdd after a failed start returned %v, want %v", err, first)
+	}
+	if starts := s.Stats().Starts; starts != 1 {
+		t.Errorf("shim was started %d times, want 1", starts)
+	}
+
+	s.Reset()
+	if err := s.Err(); err != nil {
+		t.Errorf("Err after Reset returned %v", err)
+	}
+	if err := s.TryAdd(1); err != nil {
+		t.Fatalf("TryAdd after Reset returned %v", err)
+	}
+	s.Done()
+	s.WaitDone()
+}
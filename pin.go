@@ -0,0 +1,73 @@
+package comshim
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/go-ole/go-ole"
+)
+
+// PinCurrentGoroutine locks the calling goroutine to its OS thread and
+// initializes COM on that thread for apt, which must be ApartmentSTA or
+// ApartmentMTA, using the shim's other settings. This is for callers that need
+// COM on their own goroutine, such as users of thread-affine objects, rather
+// than on the shim's thread.
+//
+// unpin uninitializes COM and unlocks the thread. It must be called on the
+// same goroutine, and only has an effect the first time. Until it is called,
+// the goroutine counts as one of the shim's, so WaitDone, and with it Close,
+// waits for it.
+//
+// A goroutine whose thread was already initialized for COM gets the same
+// treatment as the shim's own thread: ErrAlreadyInitialized unless the shim
+// was created with WithReuseExistingInit.
+func (s *Shim) PinCurrentGoroutine(apt ApartmentType) (unpin func(), err error) {
+	var coinit uint32
+	switch apt {
+	case ApartmentSTA:
+		coinit = s.settings().coinit | ole.COINIT_APARTMENTTHREADED
+	case ApartmentMTA:
+		coinit = s.settings().coinit &^ ole.COINIT_APARTMENTTHREADED
+	default:
+		return nil, fmt.Errorf("component object model shim cannot pin a goroutine to apartment %v", apt)
+	}
+
+	s.startAccess.Lock()
+	if s.closed.Load() {
+		s.startAccess.Unlock()
+		return nil, ErrClosed
+	}
+	s.begin()
+	s.startAccess.Unlock()
+
+	runtime.LockOSThread()
+	err = s.initializeWith(coinit)
+	if err != nil && hresultCode(err) == sFalse {
+		if s.settings().reuseExistingInit {
+			err = nil
+		} else {
+			s.uninitialize()
+			err = &InitError{Op: s.initOp(), HRESULT: sFalse, Err: ErrAlreadyInitialized}
+		}
+	} else if err != nil {
+		err = newInitError(s.initOp(), err)
+	}
+	if err != nil {
+		runtime.UnlockOSThread()
+		s.end()
+		return nil, err
+	}
+	s.log().Debug("comshim: goroutine pinned", "thread", currentThreadID(), "apartment", apt)
+
+	unpinned := false
+	return func() {
+		if unpinned {
+			return
+		}
+		unpinned = true
+		s.uninitialize()
+		runtime.UnlockOSThread()
+		s.end()
+		s.log().Debug("comshim: goroutine unpinned")
+	}, nil
+}
@@ -0,0 +1,208 @@
+package comshim
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-ole/go-ole"
+)
+
+// Pool maintains several COM-initialized threads and runs submitted functions
+// on whichever is least busy. Each thread belongs to a shim of its own, so a
+// function has the same thread affinity guarantees as one passed to Run, while
+// independent functions can run in parallel.
+type Pool struct {
+	m       sync.RWMutex
+	workers []*poolWorker // The threads functions are submitted to; protected by m
+	retired []*poolWorker // Workers removed by ScaleWorkers that may still be running; protected by m
+	closed  bool          // Set by Close; protected by m
+	newShim func(...Option) *Shim
+	opts    []Option
+	lazy    bool // Threads only run while functions are submitted to them
+}
+
+// poolWorker is one of the threads of a pool.
+type poolWorker struct {
+	shim *Shim
+	load atomic.Int64 // Functions submitted to the worker that haven't returned
+}
+
+// NewPool starts a pool of n threads, each initialized according to opts. If n
+// is less than one, the pool has a single thread. The threads stay initialized
+// until the pool is closed.
+//
+// If any of the threads cannot be started, the others are released again and
+// the error is returned.
+func NewPool(n int, opts ...Option) (*Pool, error) {
+	if n < 1 {
+		n = 1
+	}
+	p := &Pool{workers: make([]*poolWorker, 0, n), newShim: New, opts: opts}
+	for i := 0; i < n; i++ {
+		w, err := p.newWorker()
+		if err != nil {
+			p.Close(context.Background())
+			return nil, err
+		}
+		p.workers = append(p.workers, w)
+	}
+	return p, nil
+}
+
+// newLazyPool returns a pool of n shims created with newShim, whose threads
+// only run while functions are submitted to them, rather than until the pool
+// is closed.
+func newLazyPool(n int, newShim func(...Option) *Shim, opts ...Option) *Pool {
+	if n < 1 {
+		n = 1
+	}
+	p := &Pool{workers: make([]*poolWorker, n), newShim: newShim, opts: opts, lazy: true}
+	for i := range p.workers {
+		p.workers[i], _ = p.newWorker() // Lazy workers can't fail
+	}
+	return p
+}
+
+// newWorker returns a new worker for the pool, with its thread started
+// unless the pool is lazy.
+func (p *Pool) newWorker() (*poolWorker, error) {
+	s := p.newShim(p.opts...)
+	if !p.lazy {
+		if err := s.hold(1); err != nil {
+			s.Close(context.Background()) // Can't fail without a deadline
+			return nil, err
+		}
+	}
+	return &poolWorker{shim: s}, nil
+}
+
+// finished reports whether the worker has no functions outstanding and its
+// shim's goroutine has exited.
+func (w *poolWorker) finished() bool {
+	if w.load.Load() != 0 {
+		return false
+	}
+	w.shim.exitAccess.Lock()
+	defer w.shim.exitAccess.Unlock()
+	return w.shim.active == 0
+}
+
+// Size returns the number of threads in the pool.
+func (p *Pool) Size() int {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return len(p.workers)
+}
+
+// ScaleWorkers grows or shrinks the pool to n threads, at least one, for
+// example to absorb a burst of work and give the threads back afterwards.
+// Functions can be submitted throughout.
+//
+// New threads are started before ScaleWorkers returns; if one can't be
+// started, the error is returned and the pool keeps the threads started so
+// far. Surplus threads stop receiving functions right away, but finish the
+// ones already submitted to them before uninitializing COM in the
+// background. Close waits for them as well.
+//
+// Only pools of multithreaded apartments can be scaled: objects living in a
+// single-threaded apartment would be lost with its thread, so ScaleWorkers
+// returns ErrNotSupported for them. It returns ErrClosed once the pool has
+// been closed.
+func (p *Pool) ScaleWorkers(n int) error {
+	if n < 1 {
+		n = 1
+	}
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+	if p.workers[0].shim.settings().coinit&ole.COINIT_APARTMENTTHREADED != 0 {
+		return ErrNotSupported
+	}
+
+	for len(p.workers) < n {
+		w, err := p.newWorker()
+		if err != nil {
+			return err
+		}
+		p.workers = append(p.workers, w)
+	}
+
+	// Workers retired earlier are forgotten once they are done for good: they
+	// can't be claimed anymore, so nothing can start their threads again
+	retired := p.retired[:0]
+	for _, w := range p.retired {
+		if !w.finished() {
+			retired = append(retired, w)
+		}
+	}
+	p.retired = retired
+
+	for len(p.workers) > n {
+		w := p.workers[len(p.workers)-1]
+		p.workers[len(p.workers)-1] = nil
+		p.workers = p.workers[:len(p.workers)-1]
+		if !p.lazy {
+			w.shim.done(nil) // The functions submitted to it hold their own references
+		}
+		p.retired = append(p.retired, w)
+	}
+	return nil
+}
+
+// Submit runs fn on the least busy of the pool's threads and returns its
+// result. If every thread is busy, fn is queued behind the functions already
+// submitted to the chosen thread. A panic in fn is recovered and returned as a
+// *PanicError.
+func (p *Pool) Submit(fn func() error) error {
+	return p.SubmitContext(context.Background(), fn)
+}
+
+// SubmitContext is like Submit, but stops waiting and returns ctx.Err() if ctx
+// is done before fn has finished. If fn has not started by then it will not be
+// run at all.
+func (p *Pool) SubmitContext(ctx context.Context, fn func() error) error {
+	w := p.claim()
+	defer w.load.Add(-1)
+	return w.shim.RunContext(ctx, fn)
+}
+
+// claim returns the worker with the fewest outstanding functions, having
+// already counted the caller's function against it. Concurrent callers are
+// spread over different workers.
+func (p *Pool) claim() *poolWorker {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	for {
+		best := p.workers[0]
+		load := best.load.Load()
+		for _, w := range p.workers[1:] {
+			if l := w.load.Load(); l < load {
+				best, load = w, l
+			}
+		}
+		if best.load.CompareAndSwap(load, load+1) {
+			return best
+		}
+	}
+}
+
+// Close releases every thread of the pool and waits for them to uninitialize
+// COM, like Shim.Close. Functions that are already queued still run; later
+// submissions return ErrClosed.
+func (p *Pool) Close(ctx context.Context) error {
+	p.m.Lock()
+	p.closed = true
+	workers := append(p.workers[:len(p.workers):len(p.workers)], p.retired...)
+	p.m.Unlock()
+
+	var first error
+	for _, w := range workers {
+		if err := w.shim.Close(ctx); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
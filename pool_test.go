@@ -0,0 +1,108 @@
+package comshim_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NozomiNetworks/go-comshim"
+	"github.com/go-ole/go-ole"
+)
+
+func TestPoolRunsInParallel(t *testing.T) {
+	const size = 4
+	p, err := comshim.NewPool(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Size() != size {
+		t.Errorf("pool has %d threads, want %d", p.Size(), size)
+	}
+
+	// Every function waits for all of them to have started, which can only
+	// happen if each runs on a thread of its own
+	var started sync.WaitGroup
+	started.Add(size)
+	var wg sync.WaitGroup
+	for i := 0; i < size; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := p.SubmitContext(ctx, func() error {
+				started.Done()
+				started.Wait()
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := p.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Submit(func() error { return nil }); !errors.Is(err, comshim.ErrClosed) {
+		t.Errorf("Submit after Close returned %v, want %v", err, comshim.ErrClosed)
+	}
+}
+
+func TestPoolScaleWorkers(t *testing.T) {
+	p, err := comshim.NewPool(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := p.Submit(func() error { return nil }); err != nil {
+					t.Errorf("Submit while scaling returned %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	for _, n := range []int{8, 1, 4, 2, 6, 1} {
+		if err := p.ScaleWorkers(n); err != nil {
+			t.Fatal(err)
+		}
+		if p.Size() != n {
+			t.Errorf("pool has %d threads after scaling to %d", p.Size(), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(stop)
+	wg.Wait()
+
+	if err := p.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ScaleWorkers(4); !errors.Is(err, comshim.ErrClosed) {
+		t.Errorf("ScaleWorkers after Close returned %v, want %v", err, comshim.ErrClosed)
+	}
+
+	sta, err := comshim.NewPool(1, comshim.WithCoInitFlags(ole.COINIT_APARTMENTTHREADED))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sta.Close(context.Background())
+	if err := sta.ScaleWorkers(2); !errors.Is(err, comshim.ErrNotSupported) {
+		t.Errorf("ScaleWorkers on an STA pool returned %v, want %v", err, comshim.ErrNotSupported)
+	}
+}
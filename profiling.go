@@ -0,0 +1,24 @@
+package comshim
+
+import (
+	"context"
+	"runtime/pprof"
+)
+
+// profileLabel is the pprof label attached to the shim's goroutine.
+const profileLabel = "comshim"
+
+// labelGoroutine attaches a pprof label to the calling goroutine so that CPU
+// and goroutine profiles attribute it, and the thread it has locked, to this
+// package. The label's value is the thread name set by WithThreadName, or the
+// apartment otherwise. The returned context carries the label and is meant for
+// runtime/trace regions.
+func (s *Shim) labelGoroutine() context.Context {
+	name := s.settings().threadName
+	if name == "" {
+		name = s.apartmentName()
+	}
+	ctx := pprof.WithLabels(context.Background(), pprof.Labels(profileLabel, name))
+	pprof.SetGoroutineLabels(ctx)
+	return ctx
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+
+package comshim
+
+// messagePump stands in for the window message pump on platforms without
+// one. It simply blocks until woken.
+type messagePump struct {
+	woken chan struct{}
+}
+
+func newMessagePump() (*messagePump, error) {
+	return &messagePump{woken: make(chan struct{}, 1)}, nil
+}
+
+// wake causes the current or next call to wait to return.
+func (p *messagePump) wake() {
+	select {
+	case p.woken <- struct{}{}:
+	default:
+	}
+}
+
+// wait blocks until wake is called.
+func (p *messagePump) wait() {
+	<-p.woken
+}
+
+// close releases the resources held by the pump.
+func (p *messagePump) close() {}
@@ -0,0 +1,84 @@
+//go:build windows
+
+package comshim
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	moduser32 = windows.NewLazySystemDLL("user32.dll")
+
+	procMsgWaitForMultipleObjectsEx = moduser32.NewProc("MsgWaitForMultipleObjectsEx")
+	procPeekMessageW                = moduser32.NewProc("PeekMessageW")
+	procTranslateMessage            = moduser32.NewProc("TranslateMessage")
+	procDispatchMessageW            = moduser32.NewProc("DispatchMessageW")
+)
+
+const (
+	qsAllInput         = 0x04FF // QS_ALLINPUT
+	mwmoInputAvailable = 0x0004 // MWMO_INPUTAVAILABLE
+	pmRemove           = 0x0001 // PM_REMOVE
+)
+
+// msg mirrors the MSG structure used by the window message functions.
+type msg struct {
+	hwnd    uintptr
+	message uint32
+	wParam  uintptr
+	lParam  uintptr
+	time    uint32
+	pt      struct{ x, y int32 }
+	private uint32
+}
+
+// messagePump dispatches window messages on a single-threaded apartment while
+// it waits to be woken. Only the thread that owns the apartment may wait on
+// it, but any goroutine may wake it.
+type messagePump struct {
+	event windows.Handle // An auto-reset event signaled by wake
+}
+
+func newMessagePump() (*messagePump, error) {
+	event, err := windows.CreateEvent(nil, 0, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &messagePump{event: event}, nil
+}
+
+// wake causes the current or next call to wait to return.
+func (p *messagePump) wake() {
+	windows.SetEvent(p.event)
+}
+
+// wait dispatches window messages until wake is called or a message has been
+// dispatched, whichever comes first.
+func (p *messagePump) wait() {
+	r, _, _ := procMsgWaitForMultipleObjectsEx.Call(
+		1,
+		uintptr(unsafe.Pointer(&p.event)),
+		windows.INFINITE,
+		qsAllInput,
+		mwmoInputAvailable,
+	)
+	if uint32(r) == windows.WAIT_FAILED {
+		return
+	}
+
+	var m msg
+	for {
+		if r, _, _ := procPeekMessageW.Call(uintptr(unsafe.Pointer(&m)), 0, 0, 0, pmRemove); r == 0 {
+			return
+		}
+		procTranslateMessage.Call(uintptr(unsafe.Pointer(&m)))
+		procDispatchMessageW.Call(uintptr(unsafe.Pointer(&m)))
+	}
+}
+
+// close releases the resources held by the pump.
+func (p *messagePump) close() {
+	windows.CloseHandle(p.event)
+}
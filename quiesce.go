@@ -0,0 +1,24 @@
+package comshim
+
+import "context"
+
+// Quiesce waits until every function queued for the shim's thread when it was
+// called has finished, and no function is running, without waiting for the
+// counter to drop to zero. This is useful before suspending the process,
+// taking a checkpoint or reloading configuration that COM code depends on.
+// Functions submitted afterwards are not waited for, unless they were passed
+// to RunPriority and jump ahead; Quiesce doesn't stop them from being queued.
+//
+// Like Ping, Quiesce doesn't hold a reference. A shim that isn't running has
+// nothing in flight, so Quiesce returns nil right away, as it does on shims
+// holding an MTA usage cookie, whose functions run on the goroutines that
+// submit them. If ctx is done first, Quiesce returns ctx.Err().
+func (s *Shim) Quiesce(ctx context.Context) error {
+	if s.settings().shared != nil {
+		return s.settings().shared.Quiesce(ctx)
+	}
+	if err := s.pass(ctx, false); err != ErrStopped {
+		return err
+	}
+	return nil // The queue was emptied when the thread stopped
+}
@@ -0,0 +1,27 @@
+package comshim
+
+import "sync"
+
+var (
+	registryAccess sync.Mutex
+	registry       = map[string]*Shim{}
+)
+
+// Named returns the process-wide shim registered under name, creating it with
+// opts on first use. Later calls with the same name return the same shim and
+// ignore opts.
+//
+// Named shims let independent subsystems of a process own separate lifetimes,
+// so that each can be shut down on its own, without having to pass a *Shim
+// around. They are independent of the shim used by the package-level
+// functions.
+func Named(name string, opts ...Option) *Shim {
+	registryAccess.Lock()
+	defer registryAccess.Unlock()
+	s, ok := registry[name]
+	if !ok {
+		s = New(opts...)
+		registry[name] = s
+	}
+	return s
+}
@@ -0,0 +1,26 @@
+package comshim_test
+
+import (
+	"testing"
+
+	"github.com/NozomiNetworks/go-comshim"
+)
+
+func TestNamed(t *testing.T) {
+	wmi := comshim.Named("test-wmi")
+	if again := comshim.Named("test-wmi"); again != wmi {
+		t.Error("Named returned a different shim for the same name")
+	}
+
+	excel := comshim.Named("test-excel")
+	if excel == wmi {
+		t.Fatal("Named returned the same shim for different names")
+	}
+
+	wmi.Add(1)
+	defer wmi.WaitDone()
+	defer wmi.Done()
+	if excel.Count() != 0 {
+		t.Errorf("adding to one named shim changed another to %d", excel.Count())
+	}
+}
@@ -0,0 +1,119 @@
+package comshim
+
+import "context"
+
+// Reinitialize tears the shim's apartment down and brings it up again with
+// opts applied on top of the options it was created with, such as a
+// different WithSecurity or WithCoInitFlags, without the holders of
+// references having to give them back. The counter is left as it is: once
+// the functions queued when Reinitialize was called have finished, the
+// thread is uninitialized as if the counter had dropped to zero and, if
+// references are still held, started again right away. Functions submitted
+// in the meantime wait for the new thread, as do callers of TryAdd that find
+// the shim stopped. If the shim isn't running, opts are applied and it starts
+// with them next time.
+//
+// COM objects created in the old apartment are gone with it: tracked objects
+// are released during the teardown, and holders must create their objects
+// again. Subscribers receive EventUninitialized and EventInitialized as
+// usual. Options that only take effect in New, such as WithEagerStart,
+// WithMaxLifetime and WithETW, are ignored.
+//
+// Reinitialize returns the error of the new start, if any. If ctx is done
+// first, it returns ctx.Err() and the shim finishes reinitializing in the
+// background. A shim that shares the thread of another passes the call on to
+// it, and a closed or draining shim returns ErrClosed or ErrDraining.
+func (s *Shim) Reinitialize(ctx context.Context, opts ...Option) error {
+	if s.settings().shared != nil {
+		return s.settings().shared.Reinitialize(ctx, opts...)
+	}
+	if err := s.Quiesce(ctx); err != nil {
+		return err
+	}
+
+	// Claiming the start keeps anyone else from starting the shim with the
+	// old options, and makes them wait for the new apartment instead
+	s.startAccess.Lock()
+	for s.starting != nil {
+		st := s.starting
+		s.startAccess.Unlock()
+		select {
+		case <-st.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		s.startAccess.Lock()
+	}
+	if s.closed.Load() {
+		s.startAccess.Unlock()
+		return ErrClosed
+	}
+	if s.draining.Load() {
+		s.startAccess.Unlock()
+		return ErrDraining
+	}
+	st := &startup{done: make(chan struct{})}
+	s.starting = st
+	s.startAccess.Unlock()
+
+	s.log().Debug("comshim: reinitializing", "count", s.Count())
+	s.signalAccess.Lock()
+	s.bouncing.Store(true)
+	if s.cookie != 0 {
+		s.releaseMTAUsage()
+	}
+	if s.adopted {
+		s.releaseAdopted()
+	}
+	s.signal()
+	s.signalAccess.Unlock()
+
+	go s.bounce(st, opts)
+	select {
+	case <-st.done:
+		return st.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// bounce finishes the work of Reinitialize once the shim's goroutine has been
+// asked to stop: it waits for the goroutine to terminate, applies opts and
+// starts the shim again if references are still held, sharing the result of
+// the start through st.
+func (s *Shim) bounce(st *startup, opts []Option) {
+	s.WaitDone()
+
+	cfg := *s.settings()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	s.startAccess.Lock()
+	s.signalAccess.Lock()
+	s.cfg.Store(&cfg)
+	s.bouncing.Store(false)
+	held := s.held()
+	s.signalAccess.Unlock()
+	s.failed = nil
+	if !held {
+		s.starting = nil
+		s.startAccess.Unlock()
+		close(st.done)
+		return
+	}
+	s.begin()
+	s.startAccess.Unlock()
+	if cfg.logger != nil {
+		s.SetLogger(cfg.logger)
+	}
+
+	s.start(st)
+
+	// Functions queued in the meantime are left for the new thread, if there
+	// is one to run them
+	s.signalAccess.Lock()
+	if !s.running.Load() || s.cookie != 0 || s.adopted {
+		s.abandonTasks()
+	}
+	s.signalAccess.Unlock()
+}
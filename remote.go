@@ -0,0 +1,46 @@
+package comshim
+
+import "github.com/go-ole/go-ole"
+
+// AuthInfo holds the identity and security settings used to activate an
+// object on another machine with CreateRemoteObject. If User is empty, the
+// identity of the calling process is used.
+type AuthInfo struct {
+	Domain   string
+	User     string
+	Password string
+
+	AuthnLevel   AuthnLevel
+	ImpLevel     ImpLevel
+	Capabilities Capabilities
+}
+
+// CreateRemoteObject is like CreateObject, but activates the object on server,
+// the name or address of another machine, using DCOM. The activation request is
+// authenticated according to auth.
+//
+// auth only applies to the activation. Calls made through the returned proxy
+// use the process-wide security settings unless the shim was created with
+// WithProxyBlanket, or the proxy's blanket is changed with CoSetProxyBlanket.
+func (s *Shim) CreateRemoteObject(server string, clsid, iid *ole.GUID, auth AuthInfo) (obj *ole.IUnknown, release func(), err error) {
+	if err := s.hold(1); err != nil {
+		return nil, nil, err
+	}
+	err = s.Run(func() (err error) {
+		obj, err = coCreateInstanceEx(server, clsid, iid, auth)
+		if err != nil {
+			return err
+		}
+		if err := s.applyBlanket(obj); err != nil {
+			obj.Release()
+			obj = nil
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		s.done(nil)
+		return nil, nil, err
+	}
+	return obj, s.own(obj), nil
+}
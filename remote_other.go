@@ -0,0 +1,10 @@
+//go:build !windows
+
+package comshim
+
+import "github.com/go-ole/go-ole"
+
+// coCreateInstanceEx activates clsid on server and returns its iid interface.
+func coCreateInstanceEx(server string, clsid, iid *ole.GUID, auth AuthInfo) (*ole.IUnknown, error) {
+	return nil, ole.NewError(ole.E_NOTIMPL)
+}
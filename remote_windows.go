@@ -0,0 +1,116 @@
+//go:build windows
+
+package comshim
+
+import (
+	"runtime"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+	"golang.org/x/sys/windows"
+)
+
+var procCoCreateInstanceEx = modole32.NewProc("CoCreateInstanceEx")
+
+const (
+	clsctxRemoteServer = 0x10
+
+	rpcCAuthnDefault = 0xFFFFFFFF // Let COM choose the authentication service
+	rpcCAuthzNone    = 0
+
+	secWinNTAuthIdentityUnicode = 0x2
+)
+
+// coAuthIdentity is COAUTHIDENTITY.
+type coAuthIdentity struct {
+	user           *uint16
+	userLength     uint32
+	domain         *uint16
+	domainLength   uint32
+	password       *uint16
+	passwordLength uint32
+	flags          uint32
+}
+
+// coAuthInfo is COAUTHINFO.
+type coAuthInfo struct {
+	authnSvc           uint32
+	authzSvc           uint32
+	serverPrincName    *uint16
+	authnLevel         uint32
+	impersonationLevel uint32
+	authIdentityData   *coAuthIdentity
+	capabilities       uint32
+}
+
+// coServerInfo is COSERVERINFO.
+type coServerInfo struct {
+	reserved1 uint32
+	name      *uint16
+	authInfo  *coAuthInfo
+	reserved2 uint32
+}
+
+// multiQI is MULTI_QI.
+type multiQI struct {
+	iid *ole.GUID
+	itf *ole.IUnknown
+	hr  uintptr // An HRESULT, padded to pointer size like the structure
+}
+
+// coCreateInstanceEx activates clsid on server and returns its iid interface.
+func coCreateInstanceEx(server string, clsid, iid *ole.GUID, auth AuthInfo) (*ole.IUnknown, error) {
+	name, err := windows.UTF16PtrFromString(server)
+	if err != nil {
+		return nil, err
+	}
+	info := &coAuthInfo{
+		authnSvc:           rpcCAuthnDefault,
+		authzSvc:           rpcCAuthzNone,
+		authnLevel:         uint32(auth.AuthnLevel),
+		impersonationLevel: uint32(auth.ImpLevel),
+		capabilities:       uint32(auth.Capabilities),
+	}
+	if auth.User != "" {
+		user, err := windows.UTF16FromString(auth.User)
+		if err != nil {
+			return nil, err
+		}
+		domain, err := windows.UTF16FromString(auth.Domain)
+		if err != nil {
+			return nil, err
+		}
+		password, err := windows.UTF16FromString(auth.Password)
+		if err != nil {
+			return nil, err
+		}
+		// The lengths exclude the terminating NUL
+		info.authIdentityData = &coAuthIdentity{
+			user:           &user[0],
+			userLength:     uint32(len(user) - 1),
+			domain:         &domain[0],
+			domainLength:   uint32(len(domain) - 1),
+			password:       &password[0],
+			passwordLength: uint32(len(password) - 1),
+			flags:          secWinNTAuthIdentityUnicode,
+		}
+	}
+	serverInfo := &coServerInfo{name: name, authInfo: info}
+	results := []multiQI{{iid: iid}}
+
+	hr, _, _ := procCoCreateInstanceEx.Call(
+		uintptr(unsafe.Pointer(clsid)),
+		0,
+		clsctxRemoteServer,
+		uintptr(unsafe.Pointer(serverInfo)),
+		uintptr(len(results)),
+		uintptr(unsafe.Pointer(&results[0])))
+	runtime.KeepAlive(serverInfo)
+	if err := hresultError(hr); err != nil {
+		return nil, err
+	}
+	if err := hresultError(uintptr(uint32(results[0].hr))); err != nil {
+		return nil, err
+	}
+	return results[0].itf, nil
+}
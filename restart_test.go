@@ -0,0 +1,209 @@
+package comshim
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NozomiNetworks/go-comshim/internal/clock"
+)
+
+func TestRestartAfterPanic(t *testing.T) {
+	panics := make(chan *PanicError, 1)
+	s := New(WithPanicHandler(func(err *PanicError) { panics <- err }))
+	s.Add(1)
+
+	// Sending the result of a task on a closed channel panics on the shim's
+	// goroutine, outside of the protection given to the task itself
+	done := make(chan error)
+	close(done)
+	s.signalAccess.Lock()
+	s.tasks = append(s.tasks, &task{fn: func() error { return nil }, done: done})
+	s.signal()
+	s.signalAccess.Unlock()
+
+	select {
+	case <-panics:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the panic handler was not called")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.IsRunning() || s.starts.Value() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("shim was not restarted; running: %v, starts: %d", s.IsRunning(), s.starts.Value())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := s.Run(func() error { return nil }); err != nil {
+		t.Errorf("Run after the restart returned %v", err)
+	}
+
+	s.Done()
+	s.WaitDone()
+}
+
+func TestReinitialize(t *testing.T) {
+	s := New()
+	s.Add(2)
+
+	if err := s.Reinitialize(context.Background(), WithIdleTimeout(time.Minute)); err != nil {
+		t.Fatalf("Reinitialize returned %v", err)
+	}
+	if starts := s.starts.Value(); starts != 2 {
+		t.Errorf("shim was started %d times, want 2", starts)
+	}
+	if count := s.Count(); count != 2 {
+		t.Errorf("counter is %d after Reinitialize, want 2", count)
+	}
+	if !s.IsRunning() {
+		t.Error("shim is not running after Reinitialize")
+	}
+	if s.settings().idleTimeout != time.Minute {
+		t.Errorf("idle timeout is %v after Reinitialize, want %v", s.settings().idleTimeout, time.Minute)
+	}
+	if err := s.Run(func() error { return nil }); err != nil {
+		t.Errorf("Run after Reinitialize returned %v", err)
+	}
+
+	s.Done()
+	s.Done()
+	s.Close(context.Background())
+	s.WaitDone()
+
+	// A stopped shim takes the options for its next start
+	s = New()
+	if err := s.Reinitialize(context.Background(), WithIdleTimeout(time.Minute)); err != nil {
+		t.Fatalf("Reinitialize on a stopped shim returned %v", err)
+	}
+	if starts := s.starts.Value(); starts != 0 {
+		t.Errorf("stopped shim was started %d times by Reinitialize, want 0", starts)
+	}
+	if s.settings().idleTimeout != time.Minute {
+		t.Errorf("idle timeout is %v after Reinitialize, want %v", s.settings().idleTimeout, time.Minute)
+	}
+}
+
+func TestReinitializeRace(t *testing.T) {
+	s := New()
+	s.Add(1)
+
+	// Callers keep using the shim while its configuration is replaced
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			s.Add(1)
+			s.Done()
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		if err := s.Reinitialize(context.Background(), WithIdleTimeout(time.Duration(i)*time.Millisecond)); err != nil {
+			t.Errorf("Reinitialize returned %v", err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if count := s.Count(); count != 1 {
+		t.Errorf("counter is %d after Reinitialize, want 1", count)
+	}
+	s.Done()
+	s.Close(context.Background())
+	s.WaitDone()
+}
+
+// churn keeps taking and giving back references to s until stop is closed.
+func churn(s *Shim, stop <-chan struct{}, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if s.TryAdd(1) == nil {
+				s.Done()
+			}
+		}
+	}()
+}
+
+func TestPoisonRace(t *testing.T) {
+	c := clock.NewFake()
+	s := New(withClock(c))
+	s.Add(1)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	churn(s, stop, &wg)
+
+	// The thread is abandoned with the counter positive, and replaced
+	started := make(chan struct{})
+	release := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.RunTimeout(time.Minute, func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+	c.WaitTimers(1)
+	c.Advance(time.Minute)
+	if err := <-errc; err != context.DeadlineExceeded {
+		t.Errorf("RunTimeout returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := s.Run(func() error { return nil }); err != nil {
+		t.Errorf("Run on the replacement thread returned %v", err)
+	}
+	close(stop)
+	wg.Wait()
+	close(release)
+
+	if count := s.Count(); count != 1 {
+		t.Errorf("counter is %d after the thread was replaced, want 1", count)
+	}
+	if !s.IsRunning() {
+		t.Error("shim is not running after the thread was replaced")
+	}
+	s.Done()
+	s.WaitDone()
+}
+
+func TestCloseRace(t *testing.T) {
+	s := New()
+	s.Add(1)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	churn(s, stop, &wg)
+
+	// The thread goes down with the counter positive and stays down
+	time.Sleep(time.Millisecond)
+	if err := s.Close(context.Background()); err != nil {
+		t.Errorf("Close returned %v", err)
+	}
+	close(stop)
+	wg.Wait()
+
+	if s.IsRunning() {
+		t.Error("closed shim is still running")
+	}
+	if count := s.Count(); count != 1 {
+		t.Errorf("counter is %d after Close, want 1", count)
+	}
+	s.Done()
+	s.WaitDone()
+}
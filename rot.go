@@ -0,0 +1,79 @@
+package comshim
+
+import (
+	"sync"
+
+	"github.com/go-ole/go-ole"
+)
+
+// GetActiveObject attaches to a running instance of the class registered as
+// progID, such as "Excel.Application", through the Running Object Table, and
+// returns its IDispatch interface. The lookup runs on the shim's thread and,
+// like CreateDispatch, the shim holds a reference until the returned release
+// function is called.
+func (s *Shim) GetActiveObject(progID string) (disp *ole.IDispatch, release func(), err error) {
+	if err := s.hold(1); err != nil {
+		return nil, nil, err
+	}
+	err = s.Run(func() error {
+		clsid, err := ole.CLSIDFromProgID(progID)
+		if err != nil {
+			return err
+		}
+		unknown, err := ole.GetActiveObject(clsid, ole.IID_IUnknown)
+		if err != nil {
+			return err
+		}
+		defer unknown.Release()
+		disp, err = unknown.QueryInterface(ole.IID_IDispatch)
+		if err != nil {
+			return err
+		}
+		if err := s.applyBlanket(&disp.IUnknown); err != nil {
+			disp.Release()
+			disp = nil
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		s.done(nil)
+		return nil, nil, err
+	}
+	return disp, s.own(disp), nil
+}
+
+// RegisterActiveObject registers obj in the Running Object Table as the
+// active object of the class clsid, so that other processes can attach to it
+// with GetActiveObject. Registration runs on the shim's thread, which should
+// be the thread that created obj, and the table holds a strong reference to
+// obj until revoke is called.
+//
+// The shim holds a reference of its own until then, so that its apartment
+// outlives the registration. revoke removes the registration on the shim's
+// thread and gives the reference back; it only has an effect the first time.
+func (s *Shim) RegisterActiveObject(obj *ole.IUnknown, clsid *ole.GUID) (revoke func() error, err error) {
+	if err := s.hold(1); err != nil {
+		return nil, err
+	}
+	var registration uint32
+	err = s.Run(func() (err error) {
+		registration, err = registerActiveObject(obj, clsid)
+		return err
+	})
+	if err != nil {
+		s.done(nil)
+		return nil, err
+	}
+
+	var once sync.Once
+	return func() (err error) {
+		once.Do(func() {
+			err = s.Run(func() error {
+				return revokeActiveObject(registration)
+			})
+			s.done(nil)
+		})
+		return err
+	}, nil
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package comshim
+
+import "github.com/go-ole/go-ole"
+
+// registerActiveObject registers obj in the Running Object Table.
+func registerActiveObject(obj *ole.IUnknown, clsid *ole.GUID) (uint32, error) {
+	return 0, ole.NewError(ole.E_NOTIMPL)
+}
+
+// revokeActiveObject removes a registration made by registerActiveObject.
+func revokeActiveObject(registration uint32) error {
+	return ole.NewError(ole.E_NOTIMPL)
+}
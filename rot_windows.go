@@ -0,0 +1,35 @@
+//go:build windows
+
+package comshim
+
+import (
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modoleaut32 = windows.NewLazySystemDLL("oleaut32.dll")
+
+	procRegisterActiveObject = modoleaut32.NewProc("RegisterActiveObject")
+	procRevokeActiveObject   = modoleaut32.NewProc("RevokeActiveObject")
+)
+
+const activeObjectStrong = 0 // ACTIVEOBJECT_STRONG
+
+// registerActiveObject registers obj in the Running Object Table.
+func registerActiveObject(obj *ole.IUnknown, clsid *ole.GUID) (registration uint32, err error) {
+	hr, _, _ := procRegisterActiveObject.Call(
+		uintptr(unsafe.Pointer(obj)),
+		uintptr(unsafe.Pointer(clsid)),
+		activeObjectStrong,
+		uintptr(unsafe.Pointer(&registration)))
+	return registration, hresultError(hr)
+}
+
+// revokeActiveObject removes a registration made by registerActiveObject.
+func revokeActiveObject(registration uint32) error {
+	hr, _, _ := procRevokeActiveObject.Call(uintptr(registration), 0)
+	return hresultError(hr)
+}
@@ -0,0 +1,88 @@
+package comshim
+
+import "sync"
+
+// AuthnLevel is an RPC authentication level, as used by CoInitializeSecurity.
+type AuthnLevel uint32
+
+// Authentication levels, corresponding to the RPC_C_AUTHN_LEVEL constants.
+const (
+	AuthnLevelDefault      AuthnLevel = 0
+	AuthnLevelNone         AuthnLevel = 1
+	AuthnLevelConnect      AuthnLevel = 2
+	AuthnLevelCall         AuthnLevel = 3
+	AuthnLevelPkt          AuthnLevel = 4
+	AuthnLevelPktIntegrity AuthnLevel = 5
+	AuthnLevelPktPrivacy   AuthnLevel = 6
+)
+
+// ImpLevel is an RPC impersonation level, as used by CoInitializeSecurity.
+type ImpLevel uint32
+
+// Impersonation levels, corresponding to the RPC_C_IMP_LEVEL constants.
+const (
+	ImpLevelDefault     ImpLevel = 0
+	ImpLevelAnonymous   ImpLevel = 1
+	ImpLevelIdentify    ImpLevel = 2
+	ImpLevelImpersonate ImpLevel = 3
+	ImpLevelDelegate    ImpLevel = 4
+)
+
+// Capabilities is a set of EOLE_AUTHENTICATION_CAPABILITIES flags, as used by
+// CoInitializeSecurity.
+type Capabilities uint32
+
+// Authentication capabilities, corresponding to the EOAC constants.
+const (
+	CapabilitiesNone          Capabilities = 0x0000
+	CapabilitiesMutualAuth    Capabilities = 0x0001
+	CapabilitiesSecureRefs    Capabilities = 0x0002
+	CapabilitiesStaticCloak   Capabilities = 0x0020
+	CapabilitiesDynamicCloak  Capabilities = 0x0040
+	CapabilitiesDisableAAA    Capabilities = 0x1000
+	CapabilitiesNoCustomMarsh Capabilities = 0x2000
+)
+
+// SecurityConfig holds the process-wide security settings passed to
+// CoInitializeSecurity by a shim created with WithSecurity. COM chooses the
+// authentication services and the default security descriptor is used.
+type SecurityConfig struct {
+	AuthnLevel   AuthnLevel
+	ImpLevel     ImpLevel
+	Capabilities Capabilities
+}
+
+// security tracks whether any shim has called CoInitializeSecurity, which may
+// only succeed once per process.
+var security struct {
+	sync.Mutex
+	applied bool
+	config  SecurityConfig // The settings that were applied
+}
+
+// initializeSecurity calls CoInitializeSecurity with cfg unless a shim has
+// already done so. It must be called on a thread that is initialized for COM.
+func initializeSecurity(cfg SecurityConfig) error {
+	security.Lock()
+	defer security.Unlock()
+	if security.applied {
+		return nil
+	}
+	if err := coInitializeSecurity(cfg); err != nil {
+		return err
+	}
+	security.applied = true
+	security.config = cfg
+	return nil
+}
+
+// SecurityConfigApplied returns the settings that a shim passed to
+// CoInitializeSecurity. Only the first call in the process takes effect, so a
+// component that needs particular settings can compare them with its own and
+// warn about a mismatch, which otherwise shows up as puzzling authentication
+// failures. ok is false if no shim has initialized security.
+func SecurityConfigApplied() (cfg SecurityConfig, ok bool) {
+	security.Lock()
+	defer security.Unlock()
+	return security.config, security.applied
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package comshim
+
+// coInitializeSecurity does nothing on platforms without COM.
+func coInitializeSecurity(cfg SecurityConfig) error {
+	return nil
+}
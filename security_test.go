@@ -0,0 +1,34 @@
+package comshim_test
+
+import (
+	"testing"
+
+	"github.com/NozomiNetworks/go-comshim"
+)
+
+func TestWithSecurityAppliesOnce(t *testing.T) {
+	cfg := comshim.SecurityConfig{
+		AuthnLevel: comshim.AuthnLevelDefault,
+		ImpLevel:   comshim.ImpLevelImpersonate,
+	}
+	s := comshim.New(comshim.WithSecurity(cfg))
+	defer s.WaitDone()
+
+	// Restarting the thread must not call CoInitializeSecurity again, which
+	// would fail with RPC_E_TOO_LATE
+	for i := 0; i < 2; i++ {
+		if err := s.TryAdd(1); err != nil {
+			t.Fatal(err)
+		}
+		s.Done()
+		s.WaitDone()
+	}
+
+	applied, ok := comshim.SecurityConfigApplied()
+	if !ok {
+		t.Fatal("SecurityConfigApplied reports that security wasn't initialized")
+	}
+	if applied != cfg {
+		t.Errorf("SecurityConfigApplied returned %+v, want %+v", applied, cfg)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package comshim
+
+import "github.com/go-ole/go-ole"
+
+func coInitializeSecurity(cfg SecurityConfig) error {
+	return ole.CoInitializeSecurity(-1, uint32(cfg.AuthnLevel), uint32(cfg.ImpLevel), uint32(cfg.Capabilities))
+}
@@ -0,0 +1,72 @@
+package comshim
+
+// SharedThread is a COM-initialized thread that several shims share, so that
+// subsystems owning separate shims don't each lock an OS thread of their own.
+// Every shim returned by NewShim keeps its own counter, and holds a single
+// reference to the shared thread while its counter is positive. The thread is
+// released once the counters of all of them have dropped to zero.
+type SharedThread struct {
+	shim *Shim
+}
+
+// NewSharedThread returns a new shared thread, initialized according to opts
+// when it is needed.
+func NewSharedThread(opts ...Option) *SharedThread {
+	return &SharedThread{shim: New(opts...)}
+}
+
+// NewShim returns a new shim that runs on the shared thread. opts configure the
+// shim's own bookkeeping, such as WithLogger or WithMaxHolders; options that
+// concern the thread itself only apply when given to NewSharedThread.
+//
+// Functions passed to Run and objects passed to Track end up on the shared
+// thread. Tracked objects are therefore released when the shared thread is,
+// rather than when the shim's counter drops to zero.
+func (t *SharedThread) NewShim(opts ...Option) *Shim {
+	return New(append(opts, withSharedThread(t.shim))...)
+}
+
+// Shim returns the shim that owns the shared thread. Its counter is the number
+// of shims that are currently holding the thread, plus any references taken
+// on it directly.
+func (t *SharedThread) Shim() *Shim {
+	return t.shim
+}
+
+func withSharedThread(s *Shim) Option {
+	return func(c *config) {
+		c.shared = s
+	}
+}
+
+// startShared takes the place of the shim's goroutine when the shim runs on a
+// shared thread.
+func (s *Shim) startShared() error {
+	defer s.end()
+
+	if err := s.settings().shared.TryAdd(1); err != nil {
+		return err
+	}
+
+	s.signalAccess.Lock()
+	s.sharing = true
+	s.setRunning(true)
+	if !s.held() || s.closed.Load() {
+		// Every reference was dropped, or the shim was closed, while the
+		// shared thread was being started
+		s.releaseShared()
+	}
+	s.signalAccess.Unlock()
+
+	s.log().Debug("comshim: holding shared thread")
+	return nil
+}
+
+// releaseShared gives back the shim's reference to the shared thread. It must
+// be called while holding signalAccess.
+func (s *Shim) releaseShared() {
+	s.setRunning(false)
+	s.sharing = false
+	s.settings().shared.Done()
+	s.emit(EventUninitialized, nil)
+}
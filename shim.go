@@ -1,14 +1,20 @@
 package comshim
 
 import (
+	"context"
+	"errors"
 	"runtime"
+	"runtime/trace"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/NozomiNetworks/go-comshim/internal/clock"
 	"github.com/go-ole/go-ole"
 )
 
 // Shim provides control of a thread-locked goroutine that has been initialized
-// for use with a mulithreaded component object model apartment. This is used
+// for use with a component object model apartment. This is used
 // to ensure that at least one thread within a process maintains an
 // initialized connection to COM, and thus prevents COM resources from being
 // unloaded from that process.
@@ -16,24 +22,126 @@ import (
 // Control is implemented through the use of a counter similar to a waitgroup.
 // As long as the counter is greater than zero then the goroutine will remain
 // in a blocked condition with its COM connection intact.
+//
+// By default the thread joins the multithreaded apartment. Shims created by
+// NewSTA instead initialize a single-threaded apartment and pump window
+// messages while the counter is greater than zero.
 type Shim struct {
+	// cfg holds the settings, which are only replaced as a whole by
+	// Reinitialize while it holds startAccess and signalAccess.
+	cfg atomic.Pointer[config]
+
+	// The locks are taken in the order startAccess, signalAccess, and then
+	// exitAccess or errAccess; no code takes startAccess while holding
+	// signalAccess. They uphold these invariants:
+	//
+	//   - A cold start is only begun while holding startAccess, which is
+	//     also held to change closed, draining and starting.
+	//   - The counter only rises from zero or drops to zero while holding
+	//     signalAccess. addFast changes it without locks, but only while
+	//     the counter stays positive, the thread is running and the shim
+	//     isn't closed, draining or bouncing.
+	//   - The counter never leaves the range from zero to the limit set by
+	//     WithMaxHolders, not even for a moment.
+	//   - running only changes while holding signalAccess, and is set to
+	//     false before it is released by whoever stops the thread. The
+	//     thread decides to stop while holding it, after seeing the counter
+	//     at zero or the shim closed or bouncing; poison also gives up on a
+	//     stuck thread while holding it. Only in those last three cases can
+	//     the thread stop with the counter positive: a closed shim stays
+	//     stopped, while Reinitialize and poison start a new thread if the
+	//     counter is still positive. So a caller of add that sees running
+	//     as true has kept the thread, unless the shim is being closed or
+	//     the thread replaced.
 	startAccess  sync.RWMutex
-	running      bool
+	starting     *startup    // Non-nil while a cold start is in progress
+	failed       error       // The error of the last failed start until Reset; protected by startAccess
+	running      atomic.Bool // Only changed while holding signalAccess
+	closed       atomic.Bool // Set by Close while holding startAccess
+	keepAlive    atomic.Bool // Set by Start or WithPermanent to keep the thread regardless of the counter
+	draining     atomic.Bool // Set by Drain while holding startAccess
+	unbalanced   atomic.Bool // Set if the stop channel closed the shim with references outstanding
 	cond         sync.Cond
 	signalAccess sync.RWMutex
-	c            Counter // An atomic counter
-	wg           sync.WaitGroup
+	pump         *messagePump  // Non-nil while an STA thread is running; protected by signalAccess
+	tasks        []*task       // Functions waiting to run on the thread; protected by signalAccess
+	tracked      []*tracked    // Objects to release before uninitializing; protected by signalAccess
+	gitCookies   gitCookies    // Interfaces registered in the GIT and not revoked yet; protected by signalAccess
+	room         chan struct{} // Closed when the counter drops, if WaitAdd or Drain is waiting; protected by signalAccess
+	cookie       uintptr       // The MTA usage cookie while one is held; protected by signalAccess
+	sharing      bool          // Whether a reference to the shared thread is held; protected by signalAccess
+	adopted      bool          // Whether the host's MTA is used in place of a thread; protected by signalAccess
+	bouncing     atomic.Bool   // Whether Reinitialize is waiting for the apartment to come down; only changed while holding signalAccess
+	watchdog     chan struct{} // Closed to stop the watchdog, if there is one; protected by signalAccess
+	terminated   chan struct{} // Closed by Close; made by New
+	lifetime     clock.Timer   // Fires when the lifetime set by WithMaxLifetime is over; protected by startAccess
+	current      *progress     // The progress of the running thread; protected by signalAccess
+	busy         *task         // The task the thread is running, if any; protected by signalAccess
+	busySince    atomic.Int64  // Unix nanoseconds at which busy started running, or 0; only changed while holding signalAccess
+	c            Counter       // An atomic counter
+	starts       Counter       // The number of times run() has been called
+	adds         Counter       // The number of times the counter was increased
+	failures     Counter       // The number of starts that failed
+	peak         atomic.Int64  // The highest value the counter has reached
+	gitCount     atomic.Int64  // The number of entries in gitCookies; only changed while holding signalAccess
+	upSince      atomic.Int64  // Unix nanoseconds at which the apartment came up, or 0 while down
+	upTotal      atomic.Int64  // Nanoseconds the apartment was up before upSince
+	idleSince    atomic.Int64  // Unix nanoseconds at which the counter last hit zero, or 0 while busy
+	changed      atomic.Int64  // Unix nanoseconds at which the counter last changed, with WithHysteresis
+	avoided      atomic.Int64  // The number of times a lingering apartment was reused
+	generation   atomic.Uint64 // The number of times the counter has risen from zero; only changed while holding signalAccess
+	threadID     atomic.Uint32 // The OS thread hosting the apartment, or 0
+	etwHandle    uint64        // The ETW provider registered for WithETW, or 0; set by New
+	eventHandle  uintptr       // The event held for WithNamedEvent, or 0; only used while starting and stopping
+	logger       atomic.Value  // Holds a loggerHolder
+	subscribers  subscribers   // Listeners registered with Subscribe
+	holders      holders       // Outstanding references, if leak tracking is enabled
+	clients      clients       // Clients created with NewClient
+	errAccess    sync.Mutex
+	err          error // The last failure of the shim's goroutine
+	exitAccess   sync.Mutex
+	active       int           // Goroutines started by run() that haven't exited; protected by exitAccess
+	exited       chan struct{} // Closed when active drops to zero; protected by exitAccess
+}
+
+// startup is shared by every caller that arrives while the shim is being
+// started, so that a single call to run() serves all of them.
+type startup struct {
+	done chan struct{}
+	err  error
 }
 
 // New returns a new shim for keeping component object model resources allocated
-// within a process.
-func New() *Shim {
+// within a process. Its behavior can be adjusted with options.
+func New(opts ...Option) *Shim {
 	shim := new(Shim)
 	shim.cond.L = &shim.signalAccess
-	shim.wg = sync.WaitGroup{}
+	shim.terminated = make(chan struct{})
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	shim.cfg.Store(&cfg)
+	if cfg.logger != nil {
+		shim.SetLogger(cfg.logger)
+	}
+	shim.keepAlive.Store(cfg.permanent)
+	shim.startETW()
+	shim.startLifetime()
+	shim.watchStop()
+	if cfg.eagerStart {
+		shim.Start(context.Background()) // A failure is recorded by Err
+	}
 	return shim
 }
 
+// NewSTA returns a new shim whose thread is initialized as a single-threaded
+// apartment. While the counter is greater than zero the thread runs a window
+// message pump, so that STA objects living on it can receive calls.
+func NewSTA(opts ...Option) *Shim {
+	return New(append([]Option{WithCoInitFlags(ole.COINIT_APARTMENTTHREADED)}, opts...)...)
+}
+
 // TryAdd adds delta, which may be negative, to the counter for the shim. As long
 // as the counter is greater than zero, at least one thread is guaranteed to be
 // initialized for mutli-threaded COM access.
@@ -41,29 +149,248 @@ func New() *Shim {
 // If the counter becomes zero, the shim is released and COM resources may be
 // released if there are no other threads that are still initialized.
 //
-// If the counter goes negative, TryAdd panics.
+// If the counter would go negative, TryAdd panics, unless the shim was created
+// with WithNegativeCounterErrors, in which case it returns ErrNegativeCounter.
 //
 // If the shim cannot be created for some reason, TryAdd returns an error.
 func (s *Shim) TryAdd(delta int) error {
-	s.startAccess.Lock()
-	defer s.startAccess.Unlock()
-	s.add(delta)
-	if s.running {
-		return nil //already loaded
+	return s.TryAddContext(context.Background(), delta)
+}
+
+// TryAddContext is like TryAdd, but gives up waiting for the shim to start if
+// ctx is done first. In that case delta is taken back off the counter and
+// ctx.Err() is returned. The start itself carries on in the background and
+// remains available to other callers.
+func (s *Shim) TryAddContext(ctx context.Context, delta int) error {
+	if err := s.unowned(); err != nil {
+		return err
 	}
+	return s.tryAddContext(ctx, delta, nil, false)
+}
+
+// hold adds delta to the counter on behalf of the shim itself, for helpers
+// that give the reference back on their own. It isn't subject to
+// WithStrictOwnership.
+func (s *Shim) hold(delta int) error {
+	return s.tryAddContext(context.Background(), delta, nil, false)
+}
+
+// holdContext is like hold, but gives up waiting for the shim to start if ctx
+// is done first.
+func (s *Shim) holdContext(ctx context.Context, delta int) error {
+	return s.tryAddContext(ctx, delta, nil, false)
+}
 
-	// The shim wasn't running; only change the running state within a write lock
-	if s.running {
-		// The shim was started between the read lock and the write lock
+// holdTask takes the reference held while a function submitted by Run or Go
+// runs. Unless the shim was created with WithExplicitStart, it starts the
+// thread if necessary.
+func (s *Shim) holdTask(ctx context.Context) error {
+	return s.tryAddContext(ctx, 1, nil, s.settings().explicitStart)
+}
+
+// tryAddContext implements TryAddContext. The delta is attributed to owner if
+// leak tracking is enabled. If join is set, the thread isn't started; the
+// caller only waits for a start already in progress, and gets
+// ErrShimNotReady if there is none.
+func (s *Shim) tryAddContext(ctx context.Context, delta int, owner *Guard, join bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if s.addFast(delta) {
+		s.track(delta, owner)
 		return nil
 	}
 
-	if err := s.run(); err != nil {
+	s.startAccess.Lock()
+	if s.closed.Load() {
+		s.startAccess.Unlock()
+		return ErrClosed
+	}
+	if s.draining.Load() && delta > 0 {
+		s.startAccess.Unlock()
+		return ErrDraining
+	}
+	if s.failed != nil && s.settings().stickyFailure {
+		err := s.failed
+		s.startAccess.Unlock()
 		return err
 	}
+	// With no start in progress, joining needs the thread to be running
+	// when the delta is added, which addRunning checks under the same lock
+	running, err := s.addRunning(delta, join && s.starting == nil)
+	if err == ErrTooManyHolders || err == ErrShimNotReady {
+		s.startAccess.Unlock()
+		return err
+	} else if err != nil {
+		s.startAccess.Unlock()
+		return s.negativeCounter(err)
+	}
+	s.track(delta, owner)
+	if running {
+		s.startAccess.Unlock()
+		return nil // already loaded
+	}
 
-	s.running = true
-	return nil
+	return s.startOrJoin(ctx, delta, owner)
+}
+
+// startOrJoin starts the shim's thread on behalf of a caller that has added
+// delta to the counter while it wasn't running. If another caller is already
+// starting it, it waits for that attempt to finish and shares its result
+// instead of running again. It must be called while holding startAccess,
+// which it releases.
+func (s *Shim) startOrJoin(ctx context.Context, delta int, owner *Guard) error {
+	st := s.starting
+	if st == nil {
+		st = &startup{done: make(chan struct{})}
+		s.starting = st
+		s.begin() // Must happen before the start so that WaitDone sees it
+		s.startAccess.Unlock()
+
+		if ctx.Done() == nil && s.settings().startTimeout <= 0 {
+			// The wait can't be abandoned, so there's no need for another
+			// goroutine
+			s.start(st)
+			return s.started(st, delta, owner)
+		}
+		go s.start(st)
+	} else {
+		s.startAccess.Unlock()
+	}
+
+	var timeout <-chan time.Time
+	if s.settings().startTimeout > 0 {
+		timer := s.settings().scheduler.NewTimer(s.settings().startTimeout)
+		defer timer.Stop()
+		timeout = timer.C()
+	}
+
+	select {
+	case <-st.done:
+		if err := s.started(st, delta, owner); err != nil || s.running.Load() || s.closed.Load() {
+			return err
+		}
+		// The thread came up and stopped again before our delta was added,
+		// as nobody held a reference in between. start clears s.starting
+		// before closing st.done, so this starts it again rather than
+		// joining the same start.
+		s.startAccess.Lock()
+		return s.startOrJoin(ctx, delta, owner)
+	case <-ctx.Done():
+		s.add(-delta) // Taking back our own delta can't underflow
+		s.track(-delta, owner)
+		return ctx.Err()
+	case <-timeout:
+		s.add(-delta)
+		s.track(-delta, owner)
+		s.log().Error("comshim: thread did not start in time", "timeout", s.settings().startTimeout)
+		return ErrStartTimeout
+	}
+}
+
+// start runs the shim's goroutine and shares the result with every caller
+// waiting on st.
+func (s *Shim) start(st *startup) {
+	s.emit(EventStarting, nil)
+	st.err = s.run()
+	backoff := s.settings().initBackoff
+	for attempt := 1; st.err != nil; attempt++ {
+		s.failures.Add(1)
+		if !s.retryStart(st.err, attempt) {
+			break
+		}
+		s.log().Warn("comshim: retrying start", "error", st.err, "attempt", attempt+1, "backoff", backoff)
+		s.sleep(backoff)
+		backoff *= 2
+
+		s.begin() // The failed attempt's goroutine has already ended
+		st.err = s.run()
+	}
+
+	s.startAccess.Lock()
+	s.starting = nil
+	if st.err != nil {
+		s.failed = st.err
+	}
+	s.startAccess.Unlock()
+	if st.err != nil {
+		s.setErr(st.err)
+		s.emit(EventInitFailed, st.err)
+	} else {
+		s.emit(EventInitialized, nil)
+	}
+	close(st.done)
+}
+
+// started returns the result of the start st to a caller that added delta to
+// the counter while waiting for it. If the start failed, delta is taken back
+// off the counter, as the caller won't be calling Done for it.
+func (s *Shim) started(st *startup, delta int, owner *Guard) error {
+	if st.err != nil {
+		s.add(-delta) // Taking back our own delta can't underflow
+		s.track(-delta, owner)
+	}
+	return st.err
+}
+
+// Reset clears the failure recorded when the shim last failed to start, so
+// that Err returns nil and, if the shim was created with WithStickyFailure,
+// TryAdd attempts to start it again.
+func (s *Shim) Reset() {
+	s.startAccess.Lock()
+	s.failed = nil
+	s.startAccess.Unlock()
+	s.setErr(nil)
+}
+
+// retryStart reports whether a start that failed with err should be attempted
+// again, given that attempt attempts have been made so far.
+func (s *Shim) retryStart(err error, attempt int) bool {
+	if attempt >= s.settings().initAttempts {
+		return false
+	}
+	var perr *PanicError
+	if errors.As(err, &perr) {
+		return false // A bug, not a transient failure
+	}
+	return s.Count() > 0 // Otherwise every caller has given up waiting
+}
+
+// WaitAdd is like TryAddContext, but if the shim was created with
+// WithMaxHolders and adding delta would exceed the limit, it waits for other
+// holders to call Done instead of returning ErrTooManyHolders. It gives up and
+// returns ctx.Err() once ctx is done.
+//
+// If delta exceeds the limit on its own, WaitAdd returns ErrTooManyHolders
+// right away.
+func (s *Shim) WaitAdd(ctx context.Context, delta int) error {
+	if s.settings().maxHolders > 0 && delta > s.settings().maxHolders {
+		return ErrTooManyHolders
+	}
+	for {
+		err := s.TryAddContext(ctx, delta)
+		if err != ErrTooManyHolders {
+			return err
+		}
+
+		s.signalAccess.Lock()
+		if s.c.Value()+int64(delta) <= int64(s.settings().maxHolders) {
+			s.signalAccess.Unlock()
+			continue // Someone called Done in the meantime
+		}
+		if s.room == nil {
+			s.room = make(chan struct{})
+		}
+		room := s.room
+		s.signalAccess.Unlock()
+
+		select {
+		case <-room:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 }
 
 // Add adds delta, which may be negative, to the counter for the shim. As long
@@ -76,40 +403,347 @@ func (s *Shim) TryAdd(delta int) error {
 // If the counter goes negative, Add panics.
 //
 // If the shim cannot be created for some reason, Add panics.
+//
+// Either way, a handler set by WithErrorHandler can choose not to panic.
 func (s *Shim) Add(delta int) {
 	if err := s.TryAdd(delta); err != nil {
-		panic(err)
+		s.handleError(err)
 	}
 }
 
 // Done decrements the counter for the shim.
+//
+// If the counter is already zero, Done panics, unless the shim was created with
+// WithNegativeCounterErrors or a handler set by WithErrorHandler decides
+// otherwise.
 func (s *Shim) Done() {
-	s.add(-1)
+	if err := s.unowned(); err != nil {
+		s.handleError(err)
+		return
+	}
+	s.done(nil)
+}
+
+// done implements Done. The release is attributed to owner if leak tracking is
+// enabled.
+func (s *Shim) done(owner *Guard) {
+	if _, err := s.add(-1); err != nil {
+		s.negativeCounter(err)
+		return
+	}
+	s.track(-1, owner)
+}
+
+// TryDone decrements the counter for the shim. Unlike Done it never panics: if
+// the counter is already zero it is left unchanged and ErrNegativeCounter is
+// returned.
+func (s *Shim) TryDone() error {
+	if err := s.unowned(); err != nil {
+		return err
+	}
+	if _, err := s.add(-1); err != nil {
+		return err
+	}
+	s.track(-1, nil)
+	return nil
 }
 
-func (s *Shim) add(delta int) {
+// DoneN decrements the counter for the shim by n in one step, which is cheaper
+// than calling Done n times when a batch of references is given back at once.
+// Like TryDone it never panics: if the counter is less than n it is left
+// unchanged and ErrNegativeCounter is returned, and if n is negative
+// ErrNegativeDelta is returned.
+func (s *Shim) DoneN(n int) error {
+	if n < 0 {
+		return ErrNegativeDelta
+	}
+	if err := s.unowned(); err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+	if _, err := s.add(-n); err != nil {
+		return err
+	}
+	s.track(-n, nil)
+	return nil
+}
+
+// With adds one to the counter for the shim, calls fn and then calls Done, even
+// if fn panics. It returns the error from fn, or the error that prevented the
+// shim from starting.
+//
+// fn runs on the calling goroutine; the shim only keeps COM alive for its
+// duration. Use Run to call fn on the shim's own thread.
+func (s *Shim) With(fn func() error) error {
+	if err := s.hold(1); err != nil {
+		return err
+	}
+	defer s.done(nil)
+	return fn()
+}
+
+// add adds delta to the counter and reports whether the shim's goroutine is
+// running. If it is, the goroutine is guaranteed to observe the new value
+// before it decides whether to release the thread.
+//
+// If the counter would drop below zero it is left unchanged and
+// ErrNegativeCounter is returned. Likewise, if it would exceed the limit set by
+// WithMaxHolders, ErrTooManyHolders is returned.
+func (s *Shim) add(delta int) (running bool, err error) {
+	return s.addRunning(delta, false)
+}
+
+// addRunning is like add, but if onlyRunning is set and the shim's thread
+// isn't running, it leaves the counter alone and returns ErrShimNotReady.
+// The thread can't stop between the check and the update.
+func (s *Shim) addRunning(delta int, onlyRunning bool) (running bool, err error) {
+	if s.addFast(delta) {
+		return true, nil
+	}
+
 	s.signalAccess.Lock()
-	defer s.signalAccess.Unlock()
-	value := s.c.Add(int64(delta))
+	if onlyRunning && !s.running.Load() {
+		s.signalAccess.Unlock()
+		return false, ErrShimNotReady
+	}
+	var value int64
+	for {
+		// addFast may still change the counter while it stays positive, so
+		// the new value is checked before it is stored
+		current := s.c.Value()
+		value = current + int64(delta)
+		if value < 0 {
+			err = ErrNegativeCounter
+		} else if delta > 0 && s.settings().maxHolders > 0 && value > int64(s.settings().maxHolders) {
+			err = ErrTooManyHolders
+		}
+		if err != nil {
+			running = s.running.Load()
+			s.signalAccess.Unlock()
+			return running, err
+		}
+		if s.c.CompareAndSwap(current, value) {
+			break
+		}
+	}
+	s.touch()
+	if delta < 0 && s.room != nil {
+		close(s.room) // Wake up callers of WaitAdd and Drain
+		s.room = nil
+	}
 	if value == 0 {
-		s.cond.Broadcast()
+		s.idleSince.Store(s.now().UnixNano())
+		s.signal()
+		if s.cookie != 0 && !s.keepAlive.Load() {
+			s.idleMTAUsage()
+		}
+		if s.sharing && !s.keepAlive.Load() {
+			s.releaseShared()
+		}
+		if s.adopted && !s.keepAlive.Load() {
+			s.releaseAdopted()
+		}
+	} else if value > 0 && value == int64(delta) {
+		if s.running.Load() && (s.settings().idleTimeout > 0 || s.settings().hysteresis > 0) && !s.keepAlive.Load() {
+			s.avoided.Add(1) // The thread was lingering rather than held
+		}
+		s.idleSince.Store(0) // The counter has left zero
+		s.generation.Add(1)
+		s.traceETW(etwLevelVerbose, "counter left zero")
 	}
-	if value < 0 {
-		panic(ErrNegativeCounter)
+	if delta > 0 {
+		s.adds.Add(1)
+		s.raisePeak(value)
+	}
+	running = s.running.Load()
+	s.signalAccess.Unlock()
+
+	// Log outside the lock so that a slow logger can't hold up the thread
+	if value == 0 && delta != 0 {
+		s.emit(EventDraining, nil)
+		s.log().Debug("comshim: counter dropped to zero")
+	} else if value > 0 && value == int64(delta) && s.logging() {
+		s.log().Debug("comshim: counter rose from zero", "count", value)
+	}
+	return running, nil
+}
+
+// addFast adds delta to the counter without taking any locks, provided that
+// this can't change anything but the counter itself: the shim's thread must be
+// running and the counter must stay positive and within its limit. It reports
+// whether delta was added; if not, the caller has to take the slow path.
+//
+// The thread only stops with the counter positive when the shim is closed or
+// reinitialized, which addFast checks for, or when poison gives up on it, in
+// which case a replacement is started. Otherwise a positive counter keeps the
+// thread running for the new value.
+func (s *Shim) addFast(delta int) bool {
+	if delta < 0 && s.settings().maxHolders > 0 {
+		return false // Callers of WaitAdd may need waking up
+	}
+	for {
+		value := s.c.Value()
+		next := value + int64(delta)
+		if value <= 0 || next <= 0 || !s.running.Load() || s.closed.Load() || s.draining.Load() || s.bouncing.Load() {
+			return false
+		}
+		if delta > 0 && s.settings().maxHolders > 0 && next > int64(s.settings().maxHolders) {
+			return false
+		}
+		if s.c.CompareAndSwap(value, next) {
+			s.touch()
+			if delta > 0 {
+				s.adds.Add(1)
+				s.raisePeak(next)
+			}
+			return true
+		}
+	}
+}
+
+// touch records that the counter has changed, for WithHysteresis.
+func (s *Shim) touch() {
+	if s.settings().hysteresis > 0 {
+		s.changed.Store(s.now().UnixNano())
+	}
+}
+
+// raisePeak records value as the highest value of the counter, unless it has
+// been higher before.
+func (s *Shim) raisePeak(value int64) {
+	for {
+		peak := s.peak.Load()
+		if value <= peak || s.peak.CompareAndSwap(peak, value) {
+			return
+		}
+	}
+}
+
+// negativeCounter reports an attempt to drop the counter below zero. Unless
+// the shim was created with WithNegativeCounterErrors, in which case the error
+// is logged and returned, it is handled as decided by WithErrorHandler, which
+// panics by default.
+func (s *Shim) negativeCounter(err error) error {
+	if !s.settings().negativeCounterErrors {
+		s.handleError(err)
+		return err
 	}
+	s.log().Error("comshim: counter would drop below zero", "error", err)
+	return err
 }
 
 func (s *Shim) run() error {
-	init := make(chan error)
-	s.wg.Add(1)
+	s.starts.Add(1)
+	if s.settings().shared != nil {
+		return s.startShared()
+	}
+	if s.hostApartment() {
+		return s.startAdopted()
+	}
+	if s.settings().mtaUsage && mtaUsageSupported() {
+		return s.startMTAUsage()
+	}
+
+	err := s.runThread()
+	if s.settings().apartmentFallback && taintedThread(err) {
+		// The runtime has thrown the thread away, so the next goroutine to
+		// lock one gets a clean thread
+		s.log().Warn("comshim: retrying on a fresh thread", "error", err)
+		s.begin() // The failed attempt's goroutine has already ended
+		err = s.runThread()
+	}
+	return err
+}
+
+// taintedThread reports whether err means that the shim's thread had already
+// been initialized for COM by someone else.
+func taintedThread(err error) bool {
+	return hresultCode(err) == rpcEChangedMode || errors.Is(err, ErrAlreadyInitialized)
+}
+
+// hresultCode returns the HRESULT carried by err, or 0 if it doesn't carry one.
+func hresultCode(err error) uintptr {
+	var oerr *ole.OleError
+	if errors.As(err, &oerr) {
+		return oerr.Code()
+	}
+	return 0
+}
+
+// runThread starts the shim's goroutine and waits until its thread has been
+// initialized.
+func (s *Shim) runThread() error {
+	init := make(chan error, 1)
 	go func() {
-		defer s.wg.Done()
+		var p progress
+		defer func() {
+			// A detached goroutine has already been accounted for by poison
+			if !p.detached.Load() {
+				s.end()
+			}
+		}()
 		runtime.LockOSThread()
-		defer runtime.UnlockOSThread()
+		discard := false
+		defer func() {
+			// Exiting while still locked makes the runtime terminate the
+			// thread instead of handing it to another goroutine
+			if !discard {
+				runtime.UnlockOSThread()
+			}
+		}()
+
+		restoreThread := s.configureThread()
+		defer restoreThread()
+
+		if s.settings().token != 0 {
+			if err := impersonate(s.settings().token); err != nil {
+				s.log().Error("comshim: impersonating the token failed", "error", err)
+				p.signal(init, newInitError("ImpersonateLoggedOnUser", err))
+				return
+			}
+			// Deferred before the activation context and COM, so that it
+			// comes undone last
+			defer func() {
+				if err := revertToSelf(); err != nil {
+					s.log().Error("comshim: reverting the impersonation failed, terminating the thread", "error", err)
+					discard = true
+				}
+			}()
+		}
 
-		if err := ole.CoInitializeEx(0, ole.COINIT_MULTITHREADED); err != nil {
-			switch err.(*ole.OleError).Code() {
-			case 0x00000001: // S_FALSE
+		if s.settings().manifest != "" {
+			actx, err := activateContext(s.settings().manifest)
+			if err != nil {
+				s.log().Error("comshim: activating the activation context failed", "error", err)
+				p.signal(init, newInitError("ActivateActCtx", err))
+				return
+			}
+			// Deferred first, so that it runs after COM has been uninitialized
+			// even if the goroutine panics
+			defer actx.deactivate()
+		}
+		defer s.recoverPanic(&p, init)
+
+		ctx := s.labelGoroutine()
+		s.log().Debug("comshim: thread started", "thread", currentThreadID())
+		region := trace.StartRegion(ctx, s.initOp())
+		err := s.initialize()
+		region.End()
+		if err == nil || hresultCode(err) == sFalse {
+			p.initialized = true // S_FALSE must be balanced by CoUninitialize too
+		}
+		if err != nil && hresultCode(err) == sFalse && s.settings().reuseExistingInit {
+			// Our call still counts towards the thread's initialization, and
+			// is balanced by the usual CoUninitialize at teardown. Whoever
+			// initialized the thread first keeps their initialization.
+			s.log().Warn("comshim: reusing existing COM initialization of thread")
+			err = nil
+		}
+		if err != nil {
+			switch hresultCode(err) {
+			case sFalse:
 				// Some other goroutine called CoInitialize on this thread
 				// before we ended up with it. This probably means the other
 				// caller failed to lock the OS thread or failed to call
@@ -117,33 +751,455 @@ func (s *Shim) run() error {
 
 				// We still decrement this thread's initialization counter by
 				// calling CoUninitialize here, as recommended by the docs.
-				ole.CoUninitialize()
+				s.uninitializeThread(&p)
+				s.log().Warn("comshim: thread was already initialized for COM")
 
 				// Send an error so that shim.Add panics
-				init <- ErrAlreadyInitialized
+				discard = s.settings().apartmentFallback
+				p.signal(init, &InitError{Op: s.initOp(), HRESULT: sFalse, Err: ErrAlreadyInitialized})
+			case rpcEChangedMode:
+				// Someone initialized this thread for the other concurrency
+				// model and left it that way
+				s.log().Error("comshim: thread was already initialized for another apartment", "error", err)
+				discard = s.settings().apartmentFallback
+				p.signal(init, newInitError(s.initOp(), err))
 			default:
-				init <- err
+				s.log().Error("comshim: initialization failed", "error", err)
+				p.signal(init, newInitError(s.initOp(), err))
 			}
-			close(init)
 			return
 		}
 
-		close(init)
+		// Not being able to tell which apartment the thread is in is no reason
+		// to fail the start; only a mismatch is
+		if apt, err := s.checkApartment(); errors.Is(err, ErrWrongApartment) && s.settings().initializer == nil {
+			s.log().Error("comshim: thread landed in the wrong apartment", "apartment", apt, "error", err)
+			s.uninitializeThread(&p)
+			p.signal(init, newInitError("CoGetApartmentType", err))
+			return
+		}
+
+		pump, err := s.setup()
+		if err != nil {
+			s.uninitializeThread(&p)
+			p.signal(init, err)
+			return
+		}
+
+		s.log().Debug("comshim: thread initialized for COM", "apartment", s.apartmentName())
 
 		s.signalAccess.Lock()
-		for s.c.Value() > 0 {
-			s.cond.Wait()
+		p.locked = true
+		s.pump = pump
+		s.threadID.Store(currentThreadID())
+		s.setRunning(true)
+		s.startWatchdog()
+		s.current = &p
+		p.signal(init, nil)
+		var idle clock.Timer
+		var libraries libraryCollector
+		region = trace.StartRegion(ctx, "comshim wait")
+		for {
+			if p.detached.Load() {
+				break
+			}
+			if len(s.tasks) > 0 {
+				s.runTask(&p)
+				continue
+			}
+			if s.collectLibraries(&p, &libraries) {
+				continue
+			}
+			if !s.closed.Load() && !s.bouncing.Load() && (s.held() || s.linger(&idle)) {
+				s.wait(&p)
+				continue
+			}
+			if s.reprieve(&p) {
+				continue
+			}
+			break
+		}
+		region.End()
+		if idle != nil {
+			idle.Stop()
 		}
-		s.running = false
-		ole.CoUninitialize()
+		libraries.stop()
+		if p.detached.Load() {
+			// RunTimeout gave up on this thread and the shim has moved on to
+			// another one, so only what belongs to this thread is undone
+			if pump != nil {
+				pump.close()
+			}
+			s.uninitializeThread(&p)
+			s.signalAccess.Unlock()
+			p.locked = false
+			s.log().Warn("comshim: abandoned thread uninitialized and released")
+			return
+		}
+		s.current = nil
+		s.setRunning(false)
+		s.threadID.Store(0)
+		s.stopWatchdog()
+		s.abandonTasks()
+		s.runTeardownHooks(&p)
+		if pump != nil {
+			s.pump = nil
+			pump.close()
+		}
+		s.reportUnreleased()
+		s.releaseTracked()
+		s.revokeGIT()
+		region = trace.StartRegion(ctx, s.uninitOp())
+		s.uninitializeThread(&p)
+		region.End()
 		s.signalAccess.Unlock()
+		p.locked = false
+
+		s.emit(EventUninitialized, nil)
+		s.log().Debug("comshim: thread uninitialized and released")
 	}()
 
 	return <-init
 }
 
+// setup prepares a thread that has just been initialized for COM. If it
+// fails, the caller remains responsible for uninitializing COM.
+//
+// A message pump is returned for single-threaded apartments.
+func (s *Shim) setup() (pump *messagePump, err error) {
+	if s.settings().security != nil {
+		if err := initializeSecurity(*s.settings().security); err != nil {
+			s.log().Error("comshim: CoInitializeSecurity failed", "error", err)
+			return nil, newInitError("CoInitializeSecurity", err)
+		}
+	}
+	if s.settings().coinit&ole.COINIT_APARTMENTTHREADED != 0 {
+		if pump, err = newMessagePump(); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.runInitHooks(); err != nil {
+		if pump != nil {
+			pump.close()
+		}
+		return nil, err
+	}
+	return pump, nil
+}
+
+// linger reports whether the shim should hold on to its apartment even though
+// the counter has dropped to zero, because the idle timeout or the hysteresis
+// period has not expired yet. If so, idle is armed to wake the shim's
+// goroutine once it does. It must be called while holding signalAccess.
+func (s *Shim) linger(idle *clock.Timer) bool {
+	var remaining time.Duration
+	if s.settings().idleTimeout > 0 {
+		remaining = s.settings().idleTimeout - s.IdleDuration()
+	}
+	if s.settings().hysteresis > 0 {
+		since := time.Duration(s.now().UnixNano() - s.changed.Load())
+		if r := s.settings().hysteresis - since; r > remaining {
+			remaining = r
+		}
+	}
+	if remaining <= 0 {
+		return false
+	}
+	if *idle == nil {
+		*idle = s.settings().scheduler.AfterFunc(remaining, s.wake)
+	} else {
+		(*idle).Reset(remaining)
+	}
+	return true
+}
+
+// wake wakes the shim's goroutine so that it re-examines its state.
+func (s *Shim) wake() {
+	s.signalAccess.Lock()
+	defer s.signalAccess.Unlock()
+	s.signal()
+}
+
+// signal wakes the shim's goroutine so that it re-examines the counter and the
+// task queue. It must be called while holding signalAccess.
+func (s *Shim) signal() {
+	s.cond.Broadcast()
+	if s.pump != nil {
+		s.pump.wake()
+	}
+}
+
+// wait blocks until the counter may have reached zero or a task may have been
+// queued. It must be called by the shim's goroutine while holding
+// signalAccess, which it may temporarily release.
+//
+// Single-threaded apartments dispatch window messages while they wait.
+func (s *Shim) wait(p *progress) {
+	if s.pump == nil {
+		s.cond.Wait()
+		return
+	}
+	pump := s.pump
+	s.signalAccess.Unlock()
+	p.locked = false
+	pump.wait()
+	s.signalAccess.Lock()
+	p.locked = true
+}
+
+// setRunning records whether the shim's apartment is up, keeping track of how
+// long it has been up for Stats. It must be called while holding signalAccess.
+func (s *Shim) setRunning(running bool) {
+	now := s.now().UnixNano()
+	if running {
+		s.upSince.Store(now)
+	} else if since := s.upSince.Swap(0); since != 0 {
+		s.upTotal.Add(now - since)
+	}
+	s.running.Store(running)
+}
+
+// IsRunning reports whether the shim's thread is currently initialized for
+// COM use. It does not take any locks and is cheap enough to call frequently.
+func (s *Shim) IsRunning() bool {
+	return s.running.Load()
+}
+
+// IsInitialized reports whether COM is initialized for the shim's apartment,
+// which is when the uptime reported by Stats starts counting. It is set along
+// with the state reported by IsRunning, and like it, takes no locks.
+func (s *Shim) IsInitialized() bool {
+	return s.upSince.Load() != 0
+}
+
+// Count returns the current value of the counter for the shim. It does not
+// take any locks and is cheap enough to call frequently.
+func (s *Shim) Count() int64 {
+	return s.c.Value()
+}
+
+// Generation returns the number of times the counter for the shim has risen
+// from zero, which identifies the period during which the references
+// currently held were taken. It does not take any locks.
+func (s *Shim) Generation() uint64 {
+	return s.generation.Load()
+}
+
+// ThreadID returns the identifier of the OS thread that hosts the shim's
+// apartment, as reported by GetCurrentThreadId. It returns zero if the shim
+// is not running or keeps the apartment alive without a thread of its own
+// (see NewMTAUsage). Thread identifiers are only available on Windows.
+func (s *Shim) ThreadID() uint32 {
+	if s.settings().shared != nil {
+		return s.settings().shared.ThreadID()
+	}
+	return s.threadID.Load()
+}
+
+// IdleSince returns the time at which the counter for the shim last dropped to
+// zero. It returns the zero time if the counter is currently greater than zero
+// or has never dropped to zero. Stats and DebugReport, the shim's snapshot,
+// include it as well.
+func (s *Shim) IdleSince() time.Time {
+	ns := s.idleSince.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// IdleDuration returns how long the counter for the shim has been zero. It
+// returns zero if the shim is currently in use.
+func (s *Shim) IdleDuration() time.Duration {
+	since := s.IdleSince()
+	if since.IsZero() {
+		return 0
+	}
+	return s.now().Sub(since)
+}
+
+// Err returns the error that most recently caused the shim to fail to start,
+// or its goroutine to fail after it was started, such as a recovered panic. It
+// returns nil if no such failure has occurred since the shim was created or
+// last Reset.
+func (s *Shim) Err() error {
+	s.errAccess.Lock()
+	defer s.errAccess.Unlock()
+	return s.err
+}
+
+// Healthy reports whether the shim is usable: it hasn't been closed, and it
+// isn't stopped by a failure reported by Err. A failure that a later start
+// has recovered from, such as one retried by WithInitRetry, is still reported
+// by Err until Reset, but no longer makes the shim unhealthy.
+func (s *Shim) Healthy() bool {
+	if s.closed.Load() {
+		return false
+	}
+	return s.running.Load() || s.Err() == nil
+}
+
+func (s *Shim) setErr(err error) {
+	s.errAccess.Lock()
+	defer s.errAccess.Unlock()
+	s.err = err
+}
+
+// begin records that a goroutine has been started by run().
+func (s *Shim) begin() {
+	s.exitAccess.Lock()
+	defer s.exitAccess.Unlock()
+	if s.active == 0 {
+		s.exited = make(chan struct{})
+	}
+	s.active++
+}
+
+// end records that a goroutine started by run() has exited.
+func (s *Shim) end() {
+	s.exitAccess.Lock()
+	defer s.exitAccess.Unlock()
+	s.active--
+	if s.active == 0 {
+		close(s.exited)
+	}
+}
+
+// WaitDone waits for the shim's goroutine to terminate, which happens once the
+// counter has dropped to zero and COM has been uninitialized on its thread.
 func (s *Shim) WaitDone() {
+	s.WaitDoneContext(context.Background()) // Can't fail without a deadline
+}
+
+// WaitDoneContext is like WaitDone, but gives up once ctx is done, in which
+// case it returns ctx.Err(). The shim is left untouched, so its goroutine can
+// still terminate later.
+func (s *Shim) WaitDoneContext(ctx context.Context) error {
+	s.exitAccess.Lock()
+	exited := s.exited
+	active := s.active
+	s.exitAccess.Unlock()
+	if active == 0 {
+		return nil
+	}
+
+	select {
+	case <-exited:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WaitZero blocks until the counter is zero, returning right away if it
+// already is. Unlike WaitDone it doesn't wait for the shim's goroutine, so it
+// also tells when every holder has finished with a shim that keeps its thread
+// regardless, such as one created with WithPermanent or WithIdleTimeout. The
+// counter may have left zero again by the time WaitZero returns. If ctx is
+// done first, WaitZero returns ctx.Err().
+func (s *Shim) WaitZero(ctx context.Context) error {
+	return s.c.WaitZero(ctx)
+}
+
+// WaitDoneTimeout is like WaitDone, but gives up after d, in which case it
+// returns context.DeadlineExceeded.
+func (s *Shim) WaitDoneTimeout(d time.Duration) error {
+	ctx, cancel := s.withTimeout(context.Background(), d)
+	defer cancel()
+	return timeoutErr(ctx, s.WaitDoneContext(ctx))
+}
+
+// Close tears the shim down regardless of its counter, which is useful when a
+// leaked reference would otherwise keep COM initialized forever. Queued
+// functions still run, but from then on TryAdd and Run return ErrClosed. Calls
+// to Done for references taken before Close are still accepted. References
+// held by WithShim are given back, and futures returned by GoContext report
+// ErrClosed; see Closed.
+//
+// Close waits for the shim's goroutine to uninitialize COM and terminate, like
+// WaitDoneContext. If ctx is done first, it returns ctx.Err() and the shim
+// finishes closing in the background. Closing a shim more than once is
+// harmless.
+func (s *Shim) Close(ctx context.Context) error {
 	s.startAccess.Lock()
-	defer s.startAccess.Unlock()
-	s.wg.Wait()
+	closed := s.closed.Swap(true)
+	if !closed {
+		if s.running.Load() {
+			s.emit(EventDraining, nil) // Queued before Closed fires, for AllEvents
+		}
+		close(s.terminated)
+	}
+	if s.lifetime != nil {
+		s.lifetime.Stop()
+	}
+	s.startAccess.Unlock()
+
+	s.signalAccess.Lock()
+	if s.cookie != 0 {
+		s.releaseMTAUsage()
+	}
+	if s.sharing {
+		s.releaseShared()
+	}
+	if s.adopted {
+		s.releaseAdopted()
+	}
+	s.signal()
+	s.signalAccess.Unlock()
+
+	s.log().Debug("comshim: closing")
+	return s.WaitDoneContext(ctx)
+}
+
+// Closed returns a channel that is closed once the shim has been closed, by
+// Close, Drain, the end of the lifetime set by WithMaxLifetime or the stop
+// channel set by WithStopChannel. Goroutines that watch a shim on behalf of
+// its users, such as the one started by WithShim, select on it so that they
+// don't outlive the shim.
+func (s *Shim) Closed() <-chan struct{} {
+	return s.terminated
+}
+
+// Drain stops the shim from accepting new work while letting the work in
+// progress finish: from now on, adding to the counter makes TryAdd return
+// ErrDraining, and Add panic, while Done keeps working. Once the counter has
+// dropped to zero, Drain tears the apartment down like Close, and the shim
+// stays closed.
+//
+// If ctx is done before the counter reaches zero, Drain returns ctx.Err() and
+// the shim keeps draining. It can then be forced down with Close.
+func (s *Shim) Drain(ctx context.Context) error {
+	s.startAccess.Lock()
+	s.draining.Store(true)
+	s.startAccess.Unlock()
+	s.log().Debug("comshim: draining", "count", s.Count())
+
+	for {
+		// The counter never drops to zero through addFast, so the last Done
+		// always closes room
+		s.signalAccess.Lock()
+		if s.c.Value() <= 0 {
+			s.signalAccess.Unlock()
+			break
+		}
+		if s.room == nil {
+			s.room = make(chan struct{})
+		}
+		room := s.room
+		s.signalAccess.Unlock()
+
+		select {
+		case <-room:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return s.Close(ctx)
+}
+
+// WaitDoneErr is like WaitDone, but also returns the error reported by Err once
+// the shim's goroutine has terminated.
+func (s *Shim) WaitDoneErr() error {
+	s.WaitDone()
+	return s.Err()
 }
@@ -1,52 +1,20 @@
 package comshim_test
 
 import (
+	"context"
+	"errors"
 	"runtime"
+	"runtime/pprof"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/NozomiNetworks/go-comshim"
+	"github.com/NozomiNetworks/go-comshim/comshimtest"
 	"github.com/go-ole/go-ole"
-	"github.com/go-ole/go-ole/oleutil"
 )
 
-func TestConcurrentShims(t *testing.T) {
-	defer comshim.WaitDone()
-	var maxRounds int
-	if testing.Short() {
-		maxRounds = 64
-	} else {
-		maxRounds = 256
-	}
-
-	// Vary the number of threads
-	for procs := 1; procs < 11; procs++ {
-		runtime.GOMAXPROCS(procs)
-
-		// Vary the number of shims
-		for rounds := 1; rounds <= maxRounds; rounds *= 2 {
-			wg := sync.WaitGroup{}
-			for i := 0; i < rounds; i++ {
-				wg.Add(1)
-				go func(i int) {
-					defer wg.Done()
-
-					comshim.Add(1)
-					defer comshim.Done()
-
-					obj, err := oleutil.CreateObject("WbemScripting.SWbemLocator")
-					if err != nil {
-						t.Error(err)
-					} else {
-						defer obj.Release()
-					}
-				}(i)
-			}
-			wg.Wait()
-		}
-	}
-}
-
 func TestConcurrentCoInitializeDoesNotPanic(t *testing.T) {
 	defer comshim.WaitDone()
 	var maxRounds int
@@ -80,3 +48,1244 @@ func TestConcurrentCoInitializeDoesNotPanic(t *testing.T) {
 		}
 	}
 }
+
+func TestIdleSince(t *testing.T) {
+	s := comshim.New()
+	defer s.WaitDone()
+
+	if since := s.IdleSince(); !since.IsZero() {
+		t.Fatalf("new shim reports idle since %v", since)
+	}
+
+	s.Add(1)
+	if d := s.IdleDuration(); d != 0 {
+		t.Errorf("busy shim reports idle duration %v", d)
+	}
+
+	before := time.Now()
+	s.Done()
+	since := s.IdleSince()
+	if since.Before(before) {
+		t.Errorf("idle since %v, want a time after %v", since, before)
+	}
+
+	s.Add(1)
+	if since := s.IdleSince(); !since.IsZero() {
+		t.Errorf("busy shim reports idle since %v", since)
+	}
+	s.Done()
+}
+
+func BenchmarkIsRunningUnderLoad(b *testing.B) {
+	s := comshim.New()
+	s.Add(1)
+	defer s.WaitDone()
+	defer s.Done()
+
+	// Keep Add/Done traffic flowing for the duration of the benchmark
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					s.Add(1)
+					s.Done()
+				}
+			}
+		}()
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if !s.IsRunning() || !s.IsInitialized() || s.Count() < 1 {
+				b.Error("shim stopped while a reference was held")
+			}
+		}
+	})
+	b.StopTimer()
+
+	close(stop)
+	wg.Wait()
+}
+
+func BenchmarkAddDone(b *testing.B) {
+	s := comshim.New()
+	s.Add(1)
+	defer s.WaitDone()
+	defer s.Done()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Add(1)
+		s.Done()
+	}
+}
+
+func BenchmarkContendedAddDone(b *testing.B) {
+	s := comshim.New()
+	s.Add(1)
+	defer s.WaitDone()
+	defer s.Done()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.Add(1)
+			s.Done()
+		}
+	})
+}
+
+func BenchmarkZeroCrossing(b *testing.B) {
+	// The thread stays up, so this measures the slow path of the counter
+	// rather than starting threads
+	s := comshim.New(comshim.WithPermanent())
+	if err := s.Start(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close(context.Background())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Add(1)
+		s.Done()
+	}
+}
+
+func TestAddDoneDoesNotAllocate(t *testing.T) {
+	s := comshim.New(comshim.WithPermanent())
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(context.Background())
+
+	// Both the counter crossing zero and Add/Done while it is held
+	if allocs := testing.AllocsPerRun(100, func() {
+		s.Add(1)
+		s.Add(1)
+		s.Done()
+		s.Done()
+	}); allocs != 0 {
+		t.Errorf("Add and Done allocated %v times, want 0", allocs)
+	}
+}
+
+func TestAddDoneWhileHeldKeepsThread(t *testing.T) {
+	s := comshim.New()
+	s.Add(1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				s.Add(1)
+				s.Done()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if st := s.Stats(); st.Starts != 1 || st.PeakCount < 2 || st.TotalAdds != 8001 {
+		t.Errorf("stats after concurrent Add and Done are %+v, want one start, a peak of at least 2 and 8001 adds", st)
+	}
+	s.Done()
+	s.WaitDone()
+}
+
+func TestSTAShim(t *testing.T) {
+	s := comshim.NewSTA()
+	for i := 0; i < 3; i++ {
+		s.Add(1)
+		if !s.IsRunning() || !s.IsInitialized() {
+			t.Fatal("STA shim is not running after Add")
+		}
+		s.Done()
+		s.WaitDone()
+		if s.IsRunning() || s.IsInitialized() {
+			t.Fatal("STA shim is still running after its counter reached zero")
+		}
+	}
+}
+
+func TestTryAddContext(t *testing.T) {
+	s := comshim.New()
+	defer s.WaitDone()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := s.TryAddContext(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsRunning() {
+		t.Error("shim is not running after TryAddContext")
+	}
+	s.Done()
+
+	cancel()
+	if err := s.TryAddContext(ctx, 1); err != context.Canceled {
+		t.Errorf("TryAddContext returned %v, want %v", err, context.Canceled)
+	}
+	if count := s.Count(); count != 0 {
+		t.Errorf("counter is %d after a canceled TryAddContext, want 0", count)
+	}
+}
+
+func TestWithReleasesOnPanic(t *testing.T) {
+	s := comshim.New()
+	defer s.WaitDone()
+
+	errFn := errors.New("fn failed")
+	if err := s.With(func() error {
+		if s.Count() != 1 {
+			t.Errorf("counter is %d inside With, want 1", s.Count())
+		}
+		return errFn
+	}); err != errFn {
+		t.Errorf("With returned %v, want %v", err, errFn)
+	}
+
+	func() {
+		defer func() { _ = recover() }()
+		s.With(func() error { panic("injected panic") })
+	}()
+	if count := s.Count(); count != 0 {
+		t.Errorf("counter is %d after With panicked, want 0", count)
+	}
+}
+
+func TestTryDoneDoesNotUnderflow(t *testing.T) {
+	s := comshim.New()
+	if err := s.TryDone(); err != comshim.ErrNegativeCounter {
+		t.Errorf("TryDone returned %v, want %v", err, comshim.ErrNegativeCounter)
+	}
+	if count := s.Count(); count != 0 {
+		t.Errorf("counter is %d after TryDone underflowed, want 0", count)
+	}
+
+	defer func() {
+		if r := recover(); r != comshim.ErrNegativeCounter {
+			t.Errorf("Done panicked with %v, want %v", r, comshim.ErrNegativeCounter)
+		}
+	}()
+	s.Done()
+}
+
+func TestDoneN(t *testing.T) {
+	s := comshim.New()
+	defer s.WaitDone()
+
+	s.Add(100)
+	if err := s.DoneN(101); err != comshim.ErrNegativeCounter {
+		t.Errorf("DoneN beyond the counter returned %v, want %v", err, comshim.ErrNegativeCounter)
+	}
+	if err := s.DoneN(-1); err != comshim.ErrNegativeDelta {
+		t.Errorf("DoneN(-1) returned %v, want %v", err, comshim.ErrNegativeDelta)
+	}
+	if count := s.Count(); count != 100 {
+		t.Errorf("counter is %d after failed calls to DoneN, want 100", count)
+	}
+	if err := s.DoneN(100); err != nil {
+		t.Fatal(err)
+	}
+	if count := s.Count(); count != 0 {
+		t.Errorf("counter is %d after DoneN, want 0", count)
+	}
+}
+
+func TestWithNegativeCounterErrors(t *testing.T) {
+	s := comshim.New(comshim.WithNegativeCounterErrors())
+	s.Done()
+	if err := s.TryAdd(-1); err != comshim.ErrNegativeCounter {
+		t.Errorf("TryAdd returned %v, want %v", err, comshim.ErrNegativeCounter)
+	}
+	if count := s.Count(); count != 0 {
+		t.Errorf("counter is %d after underflows, want 0", count)
+	}
+}
+
+func TestStrictOwnership(t *testing.T) {
+	s := comshim.New(comshim.WithStrictOwnership(), comshim.WithNegativeCounterErrors())
+
+	if err := s.TryAdd(1); err != comshim.ErrNoClient {
+		t.Errorf("TryAdd without a client returned %v, want %v", err, comshim.ErrNoClient)
+	}
+	if _, err := s.TryAcquire(); err != comshim.ErrNoClient {
+		t.Errorf("TryAcquire without a client returned %v, want %v", err, comshim.ErrNoClient)
+	}
+
+	a := s.NewClient("a")
+	if b := s.NewClient("a"); b != a {
+		t.Error("NewClient returned a different client for the same name")
+	}
+	b := s.NewClient("b")
+	if err := a.TryAdd(2); err != nil {
+		t.Fatalf("TryAdd through a client returned %v", err)
+	}
+	if err := b.TryAdd(1); err != nil {
+		t.Fatalf("TryAdd through a client returned %v", err)
+	}
+	if err := s.TryDone(); err != comshim.ErrNoClient {
+		t.Errorf("TryDone without a client returned %v, want %v", err, comshim.ErrNoClient)
+	}
+	if err := s.Run(func() error { return nil }); err != nil {
+		t.Errorf("Run on a strict shim returned %v", err)
+	}
+	if counts := s.ClientCounts(); counts["a"] != 2 || counts["b"] != 1 || len(counts) != 2 {
+		t.Errorf("ClientCounts returned %v, want map[a:2 b:1]", counts)
+	}
+
+	// A client can't give back references held by another
+	if err := b.TryAdd(-2); err != comshim.ErrNegativeCounter {
+		t.Errorf("giving back more than the client holds returned %v, want %v", err, comshim.ErrNegativeCounter)
+	}
+	a.Done()
+	a.Done()
+	b.Done()
+	if count := s.Count(); count != 0 {
+		t.Errorf("counter is %d after every client is done, want 0", count)
+	}
+	if counts := s.ClientCounts(); counts["a"] != 0 || counts["b"] != 0 {
+		t.Errorf("ClientCounts returned %v after every client is done, want zeros", counts)
+	}
+	s.WaitDone()
+}
+
+func TestWaitDoneTimeout(t *testing.T) {
+	s := comshim.New()
+
+	if err := s.WaitDoneTimeout(time.Millisecond); err != nil {
+		t.Fatalf("WaitDoneTimeout on an idle shim returned %v", err)
+	}
+
+	s.Add(1)
+	if err := s.WaitDoneTimeout(10 * time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitDoneTimeout while the counter is held returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// Giving up must not get in the way of the shim being used
+	s.Add(1)
+	s.Done()
+	s.Done()
+	if err := s.WaitDoneTimeout(5 * time.Second); err != nil {
+		t.Errorf("WaitDoneTimeout after release returned %v", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	s := comshim.New()
+	s.Add(1) // Leaked on purpose
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Close(ctx); err != nil {
+		t.Fatalf("Close returned %v", err)
+	}
+	if s.IsRunning() {
+		t.Error("shim is still running after Close")
+	}
+	if err := s.TryAdd(1); !errors.Is(err, comshim.ErrClosed) {
+		t.Errorf("TryAdd after Close returned %v, want %v", err, comshim.ErrClosed)
+	}
+	if err := s.Run(func() error { return nil }); !errors.Is(err, comshim.ErrClosed) {
+		t.Errorf("Run after Close returned %v, want %v", err, comshim.ErrClosed)
+	}
+
+	// The leaked reference can still be given back
+	if err := s.TryDone(); err != nil {
+		t.Errorf("TryDone after Close returned %v", err)
+	}
+	if err := s.Close(ctx); err != nil {
+		t.Errorf("second Close returned %v", err)
+	}
+}
+
+func TestWinRTShim(t *testing.T) {
+	s := comshim.NewWinRT()
+	if err := s.TryAdd(1); err != nil {
+		t.Skipf("the Windows Runtime is unavailable: %v", err)
+	}
+	if err := s.Run(func() error { return nil }); err != nil {
+		t.Errorf("Run on a Windows Runtime shim returned %v", err)
+	}
+	s.Done()
+	s.WaitDone()
+}
+
+func TestInitError(t *testing.T) {
+	var err error = &comshim.InitError{Op: "CoInitializeEx", HRESULT: 0x80010106, Err: ole.NewError(0x80010106)}
+
+	var oerr *ole.OleError
+	if !errors.As(err, &oerr) || oerr.Code() != 0x80010106 {
+		t.Errorf("errors.As did not find the HRESULT in %v", err)
+	}
+	if want := "component object model shim: CoInitializeEx failed (HRESULT 0x80010106): "; !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("error message is %q, want it to start with %q", err, want)
+	}
+
+	if !errors.Is(err, comshim.ErrChangedMode) || errors.Is(err, comshim.ErrInvalidArg) {
+		t.Errorf("errors.Is did not match %v to ErrChangedMode alone", err)
+	}
+	if !strings.Contains(err.Error(), "WithApartmentFallback") {
+		t.Errorf("error message %q doesn't suggest a remedy", err)
+	}
+
+	err = &comshim.InitError{Op: "CoInitializeEx", HRESULT: 1, Err: comshim.ErrAlreadyInitialized}
+	if !errors.Is(err, comshim.ErrAlreadyInitialized) {
+		t.Errorf("errors.Is did not find ErrAlreadyInitialized in %v", err)
+	}
+}
+
+func TestCreateObjectReleasesReferenceOnFailure(t *testing.T) {
+	s := comshim.New()
+	if _, _, err := s.CreateDispatch("ComShim.DoesNotExist"); err == nil {
+		t.Fatal("CreateDispatch of an unregistered class succeeded")
+	}
+	if count := s.Count(); count != 0 {
+		t.Errorf("counter is %d after a failed CreateDispatch, want 0", count)
+	}
+
+	// No such machine
+	clsid := ole.NewGUID("{00000000-0000-0000-0000-000000000001}")
+	if _, _, err := s.CreateRemoteObject("comshim.invalid", clsid, ole.IID_IUnknown, comshim.AuthInfo{}); err == nil {
+		t.Fatal("CreateRemoteObject on a nonexistent server succeeded")
+	}
+	if count := s.Count(); count != 0 {
+		t.Errorf("counter is %d after a failed CreateRemoteObject, want 0", count)
+	}
+	s.WaitDone()
+}
+
+func TestCounterStaysInRange(t *testing.T) {
+	const limit = 4
+	s := comshim.New(comshim.WithMaxHolders(limit))
+
+	stop := make(chan struct{})
+	observed := make(chan int64, 1)
+	go func() {
+		defer close(observed)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if count := s.Count(); count < 0 || count > limit {
+				observed <- count
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if s.TryAdd(1) == nil {
+					s.Done()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	if count, ok := <-observed; ok {
+		t.Errorf("counter was observed at %d, outside of [0, %d]", count, limit)
+	}
+	s.WaitDone()
+	if count := s.Count(); count != 0 {
+		t.Errorf("counter is %d after every holder is done, want 0", count)
+	}
+}
+
+func TestDoneRacingStartup(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		s := comshim.New()
+		var wg sync.WaitGroup
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			s.Add(1)
+			s.Done()
+		}()
+		go func() {
+			defer wg.Done()
+			// Gives up in the middle of the start now and then
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(i)*time.Microsecond)
+			defer cancel()
+			if s.TryAddContext(ctx, 1) == nil {
+				s.Done()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := s.Run(func() error { return nil }); err != nil {
+				t.Errorf("Run returned %v", err)
+			}
+		}()
+		wg.Wait()
+
+		s.WaitDone()
+		if s.IsRunning() {
+			t.Fatalf("iteration %d: shim is still running after every holder is done", i)
+		}
+		if count := s.Count(); count != 0 {
+			t.Fatalf("iteration %d: counter is %d after every holder is done, want 0", i, count)
+		}
+	}
+}
+
+func TestWithMaxHolders(t *testing.T) {
+	s := comshim.New(comshim.WithMaxHolders(2))
+	defer s.WaitDone()
+
+	s.Add(2)
+	if err := s.TryAdd(1); !errors.Is(err, comshim.ErrTooManyHolders) {
+		t.Errorf("TryAdd beyond the limit returned %v, want %v", err, comshim.ErrTooManyHolders)
+	}
+	if count := s.Count(); count != 2 {
+		t.Errorf("counter is %d after a rejected TryAdd, want 2", count)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.WaitAdd(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitAdd without room returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	added := make(chan error)
+	go func() { added <- s.WaitAdd(context.Background(), 1) }()
+	time.Sleep(10 * time.Millisecond)
+	s.Done()
+	if err := <-added; err != nil {
+		t.Errorf("WaitAdd after Done returned %v", err)
+	}
+	if err := s.WaitAdd(context.Background(), 3); !errors.Is(err, comshim.ErrTooManyHolders) {
+		t.Errorf("WaitAdd for more than the limit returned %v, want %v", err, comshim.ErrTooManyHolders)
+	}
+
+	s.Done()
+	s.Done()
+}
+
+func TestAdviseRejectsInvalidSink(t *testing.T) {
+	s := comshim.New()
+	if _, err := s.Advise(nil, ole.IID_IDispatch, "not a sink"); err == nil {
+		t.Error("Advise accepted a string as a sink")
+	}
+	if count := s.Count(); count != 0 {
+		t.Errorf("counter is %d after a failed Advise, want 0", count)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	s := comshim.New()
+	events := make(chan comshim.Event, 16)
+	unsubscribe := s.Subscribe(func(ev comshim.Event) { events <- ev })
+	defer unsubscribe()
+
+	s.Add(1)
+	s.Done()
+	s.WaitDone()
+
+	want := []comshim.EventKind{comshim.EventStarting, comshim.EventInitialized, comshim.EventDraining, comshim.EventUninitialized}
+	for _, kind := range want {
+		select {
+		case ev := <-events:
+			if ev.Kind != kind {
+				t.Fatalf("received %v event, want %v", ev.Kind, kind)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the %v event", kind)
+		}
+	}
+
+	unsubscribe()
+	s.Add(1)
+	s.Done()
+	s.WaitDone()
+	select {
+	case ev := <-events:
+		t.Errorf("received %v event after unsubscribing", ev.Kind)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestWaitForEvent(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, kind := range []comshim.EventKind{comshim.EventStarting, comshim.EventInitialized} {
+		s := comshim.New()
+		waited := make(chan error, 1)
+		go func() { waited <- s.WaitForEvent(ctx, kind) }()
+		for started := false; !started; {
+			// Cycle until the waiter has subscribed and seen a start
+			s.Add(1)
+			s.Done()
+			s.WaitDone()
+			select {
+			case err := <-waited:
+				if err != nil {
+					t.Errorf("waiting for the %v event returned %v", kind, err)
+				}
+				started = true
+			default:
+			}
+		}
+
+		// A shim that is already running doesn't count until it starts again
+		s.Add(1)
+		short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		if err := s.WaitForEvent(short, kind); err != context.DeadlineExceeded {
+			t.Errorf("waiting for the %v event on a running shim returned %v, want %v", kind, err, context.DeadlineExceeded)
+		}
+		cancel()
+		s.Done()
+		s.WaitDone()
+	}
+
+	s := comshim.New()
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := s.WaitForEvent(short, comshim.EventExpired); err != context.DeadlineExceeded {
+		t.Errorf("waiting for an event that never comes returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestGoroutineProfileLabel(t *testing.T) {
+	s := comshim.New(comshim.WithThreadName("comshim profile test"))
+	s.Add(1)
+	defer s.WaitDone()
+	defer s.Done()
+
+	var b strings.Builder
+	if err := pprof.Lookup("goroutine").WriteTo(&b, 1); err != nil {
+		t.Fatal(err)
+	}
+	if want := `"comshim":"comshim profile test"`; !strings.Contains(b.String(), want) {
+		t.Errorf("goroutine profile does not contain the label %s", want)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	s := comshim.New(comshim.WithIdleTimeout(time.Hour))
+	s.Add(1)
+
+	drained := make(chan error)
+	go func() { drained <- s.Drain(context.Background()) }()
+	for s.TryAdd(1) == nil {
+		s.Done() // Drain hasn't started yet
+		time.Sleep(time.Millisecond)
+	}
+	if err := s.TryAdd(1); !errors.Is(err, comshim.ErrDraining) {
+		t.Errorf("TryAdd while draining returned %v, want %v", err, comshim.ErrDraining)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Drain with a reference held returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	s.Done()
+	if err := <-drained; err != nil {
+		t.Fatalf("Drain returned %v", err)
+	}
+	if s.IsRunning() {
+		t.Error("shim is still running after Drain")
+	}
+	if err := s.TryAdd(1); !errors.Is(err, comshim.ErrClosed) {
+		t.Errorf("TryAdd after Drain returned %v, want %v", err, comshim.ErrClosed)
+	}
+}
+
+func TestPinCurrentGoroutine(t *testing.T) {
+	s := comshim.New()
+	if _, err := s.PinCurrentGoroutine(comshim.ApartmentNA); err == nil {
+		t.Error("PinCurrentGoroutine accepted the neutral apartment")
+	}
+
+	unpin, err := s.PinCurrentGoroutine(comshim.ApartmentSTA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WaitDoneTimeout(10 * time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitDoneTimeout with a pinned goroutine returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	unpin()
+	unpin()
+	if err := s.WaitDoneTimeout(5 * time.Second); err != nil {
+		t.Errorf("WaitDoneTimeout after unpinning returned %v", err)
+	}
+}
+
+func TestStart(t *testing.T) {
+	s := comshim.New(comshim.WithEagerStart())
+	if !s.IsRunning() {
+		t.Fatal("shim with an eager start is not running after New")
+	}
+	if count := s.Count(); count != 0 {
+		t.Errorf("counter is %d after an eager start, want 0", count)
+	}
+
+	s.Add(1)
+	s.Done()
+	if err := s.WaitDoneTimeout(10 * time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitDoneTimeout after an eager start returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if !s.IsRunning() {
+		t.Error("shim stopped when its counter dropped to zero after an eager start")
+	}
+
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if s.IsRunning() {
+		t.Error("shim is still running after Close")
+	}
+}
+
+// panickingUninitializer panics after uninitializing the thread for the first
+// time.
+type panickingUninitializer struct {
+	*comshimtest.Initializer
+	panicked bool
+}
+
+func (p *panickingUninitializer) Uninitialize() {
+	p.Initializer.Uninitialize()
+	if !p.panicked {
+		p.panicked = true
+		panic("injected panic")
+	}
+}
+
+func TestUninitializeOnce(t *testing.T) {
+	f := comshimtest.NewInitializer()
+	s := comshim.New(comshim.WithInitializer(f))
+
+	// A thread that was already initialized
+	f.AlreadyInitialized()
+	if err := s.TryAdd(1); !errors.Is(err, comshim.ErrAlreadyInitialized) {
+		t.Errorf("TryAdd on an initialized thread returned %v, want %v", err, comshim.ErrAlreadyInitialized)
+	}
+	s.WaitDone()
+	if n := f.Initialized(); n != 0 {
+		t.Errorf("%d initializations left after S_FALSE, want 0", n)
+	}
+
+	// Racing calls to Close
+	s = comshim.New(comshim.WithInitializer(f))
+	s.Add(1)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Close(context.Background())
+		}()
+	}
+	wg.Wait()
+	if n := f.Initialized(); n != 0 {
+		t.Errorf("%d initializations left after concurrent calls to Close, want 0", n)
+	}
+
+	// A panic in CoUninitialize itself
+	p := &panickingUninitializer{Initializer: comshimtest.NewInitializer()}
+	s = comshim.New(comshim.WithInitializer(p))
+	s.Add(1)
+	s.Done()
+	s.WaitDone()
+	if n := p.Initialized(); n != 0 {
+		t.Errorf("%d initializations left after CoUninitialize panicked, want 0", n)
+	}
+}
+
+func TestWithErrorHandler(t *testing.T) {
+	f := comshimtest.NewInitializer()
+	var handled []error
+	s := comshim.New(comshim.WithInitializer(f), comshim.WithErrorHandler(func(err error) comshim.Action {
+		handled = append(handled, err)
+		return comshim.ActionLog
+	}))
+
+	s.Done()
+	errInit := errors.New("injected failure")
+	f.FailNext(errInit)
+	s.Add(1)
+	if count := s.Count(); count != 0 {
+		t.Errorf("counter is %d after errors were ignored, want 0", count)
+	}
+	if len(handled) != 2 || handled[0] != comshim.ErrNegativeCounter || !errors.Is(handled[1], errInit) {
+		t.Errorf("error handler received %v, want %v and %v", handled, comshim.ErrNegativeCounter, errInit)
+	}
+
+	s = comshim.New(comshim.WithErrorHandler(func(err error) comshim.Action { return comshim.ActionPanic }))
+	defer func() {
+		if r := recover(); r != comshim.ErrNegativeCounter {
+			t.Errorf("Done panicked with %v, want %v", r, comshim.ErrNegativeCounter)
+		}
+	}()
+	s.Done()
+}
+
+func TestWithShim(t *testing.T) {
+	s := comshim.New()
+	if _, ok := comshim.FromContext(context.Background()); ok {
+		t.Error("FromContext found a shim in the background context")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx, err := comshim.WithShim(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := comshim.FromContext(ctx); !ok || got != s {
+		t.Errorf("FromContext returned %p, %v, want %p, true", got, ok, s)
+	}
+	if count := s.Count(); count != 1 {
+		t.Errorf("counter is %d while the context is live, want 1", count)
+	}
+
+	cancel()
+	if err := s.WaitDoneTimeout(5 * time.Second); err != nil {
+		t.Errorf("WaitDoneTimeout after canceling the context returned %v", err)
+	}
+	if count := s.Count(); count != 0 {
+		t.Errorf("counter is %d after canceling the context, want 0", count)
+	}
+}
+
+func TestClosedStopsWatchers(t *testing.T) {
+	// The contexts stay live, so that only closing the shims can stop the
+	// goroutines watching them
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	baseline := runtime.NumGoroutine()
+	for i := 0; i < 1000; i++ {
+		s := comshim.New()
+		if _, err := comshim.WithShim(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-s.Closed():
+		default:
+			t.Fatal("Closed channel is open after Close")
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines are running after closing every shim, want %d", runtime.NumGoroutine(), baseline)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWithWatchdog(t *testing.T) {
+	stalls := make(chan time.Duration, 16)
+	s := comshim.New(comshim.WithWatchdog(10*time.Millisecond, func(stalled time.Duration) {
+		select {
+		case stalls <- stalled:
+		default:
+		}
+	}))
+	defer s.WaitDone()
+
+	if err := s.Ping(context.Background()); err != comshim.ErrStopped {
+		t.Errorf("Ping on a stopped shim returned %v, want %v", err, comshim.ErrStopped)
+	}
+	s.Add(1)
+	defer s.Done()
+	if err := s.Ping(context.Background()); err != nil {
+		t.Errorf("Ping on a running shim returned %v", err)
+	}
+
+	if err := s.Run(func() error {
+		select {
+		case <-stalls:
+		case <-time.After(5 * time.Second):
+			t.Error("watchdog didn't report the stalled thread")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSharedThread(t *testing.T) {
+	thread := comshim.NewSharedThread()
+	a, b := thread.NewShim(), thread.NewShim()
+	defer thread.Shim().WaitDone()
+
+	a.Add(1)
+	b.Add(1)
+	if count := thread.Shim().Count(); count != 2 {
+		t.Errorf("shared thread counter is %d with two shims holding it, want 2", count)
+	}
+
+	var ids [2]uint32
+	for i, s := range []*comshim.Shim{a, b} {
+		if err := s.Run(func() error {
+			ids[i] = thread.Shim().ThreadID()
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ids[0] != ids[1] {
+		t.Errorf("shims ran on threads %d and %d, want the same thread", ids[0], ids[1])
+	}
+
+	a.Done()
+	if !thread.Shim().IsRunning() {
+		t.Error("shared thread stopped while a shim still held it")
+	}
+	if a.IsRunning() {
+		t.Error("shim whose counter dropped to zero is still running")
+	}
+	b.Done()
+	if err := thread.Shim().WaitDoneTimeout(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if thread.Shim().IsRunning() {
+		t.Error("shared thread is still running after every shim was done")
+	}
+}
+
+func TestWithInitHook(t *testing.T) {
+	f := comshimtest.NewInitializer()
+	errHook := errors.New("injected hook failure")
+	var calls []string
+	fail := true
+	s := comshim.New(
+		comshim.WithInitializer(f),
+		comshim.WithInitHook(func() error {
+			calls = append(calls, "first")
+			if fail {
+				return errHook
+			}
+			return nil
+		}),
+		comshim.WithInitHook(func() error {
+			calls = append(calls, "second")
+			return nil
+		}),
+	)
+	defer s.WaitDone()
+
+	if err := s.TryAdd(1); err != errHook {
+		t.Fatalf("TryAdd with a failing init hook returned %v, want %v", err, errHook)
+	}
+	if n := f.Initialized(); n != 0 {
+		t.Errorf("thread is initialized %d times after a failed init hook, want 0", n)
+	}
+
+	fail = false
+	calls = nil
+	if err := s.TryAdd(1); err != nil {
+		t.Fatal(err)
+	}
+	s.Done()
+	if len(calls) != 2 || calls[0] != "first" || calls[1] != "second" {
+		t.Errorf("init hooks were called as %q, want first then second", calls)
+	}
+}
+
+func TestWithTeardownHook(t *testing.T) {
+	f := comshimtest.NewInitializer()
+	var calls []string
+	var initialized []int
+	s := comshim.New(
+		comshim.WithInitializer(f),
+		comshim.WithTeardownHook(func() {
+			calls = append(calls, "first")
+			initialized = append(initialized, f.Initialized())
+		}),
+		comshim.WithTeardownHook(func() {
+			calls = append(calls, "second")
+		}),
+	)
+
+	s.Add(1)
+	s.Done()
+	s.WaitDone()
+
+	if len(calls) != 2 || calls[0] != "second" || calls[1] != "first" {
+		t.Errorf("teardown hooks were called as %q, want second then first", calls)
+	}
+	if len(initialized) != 1 || initialized[0] != 1 {
+		t.Errorf("teardown hook saw the thread initialized %v times, want 1", initialized)
+	}
+	if n := f.Initialized(); n != 0 {
+		t.Errorf("thread is initialized %d times after teardown, want 0", n)
+	}
+}
+
+func TestAddStrict(t *testing.T) {
+	f := comshimtest.NewInitializer()
+	s := comshim.NewSTA(comshim.WithInitializer(f))
+	defer s.WaitDone()
+
+	if err := s.AddStrict(1); err != nil {
+		t.Fatalf("AddStrict in the configured apartment returned %v", err)
+	}
+	s.Done()
+	s.WaitDone()
+
+	// Other code got to the thread first and made it join the MTA
+	f.SetApartment(comshim.ApartmentMTA)
+	if err := s.TryAdd(1); err != nil {
+		t.Fatalf("TryAdd, which doesn't check the apartment, returned %v", err)
+	}
+	s.Done()
+	if err := s.AddStrict(2); !errors.Is(err, comshim.ErrApartmentMismatch) {
+		t.Errorf("AddStrict in the wrong apartment returned %v, want %v", err, comshim.ErrApartmentMismatch)
+	}
+	if n := s.Count(); n != 0 {
+		t.Errorf("counter is %d after a failed AddStrict, want 0", n)
+	}
+}
+
+func TestHookOrder(t *testing.T) {
+	var calls []string
+	var opts []comshim.Option
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		opts = append(opts,
+			comshim.WithInitHook(func() error {
+				calls = append(calls, "init "+name)
+				return nil
+			}),
+			comshim.WithTeardownHook(func() {
+				calls = append(calls, "teardown "+name)
+				if name == "b" {
+					panic("injected teardown panic")
+				}
+			}),
+		)
+	}
+	s := comshim.New(opts...)
+
+	s.Add(1)
+	s.Done()
+	s.WaitDone()
+
+	// The panic in the middle hook doesn't keep the last one from running
+	want := []string{"init a", "init b", "init c", "teardown c", "teardown b", "teardown a"}
+	if strings.Join(calls, ", ") != strings.Join(want, ", ") {
+		t.Errorf("hooks were called as %q, want %q", calls, want)
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("Err after a teardown hook panicked returned %v", err)
+	}
+}
+
+func TestInitHookPanic(t *testing.T) {
+	f := comshimtest.NewInitializer()
+	var calls []string
+	s := comshim.New(
+		comshim.WithInitializer(f),
+		comshim.WithInitHook(func() error { calls = append(calls, "init a"); return nil }),
+		comshim.WithInitHook(func() error { panic("injected init panic") }),
+		comshim.WithInitHook(func() error { calls = append(calls, "init c"); return nil }),
+		comshim.WithTeardownHook(func() { calls = append(calls, "teardown a") }),
+		comshim.WithTeardownHook(func() { calls = append(calls, "teardown b") }),
+		comshim.WithTeardownHook(func() { calls = append(calls, "teardown c") }),
+	)
+	defer s.WaitDone()
+
+	var perr *comshim.PanicError
+	if err := s.TryAdd(1); !errors.As(err, &perr) {
+		t.Fatalf("TryAdd with a panicking init hook returned %v, want a *PanicError", err)
+	}
+	// The work of the init hook that succeeded is undone, and nothing else
+	want := []string{"init a", "teardown a"}
+	if strings.Join(calls, ", ") != strings.Join(want, ", ") {
+		t.Errorf("hooks were called as %q, want %q", calls, want)
+	}
+	if n := f.Initialized(); n != 0 {
+		t.Errorf("thread is initialized %d times after an init hook panicked, want 0", n)
+	}
+}
+
+func TestWithStartTimeout(t *testing.T) {
+	f := comshimtest.NewInitializer()
+	f.SetDelay(200 * time.Millisecond)
+	s := comshim.New(comshim.WithInitializer(f), comshim.WithStartTimeout(10*time.Millisecond))
+	defer s.WaitDone()
+
+	if err := s.TryAdd(1); err != comshim.ErrStartTimeout {
+		t.Fatalf("TryAdd with a slow start returned %v, want %v", err, comshim.ErrStartTimeout)
+	}
+	if count := s.Count(); count != 0 {
+		t.Errorf("counter is %d after a start timed out, want 0", count)
+	}
+
+	// The abandoned start releases the thread once it comes up
+	s.WaitDone()
+	if n := f.Initialized(); n != 0 {
+		t.Errorf("thread is initialized %d times after the abandoned start, want 0", n)
+	}
+
+	f.SetDelay(0)
+	if err := s.TryAdd(1); err != nil {
+		t.Fatalf("TryAdd after a timed out start returned %v", err)
+	}
+	s.Done()
+}
+
+func TestWithMaxLifetime(t *testing.T) {
+	s := comshim.New(comshim.WithMaxLifetime(50 * time.Millisecond))
+	expired := make(chan struct{})
+	unsubscribe := s.Subscribe(func(ev comshim.Event) {
+		if ev.Kind == comshim.EventExpired {
+			close(expired)
+		}
+	})
+	defer unsubscribe()
+
+	s.Add(1) // Leaked on purpose
+
+	select {
+	case <-expired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the shim to expire")
+	}
+	if err := s.WaitDoneTimeout(5 * time.Second); err != nil {
+		t.Fatalf("shim with a leaked reference wasn't closed when it expired: %v", err)
+	}
+	if err := s.TryAdd(1); err != comshim.ErrClosed {
+		t.Errorf("TryAdd on an expired shim returned %v, want %v", err, comshim.ErrClosed)
+	}
+}
+
+func TestWithStopChannel(t *testing.T) {
+	stop := make(chan struct{})
+	tornDown := make(chan struct{})
+	s := comshim.New(comshim.WithStopChannel(stop), comshim.WithTeardownHook(func() { close(tornDown) }))
+
+	s.Add(1)
+	close(stop)
+	if err := s.WaitDoneTimeout(5 * time.Second); err != nil {
+		t.Fatalf("shim with a reference wasn't closed when its stop channel was: %v", err)
+	}
+	select {
+	case <-tornDown:
+	default:
+		t.Error("the teardown hook wasn't called when the stop channel was closed")
+	}
+	if !s.UnbalancedTeardown() {
+		t.Error("UnbalancedTeardown is false after abandoning a reference")
+	}
+	if err := s.TryAdd(1); err != comshim.ErrClosed {
+		t.Errorf("TryAdd after closing the stop channel returned %v, want %v", err, comshim.ErrClosed)
+	}
+	s.Done() // Taken before the stop channel was closed
+
+	// Closing the shim first stops watching the channel
+	s = comshim.New(comshim.WithStopChannel(make(chan struct{})))
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if s.UnbalancedTeardown() {
+		t.Error("UnbalancedTeardown is true for a shim that was closed normally")
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	from, to := comshim.New(), comshim.New()
+	defer from.WaitDone()
+	defer to.WaitDone()
+
+	from.Add(2)
+	if err := from.Transfer(to, 3); err != comshim.ErrNegativeCounter {
+		t.Errorf("Transfer of more references than held returned %v, want %v", err, comshim.ErrNegativeCounter)
+	}
+	if from.Count() != 2 || to.Count() != 0 {
+		t.Errorf("counters are %d and %d after a failed transfer, want 2 and 0", from.Count(), to.Count())
+	}
+
+	if err := from.Transfer(to, 2); err != nil {
+		t.Fatal(err)
+	}
+	if from.Count() != 0 || to.Count() != 2 {
+		t.Errorf("counters are %d and %d after the transfer, want 0 and 2", from.Count(), to.Count())
+	}
+	if !to.IsRunning() {
+		t.Error("shim receiving the references is not running")
+	}
+	to.Done()
+	to.Done()
+}
+
+func TestWaitZero(t *testing.T) {
+	s := comshim.New(comshim.WithPermanent())
+	defer s.Close(context.Background())
+
+	if err := s.WaitZero(context.Background()); err != nil {
+		t.Fatalf("WaitZero on an unused shim returned %v", err)
+	}
+
+	s.Add(2)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.WaitZero(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitZero while the counter is held returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	go func() {
+		s.Done()
+		s.Done()
+	}()
+	if err := s.WaitZero(context.Background()); err != nil {
+		t.Fatalf("WaitZero returned %v", err)
+	}
+	if !s.IsRunning() {
+		t.Error("permanent shim stopped once the counter reached zero")
+	}
+}
+
+func TestWithPermanent(t *testing.T) {
+	s := comshim.New(comshim.WithPermanent())
+	if s.IsRunning() {
+		t.Fatal("permanent shim is running before it was first used")
+	}
+
+	s.Add(1)
+	s.Done()
+	if err := s.WaitDoneTimeout(10 * time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitDoneTimeout on a permanent shim returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if !s.IsRunning() {
+		t.Error("permanent shim stopped when its counter dropped to zero")
+	}
+	if count := s.Count(); count != 0 {
+		t.Errorf("counter of a permanent shim is %d, want 0", count)
+	}
+
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if s.IsRunning() {
+		t.Error("permanent shim is still running after Close")
+	}
+}
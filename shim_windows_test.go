@@ -0,0 +1,668 @@
+package comshim_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/NozomiNetworks/go-comshim"
+	"github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+	"golang.org/x/sys/windows"
+)
+
+func TestConcurrentShims(t *testing.T) {
+	defer comshim.WaitDone()
+	var maxRounds int
+	if testing.Short() {
+		maxRounds = 64
+	} else {
+		maxRounds = 256
+	}
+
+	// Vary the number of threads
+	for procs := 1; procs < 11; procs++ {
+		runtime.GOMAXPROCS(procs)
+
+		// Vary the number of shims
+		for rounds := 1; rounds <= maxRounds; rounds *= 2 {
+			wg := sync.WaitGroup{}
+			for i := 0; i < rounds; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+
+					comshim.Add(1)
+					defer comshim.Done()
+
+					obj, err := oleutil.CreateObject("WbemScripting.SWbemLocator")
+					if err != nil {
+						t.Error(err)
+					} else {
+						defer obj.Release()
+					}
+				}(i)
+			}
+			wg.Wait()
+		}
+	}
+}
+
+func TestMTAUsageShim(t *testing.T) {
+	s := comshim.NewMTAUsage()
+	for i := 0; i < 3; i++ {
+		s.Add(1)
+		if !s.IsRunning() {
+			t.Fatal("shim is not running after Add")
+		}
+		err := s.Run(func() error {
+			obj, err := oleutil.CreateObject("WbemScripting.SWbemLocator")
+			if err != nil {
+				return err
+			}
+			obj.Release()
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		s.Done()
+		s.WaitDone()
+		if s.IsRunning() {
+			t.Fatal("shim is still running after its counter reached zero")
+		}
+	}
+}
+
+func TestAdoptHostApartmentIgnoresMTAUsageCookie(t *testing.T) {
+	cookie := comshim.NewMTAUsage()
+	cookie.Add(1)
+
+	// The MTA is only alive thanks to the cookie, so it must not be adopted
+	s := comshim.New(comshim.WithAdoptHostApartment())
+	s.Add(1)
+	if s.ThreadID() == 0 {
+		t.Error("shim adopted the MTA kept alive by another shim's cookie")
+	}
+	cookie.Done()
+	cookie.WaitDone()
+	if apt, err := s.VerifyApartment(); err != nil || apt != comshim.ApartmentMTA {
+		t.Errorf("VerifyApartment after the cookie was released returned %v, %v", apt, err)
+	}
+	s.Done()
+	s.WaitDone()
+}
+
+func TestMTAUsageAlongsideThread(t *testing.T) {
+	cookie, thread := comshim.NewMTAUsage(), comshim.New()
+	defer cookie.WaitDone()
+	defer thread.WaitDone()
+	// Creating an object tells that COM is still usable
+	run := func(s *comshim.Shim, what string) {
+		t.Helper()
+		err := s.Run(func() error {
+			obj, err := oleutil.CreateObject("WbemScripting.SWbemLocator")
+			if err != nil {
+				return err
+			}
+			obj.Release()
+			return nil
+		})
+		if err != nil {
+			t.Errorf("Run on the %s shim returned %v", what, err)
+		}
+	}
+
+	// Releasing the thread leaves the cookie's apartment alone
+	cookie.Add(1)
+	thread.Add(1)
+	thread.Done()
+	thread.WaitDone()
+	if !cookie.IsRunning() {
+		t.Fatal("the cookie shim stopped when the thread shim did")
+	}
+	run(cookie, "cookie")
+
+	// And releasing the cookie leaves the thread alone
+	thread.Add(1)
+	cookie.Done()
+	cookie.WaitDone()
+	if !thread.IsRunning() {
+		t.Fatal("the thread shim stopped when the cookie shim did")
+	}
+	run(thread, "thread")
+	thread.Done()
+}
+
+func TestRunCreatesObjectsOnSTA(t *testing.T) {
+	s := comshim.NewSTA()
+	defer s.WaitDone()
+
+	err := s.Run(func() error {
+		obj, err := oleutil.CreateObject("Scripting.Dictionary")
+		if err != nil {
+			return err
+		}
+		obj.Release()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestThreadID(t *testing.T) {
+	s := comshim.New()
+	defer s.WaitDone()
+
+	if id := s.ThreadID(); id != 0 {
+		t.Errorf("stopped shim reports thread %d", id)
+	}
+
+	s.Add(1)
+	id := s.ThreadID()
+	if id == 0 {
+		t.Error("running shim reports no thread")
+	}
+	var got uint32
+	if err := s.Run(func() error {
+		got = windows.GetCurrentThreadId()
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got != id {
+		t.Errorf("Run executed on thread %d, want %d", got, id)
+	}
+	s.Done()
+}
+
+func TestGlobalInterfaceTable(t *testing.T) {
+	s := comshim.New()
+	defer s.WaitDone()
+
+	s.Add(1)
+	defer s.Done()
+
+	var obj *ole.IUnknown
+	if err := s.Run(func() (err error) {
+		obj, err = oleutil.CreateObject("Scripting.Dictionary")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Release()
+
+	cookie, err := s.RegisterInterface(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown, err := s.GetInterface(cookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown.Release()
+
+	if err := s.RevokeInterface(cookie); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetInterface(cookie); err == nil {
+		t.Error("GetInterface succeeded after the cookie was revoked")
+	}
+}
+
+func TestAgileRef(t *testing.T) {
+	s := comshim.NewSTA()
+	defer s.WaitDone()
+
+	s.Add(1)
+	defer s.Done()
+
+	var obj *ole.IUnknown
+	if err := s.Run(func() (err error) {
+		obj, err = oleutil.CreateObject("Scripting.Dictionary")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Run(func() error {
+		obj.Release()
+		return nil
+	})
+
+	ref, err := s.AgileRef(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown, err := ref.Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown.Release()
+	if err := ref.Release(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMarshalForThread(t *testing.T) {
+	s := comshim.NewSTA()
+	defer s.WaitDone()
+
+	obj, release, err := s.CreateDispatch("Scripting.Dictionary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	m, err := s.MarshalForThread(&obj.IUnknown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown, err := m.Unmarshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown.Release()
+	if _, err := m.Unmarshal(); err == nil {
+		t.Error("second Unmarshal succeeded")
+	}
+	if err := m.Release(); err != nil {
+		t.Errorf("Release after Unmarshal returned %v", err)
+	}
+}
+
+func TestCreateDispatch(t *testing.T) {
+	s := comshim.NewSTA()
+	dict, release, err := s.CreateDispatch("Scripting.Dictionary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := s.Count(); count != 1 {
+		t.Errorf("counter is %d while the object is alive, want 1", count)
+	}
+
+	err = s.Run(func() error {
+		_, err := oleutil.CallMethod(dict, "Add", "key", "value")
+		return err
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	release()
+	release()
+	if count := s.Count(); count != 0 {
+		t.Errorf("counter is %d after release, want 0", count)
+	}
+	s.WaitDone()
+}
+
+func TestProxyBlanketOnInProcessObject(t *testing.T) {
+	s := comshim.NewSTA(comshim.WithProxyBlanket(comshim.BlanketConfig{
+		AuthnLevel: comshim.AuthnLevelPktPrivacy,
+		ImpLevel:   comshim.ImpLevelImpersonate,
+	}))
+	// Scripting.Dictionary is in-process, so there is no proxy to configure
+	_, release, err := s.CreateDispatch("Scripting.Dictionary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	s.WaitDone()
+}
+
+func TestActivationContextMissingManifest(t *testing.T) {
+	s := comshim.New(comshim.WithActivationContext(`C:\comshim\does-not-exist.manifest`))
+	err := s.TryAdd(1)
+	var ierr *comshim.InitError
+	if !errors.As(err, &ierr) || ierr.Op != "ActivateActCtx" {
+		t.Errorf("TryAdd with a missing manifest returned %v, want an *InitError from ActivateActCtx", err)
+	}
+	s.WaitDone()
+}
+
+func TestThreadPriority(t *testing.T) {
+	getThreadPriority := windows.NewLazySystemDLL("kernel32.dll").NewProc("GetThreadPriority")
+	s := comshim.New(
+		comshim.WithThreadName("comshim test"),
+		comshim.WithThreadPriority(comshim.ThreadPriorityBelowNormal))
+
+	var priority int32
+	err := s.Run(func() error {
+		thread, _ := windows.GetCurrentThread()
+		r, _, _ := getThreadPriority.Call(uintptr(thread))
+		priority = int32(r)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if priority != int32(comshim.ThreadPriorityBelowNormal) {
+		t.Errorf("shim thread has priority %d, want %d", priority, comshim.ThreadPriorityBelowNormal)
+	}
+	s.WaitDone()
+}
+
+func TestVerifyApartment(t *testing.T) {
+	for _, tc := range []struct {
+		shim *comshim.Shim
+		want comshim.ApartmentType
+	}{
+		{comshim.New(), comshim.ApartmentMTA},
+		{comshim.NewSTA(), comshim.ApartmentSTA},
+	} {
+		tc.shim.Add(1)
+		apt, err := tc.shim.VerifyApartment()
+		if err != nil {
+			t.Errorf("VerifyApartment returned %v", err)
+		} else if apt != tc.want {
+			t.Errorf("VerifyApartment returned %v, want %v", apt, tc.want)
+		}
+		tc.shim.Done()
+		tc.shim.WaitDone()
+	}
+}
+
+func TestRunningObjectTable(t *testing.T) {
+	s := comshim.NewSTA()
+	defer s.WaitDone()
+
+	var clsid *ole.GUID
+	if err := s.Run(func() (err error) {
+		clsid, err = ole.CLSIDFromProgID("Scripting.Dictionary")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	obj, release, err := s.CreateObject(clsid, ole.IID_IUnknown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	revoke, err := s.RegisterActiveObject(obj, clsid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, releaseActive, err := s.GetActiveObject("Scripting.Dictionary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseActive()
+
+	if err := revoke(); err != nil {
+		t.Errorf("revoke returned %v", err)
+	}
+	if _, _, err := s.GetActiveObject("Scripting.Dictionary"); err == nil {
+		t.Error("GetActiveObject succeeded after the registration was revoked")
+	}
+}
+
+func TestRegisterClassObject(t *testing.T) {
+	s := comshim.NewSTA()
+	defer s.WaitDone()
+
+	var clsid *ole.GUID
+	if err := s.Run(func() (err error) {
+		clsid, err = ole.CLSIDFromProgID("Scripting.Dictionary")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	obj, release, err := s.CreateObject(clsid, ole.IID_IUnknown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	// Any object will do, as nothing asks the registration for instances
+	revoke, err := s.RegisterClassObject(ole.NewGUID("{6B1E5D3A-74C1-4F0B-9C3E-2A8D1F6E4B70}"), obj, comshim.RegClsMultipleUse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := s.Count(); count != 2 {
+		t.Errorf("counter is %d while the class object is registered, want 2", count)
+	}
+	revoke()
+	revoke()
+	if count := s.Count(); count != 1 {
+		t.Errorf("counter is %d after revoking, want 1", count)
+	}
+}
+
+func TestAdoptHostApartment(t *testing.T) {
+	// Stands in for the host, whose MTA the second shim adopts
+	host := comshim.New()
+	host.Add(1)
+	defer host.WaitDone()
+	defer host.Done()
+
+	s := comshim.New(comshim.WithAdoptHostApartment())
+	s.Add(1)
+	if id := s.ThreadID(); id != 0 {
+		t.Errorf("shim adopting the host's MTA runs on thread %d, want none", id)
+	}
+	if err := s.Run(func() error {
+		_, err := ole.CLSIDFromProgID("Scripting.Dictionary")
+		return err
+	}); err != nil {
+		t.Error(err)
+	}
+	s.Done()
+	s.WaitDone()
+}
+
+func TestCallMethod(t *testing.T) {
+	s := comshim.NewSTA()
+	defer s.WaitDone()
+	dict, release, err := s.CreateDispatch("Scripting.Dictionary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	if _, err := s.CallMethod(dict, "Add", "key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PutProperty(dict, "Item", "key", "changed"); err != nil {
+		t.Fatal(err)
+	}
+	item, err := s.GetProperty(dict, "Item", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ole.VariantClear(item)
+	if got := item.ToString(); got != "changed" {
+		t.Errorf("Item is %q, want %q", got, "changed")
+	}
+}
+
+func TestWrap(t *testing.T) {
+	s := comshim.NewSTA()
+	defer s.WaitDone()
+
+	var disp *ole.IDispatch
+	if err := s.Run(func() error {
+		unknown, err := oleutil.CreateObject("Scripting.Dictionary")
+		if err != nil {
+			return err
+		}
+		defer unknown.Release()
+		disp, err = unknown.QueryInterface(ole.IID_IDispatch)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	m := s.Wrap(disp)
+	if count := s.Count(); count != 1 {
+		t.Errorf("counter is %d while the object is wrapped, want 1", count)
+	}
+	if _, err := m.CallMethod("Add", "key", "value"); err != nil {
+		t.Error(err)
+	}
+	m.Close()
+	m.Close()
+	if count := s.Count(); count != 0 {
+		t.Errorf("counter is %d after Close, want 0", count)
+	}
+}
+
+func TestWithETW(t *testing.T) {
+	// Nobody enables the provider, so the events go nowhere
+	s := comshim.New(comshim.WithETW(*ole.NewGUID("{0D7C2B7E-3B61-4F1D-9E5A-6C2F8A41B93D}")))
+	if err := s.Run(func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	s.WaitDone()
+}
+
+func TestWithNamedEvent(t *testing.T) {
+	const name = `Local\comshim-test-named-event`
+	s := comshim.New(comshim.WithNamedEvent(name))
+	if cookie := s.ExternalCookie(); cookie != name {
+		t.Errorf("ExternalCookie returned %q, want %q", cookie, name)
+	}
+	p, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Add(1)
+	h, err := windows.OpenEvent(windows.SYNCHRONIZE, false, p)
+	if err != nil {
+		t.Errorf("event can't be opened while COM is initialized: %v", err)
+	} else {
+		windows.CloseHandle(h)
+	}
+	s.Done()
+	s.WaitDone()
+
+	if h, err := windows.OpenEvent(windows.SYNCHRONIZE, false, p); err == nil {
+		windows.CloseHandle(h)
+		t.Error("event can still be opened after the shim was released")
+	}
+}
+
+func TestRunFor(t *testing.T) {
+	// Scripting.Dictionary registers the Apartment threading model
+	clsid, err := ole.CLSIDFromProgID("Scripting.Dictionary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	model, err := comshim.ThreadingModelOf(*clsid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model != comshim.ThreadingModelApartment {
+		t.Errorf("threading model of Scripting.Dictionary is %v, want %v", model, comshim.ThreadingModelApartment)
+	}
+
+	m := comshim.NewApartmentManager(1)
+	defer m.Close(context.Background())
+	if err := m.RunFor(*clsid, func() error {
+		obj, err := oleutil.CreateObject("Scripting.Dictionary")
+		if err != nil {
+			return err
+		}
+		obj.Release()
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenFileStream(t *testing.T) {
+	s := comshim.New()
+	defer s.WaitDone()
+	path := filepath.Join(t.TempDir(), "stream.bin")
+	want := []byte("structured storage")
+
+	w, err := s.OpenFileStream(path, comshim.StgmCreate|comshim.StgmWrite|comshim.StgmShareExclusive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(want); err != nil {
+		t.Error(err)
+	}
+	w.Close()
+
+	r, err := s.OpenFileStream(path, comshim.StgmRead|comshim.StgmShareDenyWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("read %q back from the stream, want %q", got, want)
+	}
+	if _, err := s.OpenStorage(path, comshim.StgmRead|comshim.StgmShareDenyWrite); err == nil {
+		t.Error("OpenStorage opened a file that isn't a compound document")
+	}
+}
+
+func TestWithToken(t *testing.T) {
+	var token windows.Token
+	if err := windows.OpenProcessToken(windows.CurrentProcess(), windows.TOKEN_QUERY|windows.TOKEN_DUPLICATE|windows.TOKEN_IMPERSONATE, &token); err != nil {
+		t.Fatal(err)
+	}
+	defer token.Close()
+
+	s := comshim.New(comshim.WithToken(token))
+	defer s.WaitDone()
+	if err := s.Run(func() error {
+		// Only impersonating threads have a token of their own
+		var thread windows.Token
+		if err := windows.OpenThreadToken(windows.CurrentThread(), windows.TOKEN_QUERY, true, &thread); err != nil {
+			return err
+		}
+		return thread.Close()
+	}); err != nil {
+		t.Errorf("thread isn't impersonating: %v", err)
+	}
+}
+
+func TestCreateObjectAuto(t *testing.T) {
+	m := comshim.NewApartmentManager(1)
+	defer m.Close(context.Background())
+
+	obj, apt, err := m.CreateObjectAuto("Scripting.Dictionary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Close()
+	if apt != comshim.ApartmentMTA || obj.Shim() != m.MTA() {
+		t.Errorf("object was created in the %v, want the MTA, which can host it through COM's host STA", apt)
+	}
+	if _, err := obj.CallMethod("Add", "key", "value"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestThreadAffinity(t *testing.T) {
+	setThreadAffinityMask := windows.NewLazySystemDLL("kernel32.dll").NewProc("SetThreadAffinityMask")
+	s := comshim.New(comshim.WithThreadAffinity(1))
+
+	var mask uintptr
+	err := s.Run(func() error {
+		// Setting the same mask again reveals the current one
+		thread, _ := windows.GetCurrentThread()
+		mask, _, _ = setThreadAffinityMask.Call(uintptr(thread), 1)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mask != 1 {
+		t.Errorf("shim thread has affinity mask %#x, want 0x1", mask)
+	}
+	s.WaitDone()
+}
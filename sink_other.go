@@ -0,0 +1,11 @@
+//go:build !windows
+
+package comshim
+
+import "github.com/go-ole/go-ole"
+
+// newDispatchSink returns a new sink for the dispinterface iid that passes the
+// events it receives to fn. The caller owns the only reference.
+func newDispatchSink(s *Shim, iid *ole.GUID, fn SinkFunc) (*ole.IUnknown, error) {
+	return nil, ole.NewError(ole.E_NOTIMPL)
+}
@@ -0,0 +1,132 @@
+//go:build windows
+
+package comshim
+
+import (
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+)
+
+// dispatchSink is an IDispatch implementation that passes every call to
+// Invoke to a SinkFunc.
+type dispatchSink struct {
+	vtbl *dispatchSinkVtbl // Must come first, as COM expects
+	refs atomic.Int32
+	iid  ole.GUID
+	fn   SinkFunc
+	shim *Shim
+}
+
+// dispatchSinkVtbl is the IDispatch vtable of a dispatchSink.
+type dispatchSinkVtbl struct {
+	queryInterface   uintptr
+	addRef           uintptr
+	release          uintptr
+	getTypeInfoCount uintptr
+	getTypeInfo      uintptr
+	getIDsOfNames    uintptr
+	invoke           uintptr
+}
+
+// dispParams is DISPPARAMS, whose fields go-ole doesn't export.
+type dispParams struct {
+	args       *ole.VARIANT
+	namedArgs  *int32
+	argCount   uint32
+	namedCount uint32
+}
+
+var (
+	// The vtable is shared by every sink, as callbacks are a limited resource
+	sinkVtblOnce sync.Once
+	sinkVtbl     *dispatchSinkVtbl
+
+	// sinks keeps sinks referenced by COM alive, as COM's references are
+	// invisible to the garbage collector
+	sinksAccess sync.Mutex
+	sinks       = map[*dispatchSink]struct{}{}
+)
+
+// newDispatchSink returns a new sink for the dispinterface iid that passes the
+// events it receives to fn. The caller owns the only reference.
+func newDispatchSink(s *Shim, iid *ole.GUID, fn SinkFunc) (*ole.IUnknown, error) {
+	sinkVtblOnce.Do(func() {
+		sinkVtbl = &dispatchSinkVtbl{
+			queryInterface:   syscall.NewCallback(sinkQueryInterface),
+			addRef:           syscall.NewCallback(sinkAddRef),
+			release:          syscall.NewCallback(sinkRelease),
+			getTypeInfoCount: syscall.NewCallback(sinkGetTypeInfoCount),
+			getTypeInfo:      syscall.NewCallback(sinkNotImplemented4),
+			getIDsOfNames:    syscall.NewCallback(sinkNotImplemented6),
+			invoke:           syscall.NewCallback(sinkInvoke),
+		}
+	})
+	sink := &dispatchSink{vtbl: sinkVtbl, iid: *iid, fn: fn, shim: s}
+	sink.refs.Store(1)
+	sinksAccess.Lock()
+	sinks[sink] = struct{}{}
+	sinksAccess.Unlock()
+	return (*ole.IUnknown)(unsafe.Pointer(sink)), nil
+}
+
+func sinkQueryInterface(this *dispatchSink, iid *ole.GUID, object **dispatchSink) uintptr {
+	if ole.IsEqualGUID(iid, ole.IID_IUnknown) || ole.IsEqualGUID(iid, ole.IID_IDispatch) || ole.IsEqualGUID(iid, &this.iid) {
+		this.refs.Add(1)
+		*object = this
+		return ole.S_OK
+	}
+	*object = nil
+	return ole.E_NOINTERFACE
+}
+
+func sinkAddRef(this *dispatchSink) uintptr {
+	return uintptr(this.refs.Add(1))
+}
+
+func sinkRelease(this *dispatchSink) uintptr {
+	refs := this.refs.Add(-1)
+	if refs == 0 {
+		sinksAccess.Lock()
+		delete(sinks, this)
+		sinksAccess.Unlock()
+	}
+	return uintptr(refs)
+}
+
+func sinkGetTypeInfoCount(this *dispatchSink, count *uint32) uintptr {
+	*count = 0
+	return ole.S_OK
+}
+
+func sinkNotImplemented4(this *dispatchSink, a, b, c uintptr) uintptr {
+	return ole.E_NOTIMPL
+}
+
+func sinkNotImplemented6(this *dispatchSink, a, b, c, d, e uintptr) uintptr {
+	return ole.E_NOTIMPL
+}
+
+func sinkInvoke(this *dispatchSink, dispID uintptr, iid *ole.GUID, lcid, flags uintptr, params *dispParams, result *ole.VARIANT, excepInfo, argErr uintptr) uintptr {
+	// The arguments are stored last to first
+	var args []interface{}
+	if params != nil && params.argCount > 0 {
+		variants := unsafe.Slice(params.args, params.argCount)
+		args = make([]interface{}, len(variants))
+		for i := range variants {
+			args[len(variants)-1-i] = variants[i].Value()
+		}
+	}
+
+	// A panic must not unwind into COM
+	defer func() {
+		if r := recover(); r != nil {
+			this.shim.log().Error("comshim: event sink panicked", "panic", r)
+		}
+	}()
+	this.fn(int32(dispID), args)
+	return ole.S_OK
+}
@@ -0,0 +1,214 @@
+package comshim
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-ole/go-ole"
+)
+
+const coldStartCallers = 1000
+
+// coldStart has n goroutines call TryAdd(1) on a stopped shim at the same time
+// and returns once all of them have returned.
+func coldStart(tb testing.TB, s *Shim, n int) {
+	coldStartLocked(tb, s, n, nil)
+}
+
+// coldStartLocked is like coldStart, but each call to TryAdd is made while
+// holding serial, if it isn't nil. Holding one lock for the whole call, start
+// included, is how TryAdd used to serialize concurrent cold starts.
+func coldStartLocked(tb testing.TB, s *Shim, n int, serial sync.Locker) {
+	start := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if serial != nil {
+				serial.Lock()
+				defer serial.Unlock()
+			}
+			if err := s.TryAdd(1); err != nil {
+				tb.Error(err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+}
+
+func TestColdStartRunsOnce(t *testing.T) {
+	s := New()
+	coldStart(t, s, coldStartCallers)
+
+	if got := s.starts.Value(); got != 1 {
+		t.Errorf("run() was called %d times, want 1", got)
+	}
+	if got := s.c.Value(); got != coldStartCallers {
+		t.Errorf("counter is %d, want %d", got, coldStartCallers)
+	}
+
+	for i := 0; i < coldStartCallers; i++ {
+		s.Done()
+	}
+	s.WaitDone()
+}
+
+// slowInitializer takes a while to initialize, like CoInitializeEx during
+// process startup.
+type slowInitializer struct{}
+
+func (slowInitializer) Initialize(coinit uint32) error {
+	time.Sleep(time.Millisecond)
+	return nil
+}
+
+func (slowInitializer) Uninitialize() {}
+
+// BenchmarkColdStartAdd measures coldStartCallers concurrent calls to TryAdd on
+// a stopped shim whose start is slow. The serialized variant has every caller
+// hold one lock across TryAdd, as the callers of TryAdd used to hold
+// startAccess across the start, to compare against:
+//
+//	go test -run '^$' -bench ColdStartAdd -count 10 | benchstat -col /mode -
+func BenchmarkColdStartAdd(b *testing.B) {
+	for _, bc := range []struct {
+		name   string
+		serial bool
+	}{
+		{"mode=coalesced", false},
+		{"mode=serialized", true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				s := New(WithInitializer(slowInitializer{}))
+				var serial sync.Locker
+				if bc.serial {
+					serial = new(sync.Mutex)
+				}
+				coldStartLocked(b, s, coldStartCallers, serial)
+				for j := 0; j < coldStartCallers; j++ {
+					s.Done()
+				}
+				s.WaitDone()
+			}
+		})
+	}
+}
+
+// flakyInitializer fails every other initialization.
+type flakyInitializer struct {
+	calls atomic.Int64
+}
+
+var errFlaky = errors.New("flaky initialization")
+
+func (f *flakyInitializer) Initialize(coinit uint32) error {
+	if f.calls.Add(1)%2 == 0 {
+		return errFlaky
+	}
+	return nil
+}
+
+func (f *flakyInitializer) Uninitialize() {}
+
+func TestFailedStartsLeaveCounterUntouched(t *testing.T) {
+	f := &flakyInitializer{}
+	s := New(WithInitializer(f))
+
+	const goroutines, iterations = 4, 500
+	var failures atomic.Int64
+	wg := sync.WaitGroup{}
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				if err := s.TryAdd(1); err != nil {
+					if !errors.Is(err, errFlaky) {
+						t.Error(err)
+					}
+					failures.Add(1)
+					continue
+				}
+				if s.c.Value() <= 0 {
+					t.Error("counter isn't positive while a reference is held")
+				}
+				// Done panics if a failed start left the counter short, and
+				// the wait lets the thread stop now and then
+				s.Done()
+				s.WaitDoneTimeout(time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+	s.WaitDone()
+
+	if got := s.c.Value(); got != 0 {
+		t.Errorf("counter is %d after every reference was given back, want 0", got)
+	}
+	if failures.Load() == 0 {
+		t.Errorf("none of the %d starts failed", f.calls.Load())
+	}
+}
+
+func TestRetryStart(t *testing.T) {
+	s := New(WithInitRetry(3, time.Millisecond))
+	transient := errors.New("transient")
+
+	if s.retryStart(transient, 1) {
+		t.Error("start retried although nobody is waiting for the shim")
+	}
+
+	s.c.Add(1)
+	if !s.retryStart(transient, 1) || !s.retryStart(transient, 2) {
+		t.Error("start not retried although attempts remain")
+	}
+	if s.retryStart(transient, 3) {
+		t.Error("start retried after the last attempt")
+	}
+	if s.retryStart(&PanicError{Value: "bug"}, 1) {
+		t.Error("start retried after a panic")
+	}
+
+	plain := New()
+	plain.c.Add(1)
+	if plain.retryStart(transient, 1) {
+		t.Error("start retried without WithInitRetry")
+	}
+}
+
+func TestTaintedThread(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{ErrAlreadyInitialized, true},
+		{ole.NewError(rpcEChangedMode), true},
+		{ole.NewError(ole.E_OUTOFMEMORY), false},
+		{errors.New("other"), false},
+	} {
+		if got := taintedThread(tc.err); got != tc.want {
+			t.Errorf("taintedThread(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestHresultCode(t *testing.T) {
+	if code := hresultCode(ole.NewError(sFalse)); code != sFalse {
+		t.Errorf("hresultCode(S_FALSE) = %#x", code)
+	}
+	if code := hresultCode(fmt.Errorf("wrapped: %w", ole.NewError(rpcEChangedMode))); code != rpcEChangedMode {
+		t.Errorf("hresultCode of a wrapped RPC_E_CHANGED_MODE = %#x", code)
+	}
+	if code := hresultCode(errors.New("other")); code != 0 {
+		t.Errorf("hresultCode of a plain error = %#x, want 0", code)
+	}
+}
@@ -0,0 +1,52 @@
+package comshim
+
+import (
+	"expvar"
+	"time"
+)
+
+// Stats is a snapshot of the counters a shim keeps about its own use. It is
+// meant to be graphed by monitoring agents.
+type Stats struct {
+	Count            int64         // The current value of the counter
+	PeakCount        int64         // The highest value the counter has reached
+	TotalAdds        int64         // How many times the counter was increased
+	Starts           int64         // How many times the thread was started
+	Restarts         int64         // How many of those starts followed an earlier one
+	InitFailures     int64         // How many starts failed
+	TimeInitialized  time.Duration // How long the apartment has been up in total
+	GITCookies       int64         // How many interfaces registered in the GIT haven't been revoked
+	IdleSince        time.Time     // When the counter last dropped to zero, or the zero time while in use
+	TeardownsAvoided int64         // How many times a lingering apartment was reused instead of restarted
+}
+
+// Stats returns a snapshot of the shim's usage counters. Like Diagnostics, it
+// never waits on the shim's locks.
+func (s *Shim) Stats() Stats {
+	st := Stats{
+		Count:            s.Count(),
+		PeakCount:        s.peak.Load(),
+		TotalAdds:        s.adds.Value(),
+		Starts:           s.starts.Value(),
+		InitFailures:     s.failures.Value(),
+		GITCookies:       s.gitCount.Load(),
+		IdleSince:        s.IdleSince(),
+		TeardownsAvoided: s.avoided.Load(),
+	}
+	if st.Starts > 1 {
+		st.Restarts = st.Starts - 1
+	}
+	up := s.upTotal.Load()
+	if since := s.upSince.Load(); since != 0 {
+		up += s.now().UnixNano() - since
+	}
+	st.TimeInitialized = time.Duration(up)
+	return st
+}
+
+// PublishExpvar publishes the shim's Stats as the expvar variable name, so
+// that they are served on /debug/vars. Like expvar.Publish, it panics if name
+// is already in use.
+func (s *Shim) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() any { return s.Stats() }))
+}
@@ -0,0 +1,67 @@
+package comshim_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/NozomiNetworks/go-comshim"
+)
+
+func TestStats(t *testing.T) {
+	s := comshim.New()
+
+	s.Add(1)
+	s.Add(2)
+	s.Done()
+	s.Done()
+	s.Done()
+	s.WaitDone()
+
+	s.Add(1)
+	s.Done()
+	s.WaitDone()
+
+	st := s.Stats()
+	if st.Count != 0 {
+		t.Errorf("Count is %d, want 0", st.Count)
+	}
+	if st.PeakCount != 3 {
+		t.Errorf("PeakCount is %d, want 3", st.PeakCount)
+	}
+	if st.TotalAdds != 3 {
+		t.Errorf("TotalAdds is %d, want 3", st.TotalAdds)
+	}
+	if st.Starts != 2 || st.Restarts != 1 {
+		t.Errorf("Starts is %d and Restarts is %d, want 2 and 1", st.Starts, st.Restarts)
+	}
+	if st.InitFailures != 0 {
+		t.Errorf("InitFailures is %d, want 0", st.InitFailures)
+	}
+	if st.TimeInitialized <= 0 {
+		t.Errorf("TimeInitialized is %s, want a positive duration", st.TimeInitialized)
+	}
+	if st.IdleSince.IsZero() || !st.IdleSince.Equal(s.IdleSince()) {
+		t.Errorf("IdleSince is %v, want %v", st.IdleSince, s.IdleSince())
+	}
+}
+
+func TestPublishExpvar(t *testing.T) {
+	s := comshim.New()
+	// expvar names can't be reused, and the test may run more than once
+	name := fmt.Sprintf("comshim_test_stats_%d", time.Now().UnixNano())
+	s.PublishExpvar(name)
+	s.Add(1)
+	defer s.WaitDone()
+	defer s.Done()
+
+	var st comshim.Stats
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Count != 1 || st.Starts != 1 {
+		t.Errorf("published stats are %+v, want a count of 1 and one start", st)
+	}
+}
@@ -0,0 +1,47 @@
+package comshim
+
+import "context"
+
+// WithStopChannel ties the shim to a stop channel, for code that coordinates
+// its shutdown with channels rather than contexts. Once stop is closed, the
+// shim is closed regardless of its counter, like Close: queued functions
+// still run, and the apartment is taken down the usual way, calling the
+// teardown hooks and releasing tracked objects before COM is uninitialized.
+//
+// Closing stop abandons the references still outstanding. Their Done calls
+// are still accepted, but COM is no longer initialized on their behalf; the
+// shim records this, as reported by UnbalancedTeardown.
+//
+// The shim watches stop from a goroutine of its own until either stop or the
+// shim is closed.
+func WithStopChannel(stop <-chan struct{}) Option {
+	return func(c *config) {
+		c.stopChannel = stop
+	}
+}
+
+// watchStop closes the shim once the channel set by WithStopChannel is
+// closed, if any. It is called by New.
+func (s *Shim) watchStop() {
+	if s.settings().stopChannel == nil {
+		return
+	}
+	go func() {
+		select {
+		case <-s.settings().stopChannel:
+		case <-s.terminated:
+			return
+		}
+		if s.Count() > 0 {
+			s.unbalanced.Store(true)
+			s.log().Warn("comshim: stop channel closed with references outstanding", "count", s.Count())
+		}
+		s.Close(context.Background())
+	}()
+}
+
+// UnbalancedTeardown reports whether the channel set by WithStopChannel took
+// the shim down while references were still outstanding.
+func (s *Shim) UnbalancedTeardown() bool {
+	return s.unbalanced.Load()
+}
@@ -0,0 +1,164 @@
+package comshim
+
+import (
+	"io"
+
+	"github.com/go-ole/go-ole"
+)
+
+// StorageMode is a set of STGM flags, which control how storages and streams
+// are opened.
+type StorageMode uint32
+
+// Access, sharing and creation flags, corresponding to the STGM constants.
+const (
+	StgmRead           StorageMode = 0x00000000
+	StgmWrite          StorageMode = 0x00000001
+	StgmReadWrite      StorageMode = 0x00000002
+	StgmShareExclusive StorageMode = 0x00000010
+	StgmShareDenyWrite StorageMode = 0x00000020
+	StgmShareDenyNone  StorageMode = 0x00000040
+	StgmCreate         StorageMode = 0x00001000
+	StgmTransacted     StorageMode = 0x00010000
+)
+
+// Storage is an IStorage interface, such as the root of a compound document
+// or one of the storages nested in it, whose lifetime is tied to a reference
+// to the shim its methods run on. It is returned by OpenStorage.
+type Storage struct {
+	shim    *Shim
+	stg     *ole.IUnknown
+	release func()
+}
+
+// Stream is an IStream interface, such as a stream of a compound document or
+// a plain file, whose lifetime is tied to a reference to the shim its methods
+// run on. It is returned by OpenFileStream and Storage.OpenStream.
+type Stream struct {
+	shim    *Shim
+	stm     *ole.IUnknown
+	release func()
+}
+
+// OpenStorage opens the structured storage file at path, such as an OLE
+// compound document, with StgOpenStorageEx on the shim's thread. The shim
+// holds a reference until the storage is closed, like the objects returned by
+// CreateObject, and the storage is released as part of the teardown if the
+// shim is closed first.
+//
+// Compound documents opened for reading usually need StgmShareDenyWrite or
+// StgmShareExclusive as well, unless they are opened with StgmTransacted.
+func (s *Shim) OpenStorage(path string, mode StorageMode) (*Storage, error) {
+	return s.openStorage(func() (*ole.IUnknown, error) {
+		return stgOpenStorageEx(path, mode)
+	})
+}
+
+// OpenFileStream opens the file at path as a stream with
+// SHCreateStreamOnFileEx on the shim's thread, creating it if mode includes
+// StgmCreate. The shim holds a reference until the stream is closed, as with
+// OpenStorage.
+func (s *Shim) OpenFileStream(path string, mode StorageMode) (*Stream, error) {
+	return s.openStream(func() (*ole.IUnknown, error) {
+		return shCreateStreamOnFileEx(path, mode)
+	})
+}
+
+// openStorage calls open on the shim's thread and wraps the storage it
+// returns, holding a reference for it.
+func (s *Shim) openStorage(open func() (*ole.IUnknown, error)) (*Storage, error) {
+	stg, release, err := s.openObject(open)
+	if err != nil {
+		return nil, err
+	}
+	return &Storage{shim: s, stg: stg, release: release}, nil
+}
+
+// openStream calls open on the shim's thread and wraps the stream it returns,
+// holding a reference for it.
+func (s *Shim) openStream(open func() (*ole.IUnknown, error)) (*Stream, error) {
+	stm, release, err := s.openObject(open)
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{shim: s, stm: stm, release: release}, nil
+}
+
+// openObject calls open on the shim's thread and takes over the object it
+// returns, like CreateObject.
+func (s *Shim) openObject(open func() (*ole.IUnknown, error)) (obj *ole.IUnknown, release func(), err error) {
+	if err := s.hold(1); err != nil {
+		return nil, nil, err
+	}
+	err = s.Run(func() (err error) {
+		obj, err = open()
+		return err
+	})
+	if err != nil {
+		s.done(nil)
+		return nil, nil, err
+	}
+	return obj, s.own(obj), nil
+}
+
+// OpenStream opens the stream name nested directly in the storage. Streams
+// of compound documents must be opened with StgmShareExclusive.
+func (st *Storage) OpenStream(name string, mode StorageMode) (*Stream, error) {
+	return st.shim.openStream(func() (*ole.IUnknown, error) {
+		return storageOpenStream(st.stg, name, mode)
+	})
+}
+
+// OpenStorage opens the storage name nested directly in the storage. Nested
+// storages of compound documents must be opened with StgmShareExclusive.
+func (st *Storage) OpenStorage(name string, mode StorageMode) (*Storage, error) {
+	return st.shim.openStorage(func() (*ole.IUnknown, error) {
+		return storageOpenStorage(st.stg, name, mode)
+	})
+}
+
+// Close releases the storage on the shim's thread and gives the reference
+// back. Only the first call has any effect. Streams and storages opened from
+// it remain usable until they are closed themselves. It always returns nil,
+// and lets a Storage be used as an io.Closer.
+func (st *Storage) Close() error {
+	st.release()
+	return nil
+}
+
+// Read reads up to len(p) bytes from the stream on the shim's thread. It
+// implements io.Reader, returning io.EOF once the end of the stream has been
+// reached.
+func (sm *Stream) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	err = sm.shim.Run(func() (err error) {
+		n, err = streamRead(sm.stm, p)
+		return err
+	})
+	if err == nil && n == 0 {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Write writes p to the stream on the shim's thread. It implements io.Writer.
+func (sm *Stream) Write(p []byte) (n int, err error) {
+	err = sm.shim.Run(func() (err error) {
+		n, err = streamWrite(sm.stm, p)
+		return err
+	})
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	return n, err
+}
+
+// Close releases the stream on the shim's thread and gives the reference
+// back. Only the first call has any effect. It always returns nil, and lets a
+// Stream be used as an io.Closer.
+func (sm *Stream) Close() error {
+	sm.release()
+	return nil
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+
+package comshim
+
+import "github.com/go-ole/go-ole"
+
+func stgOpenStorageEx(path string, mode StorageMode) (*ole.IUnknown, error) {
+	return nil, ole.NewError(ole.E_NOTIMPL)
+}
+
+func shCreateStreamOnFileEx(path string, mode StorageMode) (*ole.IUnknown, error) {
+	return nil, ole.NewError(ole.E_NOTIMPL)
+}
+
+func storageOpenStream(stg *ole.IUnknown, name string, mode StorageMode) (*ole.IUnknown, error) {
+	return nil, ole.NewError(ole.E_NOTIMPL)
+}
+
+func storageOpenStorage(stg *ole.IUnknown, name string, mode StorageMode) (*ole.IUnknown, error) {
+	return nil, ole.NewError(ole.E_NOTIMPL)
+}
+
+func streamRead(stm *ole.IUnknown, p []byte) (int, error) {
+	return 0, ole.NewError(ole.E_NOTIMPL)
+}
+
+func streamWrite(stm *ole.IUnknown, p []byte) (int, error) {
+	return 0, ole.NewError(ole.E_NOTIMPL)
+}
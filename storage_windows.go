@@ -0,0 +1,159 @@
+//go:build windows
+
+package comshim
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modshlwapi = windows.NewLazySystemDLL("shlwapi.dll")
+
+	procStgOpenStorageEx       = modole32.NewProc("StgOpenStorageEx")
+	procSHCreateStreamOnFileEx = modshlwapi.NewProc("SHCreateStreamOnFileEx")
+
+	iidIStorage = ole.NewGUID("{0000000B-0000-0000-C000-000000000046}")
+)
+
+const (
+	stgfmtAny           = 4    // STGFMT_ANY
+	fileAttributeNormal = 0x80 // FILE_ATTRIBUTE_NORMAL
+)
+
+type iStorageVtbl struct {
+	ole.IUnknownVtbl
+	CreateStream  uintptr
+	OpenStream    uintptr
+	CreateStorage uintptr
+	OpenStorage   uintptr
+}
+
+type iStreamVtbl struct {
+	ole.IUnknownVtbl
+	Read  uintptr
+	Write uintptr
+}
+
+// stgOpenStorageEx opens the structured storage file at path.
+func stgOpenStorageEx(path string, mode StorageMode) (stg *ole.IUnknown, err error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	hr, _, _ := procStgOpenStorageEx.Call(
+		uintptr(unsafe.Pointer(p)),
+		uintptr(mode),
+		stgfmtAny,
+		0,
+		0,
+		0,
+		uintptr(unsafe.Pointer(iidIStorage)),
+		uintptr(unsafe.Pointer(&stg)))
+	if err := hresultError(hr); err != nil {
+		return nil, err
+	}
+	return stg, nil
+}
+
+// shCreateStreamOnFileEx opens the file at path as a stream.
+func shCreateStreamOnFileEx(path string, mode StorageMode) (stm *ole.IUnknown, err error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	var create uintptr
+	if mode&StgmCreate != 0 {
+		create = 1
+	}
+	hr, _, _ := procSHCreateStreamOnFileEx.Call(
+		uintptr(unsafe.Pointer(p)),
+		uintptr(mode),
+		fileAttributeNormal,
+		create,
+		0,
+		uintptr(unsafe.Pointer(&stm)))
+	if err := hresultError(hr); err != nil {
+		return nil, err
+	}
+	return stm, nil
+}
+
+// storageOpenStream calls IStorage::OpenStream.
+func storageOpenStream(stg *ole.IUnknown, name string, mode StorageMode) (stm *ole.IUnknown, err error) {
+	p, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	vtbl := (*iStorageVtbl)(unsafe.Pointer(stg.RawVTable))
+	hr, _, _ := syscall.SyscallN(
+		vtbl.OpenStream,
+		uintptr(unsafe.Pointer(stg)),
+		uintptr(unsafe.Pointer(p)),
+		0,
+		uintptr(mode),
+		0,
+		uintptr(unsafe.Pointer(&stm)))
+	if err := hresultError(hr); err != nil {
+		return nil, err
+	}
+	return stm, nil
+}
+
+// storageOpenStorage calls IStorage::OpenStorage.
+func storageOpenStorage(stg *ole.IUnknown, name string, mode StorageMode) (child *ole.IUnknown, err error) {
+	p, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	vtbl := (*iStorageVtbl)(unsafe.Pointer(stg.RawVTable))
+	hr, _, _ := syscall.SyscallN(
+		vtbl.OpenStorage,
+		uintptr(unsafe.Pointer(stg)),
+		uintptr(unsafe.Pointer(p)),
+		0,
+		uintptr(mode),
+		0,
+		0,
+		uintptr(unsafe.Pointer(&child)))
+	if err := hresultError(hr); err != nil {
+		return nil, err
+	}
+	return child, nil
+}
+
+// streamRead calls ISequentialStream::Read. S_FALSE only means that fewer
+// bytes than asked for were left.
+func streamRead(stm *ole.IUnknown, p []byte) (int, error) {
+	var n uint32
+	vtbl := (*iStreamVtbl)(unsafe.Pointer(stm.RawVTable))
+	hr, _, _ := syscall.SyscallN(
+		vtbl.Read,
+		uintptr(unsafe.Pointer(stm)),
+		uintptr(unsafe.Pointer(&p[0])),
+		uintptr(len(p)),
+		uintptr(unsafe.Pointer(&n)))
+	if hr == sFalse {
+		hr = 0
+	}
+	return int(n), hresultError(hr)
+}
+
+// streamWrite calls ISequentialStream::Write.
+func streamWrite(stm *ole.IUnknown, p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	var n uint32
+	vtbl := (*iStreamVtbl)(unsafe.Pointer(stm.RawVTable))
+	hr, _, _ := syscall.SyscallN(
+		vtbl.Write,
+		uintptr(unsafe.Pointer(stm)),
+		uintptr(unsafe.Pointer(&p[0])),
+		uintptr(len(p)),
+		uintptr(unsafe.Pointer(&n)))
+	return int(n), hresultError(hr)
+}
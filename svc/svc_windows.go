@@ -0,0 +1,92 @@
+//go:build windows
+
+// Package svc ties the lifetime of a comshim.Shim to a Windows service run with
+// golang.org/x/sys/windows/svc, so that COM is torn down cleanly before the
+// service control manager is told that the service has stopped.
+package svc
+
+import (
+	"context"
+	"time"
+
+	"github.com/NozomiNetworks/go-comshim"
+	winsvc "golang.org/x/sys/windows/svc"
+)
+
+// Handler returns a service handler that runs h and manages s alongside it:
+//
+//   - When a stop or shutdown request arrives, s starts draining with
+//     comshim.Shim.Drain, so that new work is rejected while the work in
+//     progress finishes, and the request is passed on to h.
+//   - Once h returns, the service keeps reporting that it is stopping until s
+//     has drained and its goroutine has exited. If that takes longer than
+//     timeout, s is closed regardless.
+//
+// The result is passed to golang.org/x/sys/windows/svc.Run in place of h.
+func Handler(s *comshim.Shim, h winsvc.Handler, timeout time.Duration) winsvc.Handler {
+	return &handler{shim: s, inner: h, timeout: timeout}
+}
+
+type handler struct {
+	shim    *comshim.Shim
+	inner   winsvc.Handler
+	timeout time.Duration
+}
+
+// Execute implements winsvc.Handler.
+func (h *handler) Execute(args []string, r <-chan winsvc.ChangeRequest, changes chan<- winsvc.Status) (bool, uint32) {
+	requests := make(chan winsvc.ChangeRequest)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	drained := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	draining := false
+	drain := func() {
+		if !draining {
+			draining = true
+			go func() { drained <- h.shim.Drain(ctx) }()
+		}
+	}
+
+	go func() {
+		defer close(stopped)
+		for {
+			var c winsvc.ChangeRequest
+			select {
+			case c = <-r:
+			case <-done:
+				return
+			}
+			if c.Cmd == winsvc.Stop || c.Cmd == winsvc.Shutdown {
+				drain()
+			}
+			select {
+			case requests <- c:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	specific, code := h.inner.Execute(args, requests, changes)
+	close(done)
+	<-stopped // drain must not be called concurrently
+	changes <- winsvc.Status{State: winsvc.StopPending, WaitHint: uint32(h.timeout / time.Millisecond)}
+	drain()
+	timer := time.NewTimer(h.timeout)
+	defer timer.Stop()
+	select {
+	case <-drained:
+	case <-timer.C:
+	}
+	cancel()
+
+	// Close returns once the goroutine has exited, or gives up after a
+	// second timeout if a COM call is stuck
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), h.timeout)
+	defer closeCancel()
+	h.shim.Close(closeCtx)
+	return specific, code
+}
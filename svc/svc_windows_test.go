@@ -0,0 +1,58 @@
+//go:build windows
+
+package svc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NozomiNetworks/go-comshim"
+	"github.com/NozomiNetworks/go-comshim/svc"
+	winsvc "golang.org/x/sys/windows/svc"
+)
+
+// stopHandler holds a reference to its shim until it is asked to stop.
+type stopHandler struct {
+	shim *comshim.Shim
+}
+
+func (h stopHandler) Execute(args []string, r <-chan winsvc.ChangeRequest, changes chan<- winsvc.Status) (bool, uint32) {
+	h.shim.Add(1)
+	defer h.shim.Done()
+	changes <- winsvc.Status{State: winsvc.Running, Accepts: winsvc.AcceptStop}
+	for c := range r {
+		if c.Cmd == winsvc.Stop {
+			return false, 0
+		}
+	}
+	return false, 0
+}
+
+func TestHandler(t *testing.T) {
+	s := comshim.New()
+	h := svc.Handler(s, stopHandler{shim: s}, 5*time.Second)
+
+	r := make(chan winsvc.ChangeRequest)
+	changes := make(chan winsvc.Status)
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		h.Execute(nil, r, changes)
+	}()
+
+	if st := <-changes; st.State != winsvc.Running {
+		t.Fatalf("service reported state %d, want running", st.State)
+	}
+	r <- winsvc.ChangeRequest{Cmd: winsvc.Stop}
+	if st := <-changes; st.State != winsvc.StopPending {
+		t.Errorf("service reported state %d, want stop pending", st.State)
+	}
+	<-exited
+
+	if s.IsRunning() {
+		t.Error("shim is still running after the service stopped")
+	}
+	if err := s.TryAdd(1); err != comshim.ErrClosed {
+		t.Errorf("TryAdd after the service stopped returned %v, want %v", err, comshim.ErrClosed)
+	}
+}
@@ -0,0 +1,201 @@
+package comshim
+
+import (
+	"context"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// task is a function waiting to be run on the shim's thread.
+type task struct {
+	fn        func() error
+	done      chan error  // Receives the result; buffered so the thread never blocks
+	abandoned atomic.Bool // Set when the caller stops waiting for the result
+	urgent    bool        // Queued ahead of the tasks that aren't
+	label     string      // Reported to the task observer
+	queued    time.Time   // When the task was queued, if there is a task observer
+}
+
+// call runs the task's function, converting a panic into a *PanicError so that
+// a misbehaving function can't take the thread down with it. COM errors are
+// described while still on the thread that ran the function.
+func (t *task) call() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return describe(t.fn())
+}
+
+// Run runs fn on the shim's COM-initialized thread and returns its result. The
+// shim holds a reference for the duration of the call, starting the thread if
+// necessary.
+//
+// This makes it possible to use objects with thread affinity, such as those
+// living in a single-threaded apartment created by NewSTA. If fn panics, the
+// panic is recovered and returned as a *PanicError. If it fails with an
+// *ole.OleError that COM can describe further, the error is returned wrapped in
+// a *TaskError.
+//
+// Functions are run one at a time in the order they were submitted, except on
+// shims holding an MTA usage cookie (see NewMTAUsage) and for functions
+// submitted with RunPriority. fn must not call Run on the same shim, as it
+// would wait for itself forever.
+func (s *Shim) Run(fn func() error) error {
+	return s.RunContext(context.Background(), fn)
+}
+
+// RunContext is like Run, but stops waiting and returns ctx.Err() if ctx is
+// done before fn has finished. If fn has not started by then it will not be
+// run at all.
+func (s *Shim) RunContext(ctx context.Context, fn func() error) error {
+	return s.runContext(ctx, &task{fn: fn})
+}
+
+// RunPriority is like Run, but fn jumps ahead of every function queued by Run
+// and the other methods that don't ask for priority, so that health checks and
+// teardown work aren't held up by a long queue of bulk queries. Functions
+// submitted with RunPriority still run in the order they were submitted among
+// themselves, and a function that is already running is never interrupted.
+func (s *Shim) RunPriority(fn func() error) error {
+	return s.RunPriorityContext(context.Background(), fn)
+}
+
+// RunPriorityContext is like RunPriority, but stops waiting and returns
+// ctx.Err() if ctx is done before fn has finished, like RunContext.
+func (s *Shim) RunPriorityContext(ctx context.Context, fn func() error) error {
+	return s.runContext(ctx, &task{fn: fn, urgent: true})
+}
+
+// runContext implements RunContext, RunPriorityContext and DoLabeled.
+func (s *Shim) runContext(ctx context.Context, t *task) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.settings().taskTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = s.withTimeout(ctx, s.settings().taskTimeout)
+		defer cancel()
+	}
+	if err := s.holdTask(ctx); err != nil {
+		return timeoutErr(ctx, err)
+	}
+	defer s.done(nil)
+
+	t.done = make(chan error, 1)
+	if s.usingMTAUsage() {
+		// There is no dedicated thread; this one is in the implicit MTA
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		started := s.now()
+		err := t.call()
+		s.observe(t, started, err)
+		return err
+	}
+	if err := s.enqueue(t); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-t.done:
+		return err
+	case <-ctx.Done():
+		t.abandoned.Store(true)
+		return timeoutErr(ctx, ctx.Err())
+	}
+}
+
+// enqueue queues t for the shim's thread. The caller must hold a reference.
+func (s *Shim) enqueue(t *task) error {
+	if s.settings().shared != nil {
+		return s.settings().shared.enqueue(t) // Our reference keeps the shared thread
+	}
+	s.signalAccess.Lock()
+	defer s.signalAccess.Unlock()
+	if !s.running.Load() && !s.bouncing.Load() {
+		// Our reference didn't keep the thread alive, because the shim was
+		// closed or its goroutine failed
+		if s.closed.Load() {
+			return ErrClosed
+		}
+		return ErrStopped
+	}
+	if !t.urgent && s.settings().queueLimit > 0 && len(s.tasks) >= s.settings().queueLimit {
+		return ErrQueueFull
+	}
+	s.queue(t)
+	s.signal()
+	return nil
+}
+
+// queue adds t to the task queue: urgent tasks after the urgent ones already
+// queued, and any other task at the end. It must be called while holding
+// signalAccess.
+func (s *Shim) queue(t *task) {
+	if s.settings().taskObserver != nil {
+		t.queued = s.now()
+	}
+	if !t.urgent {
+		s.tasks = append(s.tasks, t)
+		return
+	}
+	i := 0
+	for i < len(s.tasks) && s.tasks[i].urgent {
+		i++
+	}
+	s.tasks = append(s.tasks, nil)
+	copy(s.tasks[i+1:], s.tasks[i:])
+	s.tasks[i] = t
+}
+
+// runTask removes the next task from the queue and runs it. It must be called
+// by the shim's goroutine while holding signalAccess, which is released while
+// the task runs.
+func (s *Shim) runTask(p *progress) {
+	t := s.tasks[0]
+	s.tasks[0] = nil
+	s.tasks = s.tasks[1:]
+	if t.abandoned.Load() {
+		return
+	}
+
+	if s.misrouted() {
+		t.done <- ErrWrongThread
+		return
+	}
+
+	s.busy = t
+	s.busySince.Store(s.now().UnixNano())
+	s.signalAccess.Unlock()
+	p.locked = false
+	if s.etwHandle == 0 && s.settings().taskObserver == nil {
+		t.done <- t.call()
+	} else {
+		started := s.now()
+		err := t.call()
+		if s.etwHandle != 0 {
+			s.traceETW(etwLevelVerbose, "task ran in %v", s.now().Sub(started))
+		}
+		t.done <- err
+		s.observe(t, started, err)
+	}
+	s.signalAccess.Lock()
+	p.locked = true
+	if s.busy == t {
+		s.busy = nil
+		s.busySince.Store(0)
+	}
+}
+
+// abandonTasks fails every queued task with ErrStopped. It must be called by
+// the shim's goroutine while holding signalAccess, once it has decided to
+// release the thread.
+func (s *Shim) abandonTasks() {
+	for _, t := range s.tasks {
+		t.done <- ErrStopped
+	}
+	s.tasks = nil
+}
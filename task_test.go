@@ -0,0 +1,560 @@
+package comshim_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NozomiNetworks/go-comshim"
+	"github.com/NozomiNetworks/go-comshim/comshimtest"
+	"github.com/go-ole/go-ole"
+)
+
+func TestRunReturnsResult(t *testing.T) {
+	s := comshim.New()
+	defer s.WaitDone()
+
+	errTask := errors.New("task failed")
+	if err := s.Run(func() error { return errTask }); err != errTask {
+		t.Errorf("Run returned %v, want %v", err, errTask)
+	}
+	if err := s.Run(func() error { return nil }); err != nil {
+		t.Errorf("Run returned %v, want nil", err)
+	}
+}
+
+func TestRunRecoversPanic(t *testing.T) {
+	s := comshim.New()
+	defer s.WaitDone()
+
+	s.Add(1)
+	defer s.Done()
+
+	err := s.Run(func() error { panic("injected panic") })
+	var perr *comshim.PanicError
+	if !errors.As(err, &perr) {
+		t.Fatalf("Run returned %v, want a *PanicError", err)
+	}
+	if !s.IsRunning() {
+		t.Error("a panicking function stopped the shim")
+	}
+}
+
+func TestRunContextCanceled(t *testing.T) {
+	s := comshim.New()
+	defer s.WaitDone()
+
+	s.Add(1)
+	defer s.Done()
+
+	// Occupy the thread so that the next function has to wait
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go s.Run(func() error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := false
+	errc := make(chan error)
+	go func() {
+		errc <- s.RunContext(ctx, func() error {
+			ran = true
+			return nil
+		})
+	}()
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("RunContext returned %v, want %v", err, context.Canceled)
+	}
+
+	close(release)
+	if err := s.Run(func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if ran {
+		t.Error("a function was run after its context was canceled")
+	}
+}
+
+func TestRunAll(t *testing.T) {
+	s := comshim.New()
+	defer s.WaitDone()
+
+	errFn := errors.New("fn failed")
+	var ran []int
+	errs := s.RunAll(context.Background(), []func() error{
+		func() error { ran = append(ran, 0); return nil },
+		func() error { ran = append(ran, 1); return errFn },
+		func() error { panic("injected panic") },
+		func() error { ran = append(ran, 3); return nil },
+	})
+	if len(ran) != 3 || ran[0] != 0 || ran[1] != 1 || ran[2] != 3 {
+		t.Errorf("functions ran in the order %v, want [0 1 3]", ran)
+	}
+	var perr *comshim.PanicError
+	if errs[0] != nil || errs[1] != errFn || !errors.As(errs[2], &perr) || errs[3] != nil {
+		t.Errorf("RunAll returned %v", errs)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i, err := range s.RunAll(ctx, make([]func() error, 2)) {
+		if err != context.Canceled {
+			t.Errorf("function %d of a canceled batch returned %v, want %v", i, err, context.Canceled)
+		}
+	}
+}
+
+func TestRunResult(t *testing.T) {
+	s := comshim.New()
+	defer s.WaitDone()
+
+	n, err := comshim.RunResult(s, func() (int, error) { return 42, nil })
+	if n != 42 || err != nil {
+		t.Errorf("RunResult returned %d, %v, want 42, nil", n, err)
+	}
+
+	errFn := errors.New("fn failed")
+	n, err = comshim.RunResult(s, func() (int, error) { return 42, errFn })
+	if n != 0 || err != errFn {
+		t.Errorf("RunResult returned %d, %v, want 0, %v", n, err, errFn)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n, err = comshim.RunResultContext(ctx, s, func() (int, error) { return 42, nil })
+	if n != 0 || err != context.Canceled {
+		t.Errorf("RunResultContext with a canceled context returned %d, %v, want 0, %v", n, err, context.Canceled)
+	}
+}
+
+func TestGo(t *testing.T) {
+	s := comshim.New()
+	defer s.WaitDone()
+
+	s.Add(1)
+	defer s.Done()
+
+	// Occupy the thread so that the futures have to wait
+	release := make(chan struct{})
+	blocked := s.Go(func() (interface{}, error) {
+		<-release
+		return nil, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := false
+	canceled := s.GoContext(ctx, func() (interface{}, error) {
+		ran = true
+		return nil, nil
+	})
+	futures := make([]*comshim.Future, 3)
+	for i := range futures {
+		i := i
+		futures[i] = s.Go(func() (interface{}, error) { return i, nil })
+	}
+	cancel()
+	if _, err := canceled.Wait(context.Background()); err != context.Canceled {
+		t.Errorf("Wait on a canceled future returned %v, want %v", err, context.Canceled)
+	}
+
+	close(release)
+	if _, err := blocked.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range futures {
+		if v, err := f.Wait(context.Background()); v != i || err != nil {
+			t.Errorf("future %d returned %v, %v, want %d, nil", i, v, err, i)
+		}
+	}
+	if ran {
+		t.Error("a function was run after its context was canceled")
+	}
+}
+
+func TestOnceOnThread(t *testing.T) {
+	s := comshim.New()
+	defer s.WaitDone()
+
+	errFn := errors.New("fn failed")
+	calls := 0
+	once := s.OnceOnThread(func() error {
+		calls++
+		return errFn
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := once(); err != errFn {
+				t.Errorf("once returned %v, want %v", err, errFn)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("fn was called %d times, want 1", calls)
+	}
+
+	s.Close(context.Background())
+	if err := once(); err != errFn {
+		t.Errorf("once on a closed shim returned %v, want the remembered %v", err, errFn)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	s := comshim.New()
+	defer s.WaitDone()
+	s.Add(1)
+	defer s.Done()
+
+	release := make(chan struct{})
+	defer close(release)
+	if err := s.RunTimeout(10*time.Millisecond, func() error {
+		<-release
+		return nil
+	}); err != context.DeadlineExceeded {
+		t.Fatalf("RunTimeout of a stuck function returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The thread is still stuck, so this needs a replacement
+	if err := s.RunTimeout(5*time.Second, func() error { return nil }); err != nil {
+		t.Errorf("RunTimeout after abandoning a thread returned %v", err)
+	}
+	if starts := s.Stats().Starts; starts != 2 {
+		t.Errorf("shim started %d times, want 2", starts)
+	}
+}
+
+func TestRunPriority(t *testing.T) {
+	s := comshim.New()
+	defer s.WaitDone()
+	s.Add(1)
+	defer s.Done()
+
+	// Hold the thread until everything has been queued
+	unblock := blockThread(t, s)
+
+	var m sync.Mutex
+	var order []string
+	record := func(name string) func() error {
+		return func() error {
+			m.Lock()
+			order = append(order, name)
+			m.Unlock()
+			return nil
+		}
+	}
+	var wg sync.WaitGroup
+	submit := func(name string, run func(func() error) error, queued int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run(record(name))
+		}()
+		want := fmt.Sprintf("queued tasks: %d\n", queued)
+		for !strings.Contains(s.Diagnostics(), want) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	submit("bulk 1", s.Run, 1)
+	submit("bulk 2", s.Run, 2)
+	submit("urgent 1", s.RunPriority, 3)
+	submit("urgent 2", s.RunPriority, 4)
+	unblock()
+	wg.Wait()
+
+	want := []string{"urgent 1", "urgent 2", "bulk 1", "bulk 2"}
+	if fmt.Sprint(order) != fmt.Sprint(want) {
+		t.Errorf("functions ran in the order %q, want %q", order, want)
+	}
+}
+
+// blockThread holds the shim's thread with a function that runs until the
+// returned function is called.
+func blockThread(t *testing.T, s *comshim.Shim) (unblock func()) {
+	t.Helper()
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go s.Run(func() error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+	return func() { close(release) }
+}
+
+func TestWithQueueLimit(t *testing.T) {
+	s := comshim.New(comshim.WithQueueLimit(1))
+	defer s.WaitDone()
+	unblock := blockThread(t, s)
+
+	queued := make(chan error, 1)
+	go func() { queued <- s.Run(func() error { return nil }) }()
+	for !strings.Contains(s.Diagnostics(), "queued tasks: 1\n") {
+		time.Sleep(time.Millisecond)
+	}
+	if err := s.Run(func() error { return nil }); !errors.Is(err, comshim.ErrQueueFull) {
+		t.Errorf("Run with a full queue returned %v, want %v", err, comshim.ErrQueueFull)
+	}
+	urgent := make(chan error, 1)
+	go func() { urgent <- s.RunPriority(func() error { return nil }) }()
+
+	unblock()
+	if err := <-queued; err != nil {
+		t.Errorf("Run of the queued function returned %v", err)
+	}
+	if err := <-urgent; err != nil {
+		t.Errorf("RunPriority with a full queue returned %v", err)
+	}
+}
+
+func TestWithTaskTimeout(t *testing.T) {
+	s := comshim.New(comshim.WithTaskTimeout(20 * time.Millisecond))
+	defer s.WaitDone()
+	unblock := blockThread(t, s)
+
+	ran := false
+	if err := s.Run(func() error {
+		ran = true
+		return nil
+	}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Run behind a stuck function returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	unblock()
+	if err := s.Run(func() error { return nil }); err != nil {
+		t.Errorf("Run on a free thread returned %v", err)
+	}
+	if ran {
+		t.Error("function that timed out in the queue was run")
+	}
+}
+
+func TestQuiesce(t *testing.T) {
+	s := comshim.New()
+	defer s.WaitDone()
+	if err := s.Quiesce(context.Background()); err != nil {
+		t.Errorf("Quiesce on a stopped shim returned %v", err)
+	}
+	s.Add(1)
+	defer s.Done()
+
+	unblock := blockThread(t, s)
+	var finished sync.WaitGroup
+	var ran atomic.Int32
+	for i := 0; i < 3; i++ {
+		finished.Add(1)
+		go func() {
+			defer finished.Done()
+			s.Run(func() error {
+				ran.Add(1)
+				return nil
+			})
+		}()
+	}
+	for !strings.Contains(s.Diagnostics(), "queued tasks: 3\n") {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Quiesce(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Quiesce with a function running returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	unblock()
+	if err := s.Quiesce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := ran.Load(); n != 3 {
+		t.Errorf("%d of the 3 queued functions had run when Quiesce returned", n)
+	}
+	if !s.IsRunning() {
+		t.Error("Quiesce released the thread")
+	}
+	finished.Wait()
+}
+
+func TestRunDescribesOleErrors(t *testing.T) {
+	s := comshim.New()
+	defer s.WaitDone()
+
+	err := s.Run(func() error {
+		return ole.NewErrorWithDescription(ole.E_FAIL, "Invalid query")
+	})
+	var terr *comshim.TaskError
+	if !errors.As(err, &terr) {
+		t.Fatalf("Run returned %v, want a *TaskError", err)
+	}
+	if terr.HRESULT != ole.E_FAIL || terr.Description != "Invalid query" {
+		t.Errorf("Run returned %+v, want E_FAIL described as Invalid query", terr)
+	}
+	var oerr *ole.OleError
+	if !errors.As(err, &oerr) {
+		t.Errorf("*TaskError %v doesn't wrap the *ole.OleError", err)
+	}
+}
+
+func TestWithTaskObserver(t *testing.T) {
+	c := comshimtest.NewFakeClock()
+	observed := make(chan comshim.TaskInfo, 2)
+	s := comshim.New(comshimtest.WithClock(c), comshim.WithTaskObserver(func(info comshim.TaskInfo) { observed <- info }))
+	defer s.WaitDone()
+	s.Add(1)
+	defer s.Done()
+
+	// The labeled function is queued behind one that keeps the thread busy
+	started := make(chan struct{})
+	release := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.Run(func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+	go func() {
+		for !strings.Contains(s.Diagnostics(), "queued tasks: 1\n") {
+			time.Sleep(time.Millisecond)
+		}
+		c.Advance(20 * time.Second)
+		close(release)
+	}()
+	err := s.DoLabeled("query", func() { c.Advance(10 * time.Second) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, label := range []string{"", "query"} {
+		select {
+		case info := <-observed:
+			if info.Label != label {
+				t.Errorf("observed a function labeled %q, want %q", info.Label, label)
+			}
+			if label == "query" && (info.QueueWait != 20*time.Second || info.Exec != 10*time.Second || info.Err != nil) {
+				t.Errorf("observed %+v for the labeled function", info)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the function labeled %q to be observed", label)
+		}
+	}
+}
+
+func TestWithExplicitStart(t *testing.T) {
+	init := comshimtest.NewInitializer()
+	s := comshim.New(comshim.WithExplicitStart(), comshim.WithInitializer(init))
+	defer s.WaitDone()
+	ran := func() error { return nil }
+
+	// Not started: functions are rejected rather than starting the thread
+	if err := s.Run(ran); err != comshim.ErrShimNotReady {
+		t.Errorf("Run on a shim that isn't started returned %v, want %v", err, comshim.ErrShimNotReady)
+	}
+	if _, err := s.Go(func() (interface{}, error) { return nil, nil }).Wait(context.Background()); err != comshim.ErrShimNotReady {
+		t.Errorf("Go on a shim that isn't started returned %v, want %v", err, comshim.ErrShimNotReady)
+	}
+	if err := s.RunTimeout(time.Minute, ran); err != comshim.ErrShimNotReady {
+		t.Errorf("RunTimeout on a shim that isn't started returned %v, want %v", err, comshim.ErrShimNotReady)
+	}
+	if starts := s.Stats().Starts; starts != 0 {
+		t.Fatalf("rejected functions started the shim %d times", starts)
+	}
+
+	// Starting: functions wait for the start in progress
+	init.SetDelay(100 * time.Millisecond)
+	added := make(chan error, 1)
+	go func() { added <- s.TryAdd(1) }()
+	for s.DebugReport().State != "starting" {
+		time.Sleep(time.Millisecond)
+	}
+	if err := s.Run(ran); err != nil {
+		t.Errorf("Run on a starting shim returned %v", err)
+	}
+	if err := <-added; err != nil {
+		t.Fatal(err)
+	}
+
+	// Ready: functions run right away
+	if err := s.Run(ran); err != nil {
+		t.Errorf("Run on a running shim returned %v", err)
+	}
+	if err := s.RunTimeout(time.Minute, ran); err != nil {
+		t.Errorf("RunTimeout on a running shim returned %v", err)
+	}
+	s.Done()
+	s.WaitDone()
+	if err := s.Run(ran); err != comshim.ErrShimNotReady {
+		t.Errorf("Run once the shim stopped again returned %v, want %v", err, comshim.ErrShimNotReady)
+	}
+	if starts := s.Stats().Starts; starts != 1 {
+		t.Errorf("shim started %d times, want 1", starts)
+	}
+}
+
+func TestOnceOnThreadTaskTimeout(t *testing.T) {
+	c := comshimtest.NewFakeClock()
+	s := comshim.New(comshimtest.WithClock(c), comshim.WithTaskTimeout(time.Minute))
+	defer s.WaitDone()
+
+	errFn := errors.New("fn failed")
+	var calls atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	once := s.OnceOnThread(func() error {
+		calls.Add(1)
+		close(started)
+		<-release
+		return errFn
+	})
+
+	// Run gives up on fn while it is running, which must not count as fn not
+	// having run
+	const callers = 10
+	timedOut := make(chan struct{}, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			switch err := once(); err {
+			case context.DeadlineExceeded:
+				timedOut <- struct{}{}
+			case errFn:
+			default:
+				t.Errorf("once returned %v, want %v or %v", err, errFn, context.DeadlineExceeded)
+			}
+		}()
+	}
+	<-started
+	c.WaitTimers(1) // The deadline of the call running fn
+	c.Advance(time.Minute)
+	<-timedOut
+	close(release)
+	wg.Wait()
+	if n := len(timedOut); n != 0 {
+		t.Errorf("%d more calls timed out, want 1 in total", n+1)
+	}
+	if err := once(); err != errFn {
+		t.Errorf("once returned %v after fn finished, want %v", err, errFn)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("fn was called %d times, want 1", n)
+	}
+}
@@ -0,0 +1,78 @@
+package comshim
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-ole/go-ole"
+)
+
+// TaskError is returned by Run and the other methods that run functions on the
+// shim's thread when the function fails with an *ole.OleError, and COM has
+// more to say about the failure than its HRESULT. WMI providers and
+// automation servers describe their errors through IErrorInfo or EXCEPINFO,
+// which can only be retrieved on the thread that made the failing call, so
+// the shim does so before the function's result leaves the thread.
+//
+// TaskError wraps the original error, so errors.As still finds the
+// *ole.OleError.
+type TaskError struct {
+	Err         error  // The error returned by the function
+	HRESULT     uint32 // The HRESULT of the *ole.OleError in Err
+	Source      string // The ProgID of the component that raised the error, if known
+	Description string // The description given by the component
+	HelpFile    string // The path of a help file describing the error, if any
+	HelpContext uint32 // The topic in HelpFile, if any
+}
+
+// Error implements the error interface.
+func (e *TaskError) Error() string {
+	switch {
+	case e.Source != "" && e.Description != "":
+		return fmt.Sprintf("%v: %s: %s", e.Err, e.Source, e.Description)
+	case e.Description != "":
+		return fmt.Sprintf("%v: %s", e.Err, e.Description)
+	default:
+		return fmt.Sprintf("%v: %s", e.Err, e.Source)
+	}
+}
+
+// Unwrap returns the error returned by the function.
+func (e *TaskError) Unwrap() error {
+	return e.Err
+}
+
+// describe wraps err in a *TaskError if it is an *ole.OleError that the error
+// information of the calling thread, or the EXCEPINFO that go-ole collected
+// from IDispatch::Invoke, has something to add to. It must be called on the
+// thread that ran the failing call, right after it returned. Any other error
+// is returned as is.
+func describe(err error) error {
+	var oerr *ole.OleError
+	var terr *TaskError
+	if !errors.As(err, &oerr) || errors.As(err, &terr) {
+		return err
+	}
+	e := &TaskError{Err: err, HRESULT: uint32(oerr.Code())}
+	if info, ok := getErrorInfo(); ok {
+		e.Source = info.source
+		e.Description = info.description
+		e.HelpFile = info.helpFile
+		e.HelpContext = info.helpContext
+	}
+	if e.Description == "" {
+		e.Description = oerr.Description()
+	}
+	if e.Source == "" && e.Description == "" {
+		return err
+	}
+	return e
+}
+
+// errorInfo is what an IErrorInfo interface reports.
+type errorInfo struct {
+	source      string
+	description string
+	helpFile    string
+	helpContext uint32
+}
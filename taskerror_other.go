@@ -0,0 +1,8 @@
+//go:build !windows
+
+package comshim
+
+// getErrorInfo takes the error information of the calling thread, if any.
+func getErrorInfo() (info errorInfo, ok bool) {
+	return errorInfo{}, false
+}
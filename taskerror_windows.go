@@ -0,0 +1,51 @@
+//go:build windows
+
+package comshim
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+)
+
+var procGetErrorInfo = modoleaut32.NewProc("GetErrorInfo")
+
+type iErrorInfoVtbl struct {
+	ole.IUnknownVtbl
+	GetGUID        uintptr
+	GetSource      uintptr
+	GetDescription uintptr
+	GetHelpFile    uintptr
+	GetHelpContext uintptr
+}
+
+// getErrorInfo takes the error information of the calling thread, if any, with
+// GetErrorInfo, which also clears it.
+func getErrorInfo() (info errorInfo, ok bool) {
+	var unknown *ole.IUnknown
+	hr, _, _ := procGetErrorInfo.Call(0, uintptr(unsafe.Pointer(&unknown)))
+	if hr != 0 || unknown == nil {
+		return errorInfo{}, false // S_FALSE means there is none
+	}
+	defer unknown.Release()
+
+	vtbl := (*iErrorInfoVtbl)(unsafe.Pointer(unknown.RawVTable))
+	info.source = errorInfoString(unknown, vtbl.GetSource)
+	info.description = errorInfoString(unknown, vtbl.GetDescription)
+	info.helpFile = errorInfoString(unknown, vtbl.GetHelpFile)
+	syscall.SyscallN(vtbl.GetHelpContext, uintptr(unsafe.Pointer(unknown)), uintptr(unsafe.Pointer(&info.helpContext)))
+	return info, true
+}
+
+// errorInfoString calls the IErrorInfo method that returns a BSTR at
+// address method, and converts the result.
+func errorInfoString(unknown *ole.IUnknown, method uintptr) string {
+	var bstr *uint16
+	hr, _, _ := syscall.SyscallN(method, uintptr(unsafe.Pointer(unknown)), uintptr(unsafe.Pointer(&bstr)))
+	if hr != 0 || bstr == nil {
+		return ""
+	}
+	defer ole.SysFreeString((*int16)(unsafe.Pointer(bstr)))
+	return ole.BstrToString(bstr)
+}
@@ -0,0 +1,54 @@
+package comshim
+
+import "runtime"
+
+// TeardownPolicy decides what happens to an Add that arrives while the shim's
+// thread is about to be released, after the counter has dropped to zero.
+type TeardownPolicy int
+
+const (
+	// TeardownRestart makes an Add that arrives once the thread has decided
+	// to stop wait until COM has been uninitialized and the thread released,
+	// and then start a fresh thread. The old apartment is never used again
+	// once its teardown has begun. This is the default.
+	TeardownRestart TeardownPolicy = iota
+
+	// TeardownReuse makes the thread take one last look at the counter before
+	// it starts tearing down, giving callers already blocked in Add a chance
+	// to get in. If any did, the teardown is cancelled and the thread is
+	// reused. An Add that arrives after the last look is handled as with
+	// TeardownRestart.
+	TeardownReuse
+)
+
+// WithTeardownPolicy sets what happens to an Add that races with the release
+// of the shim's thread. The teardown itself, from the teardown hooks to
+// CoUninitialize, always runs while holding the shim's lock, so a racing Add
+// never sees a half-released thread. WithIdleTimeout gives a wider window in
+// which the thread is reused.
+func WithTeardownPolicy(p TeardownPolicy) Option {
+	return func(c *config) {
+		c.teardownPolicy = p
+	}
+}
+
+// reprieve gives callers blocked on signalAccess a chance to add to the
+// counter before the shim's goroutine commits to tearing down, if the shim's
+// policy is TeardownReuse. It reports whether one did, in which case the
+// thread carries on. It must be called by the shim's goroutine while holding
+// signalAccess, which it temporarily releases.
+func (s *Shim) reprieve(p *progress) bool {
+	if s.settings().teardownPolicy != TeardownReuse || s.closed.Load() || s.bouncing.Load() || p.detached.Load() {
+		return false
+	}
+	s.signalAccess.Unlock()
+	p.locked = false
+	runtime.Gosched()
+	s.signalAccess.Lock()
+	p.locked = true
+	if !s.held() || s.closed.Load() {
+		return false
+	}
+	s.log().Debug("comshim: counter rose from zero during teardown, reusing thread")
+	return true
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package comshim
+
+// currentThreadID returns the identifier of the calling OS thread. Thread
+// identifiers are only tracked on Windows, so it always returns zero.
+func currentThreadID() uint32 {
+	return 0
+}
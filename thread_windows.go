@@ -0,0 +1,10 @@
+//go:build windows
+
+package comshim
+
+import "golang.org/x/sys/windows"
+
+// currentThreadID returns the identifier of the calling OS thread.
+func currentThreadID() uint32 {
+	return windows.GetCurrentThreadId()
+}
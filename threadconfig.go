@@ -0,0 +1,83 @@
+package comshim
+
+// ThreadPriority is the scheduling priority of a thread, as passed to
+// SetThreadPriority.
+type ThreadPriority int32
+
+// Thread priorities, corresponding to the THREAD_PRIORITY constants.
+const (
+	ThreadPriorityIdle         ThreadPriority = -15
+	ThreadPriorityLowest       ThreadPriority = -2
+	ThreadPriorityBelowNormal  ThreadPriority = -1
+	ThreadPriorityNormal       ThreadPriority = 0
+	ThreadPriorityAboveNormal  ThreadPriority = 1
+	ThreadPriorityHighest      ThreadPriority = 2
+	ThreadPriorityTimeCritical ThreadPriority = 15
+)
+
+// WithThreadName names the shim's thread with SetThreadDescription, so that it
+// can be identified in debuggers and ETW traces. The name is cleared again
+// before the thread is handed back to the Go runtime. Naming threads requires
+// Windows 10 version 1607 or later; on older systems the name is ignored.
+func WithThreadName(name string) Option {
+	return func(c *config) {
+		c.threadName = name
+	}
+}
+
+// WithThreadPriority sets the scheduling priority of the shim's thread, for
+// instance to keep COM work from competing with more important work on a
+// constrained machine. The previous priority is restored before the thread is
+// handed back to the Go runtime.
+func WithThreadPriority(p ThreadPriority) Option {
+	return func(c *config) {
+		c.threadPriority = &p
+	}
+}
+
+// WithThreadAffinity confines the shim's thread to the processors whose bits
+// are set in mask, with SetThreadAffinityMask, so that operators partitioning
+// the cores of a machine can keep COM work on the ones they set aside for it.
+// The processors are those of the thread's processor group. The previous
+// affinity is restored before the thread is handed back to the Go runtime.
+// On other platforms the option has no effect.
+func WithThreadAffinity(mask uintptr) Option {
+	return func(c *config) {
+		c.threadAffinity = mask
+	}
+}
+
+// configureThread applies the thread name, priority and affinity of the shim,
+// if any, to the calling thread. It returns a function that undoes them. Failures are
+// logged, as they don't prevent the thread from being used.
+func (s *Shim) configureThread() (restore func()) {
+	var undo []func()
+	if s.settings().threadName != "" && threadNamesSupported() {
+		if err := setThreadName(s.settings().threadName); err != nil {
+			s.log().Warn("comshim: naming the thread failed", "error", err)
+		} else {
+			undo = append(undo, func() { setThreadName("") })
+		}
+	}
+	if s.settings().threadPriority != nil {
+		previous, err := setThreadPriority(*s.settings().threadPriority)
+		if err != nil {
+			s.log().Warn("comshim: setting the thread priority failed", "error", err)
+		} else {
+			undo = append(undo, func() { setThreadPriority(previous) })
+		}
+	}
+	if s.settings().threadAffinity != 0 {
+		previous, err := setThreadAffinity(s.settings().threadAffinity)
+		if err != nil {
+			s.log().Warn("comshim: setting the thread affinity failed", "error", err)
+		} else {
+			undo = append(undo, func() { setThreadAffinity(previous) })
+		}
+	}
+	return func() {
+		for _, fn := range undo {
+			fn()
+		}
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package comshim
+
+// threadNamesSupported reports whether the operating system provides
+// SetThreadDescription.
+func threadNamesSupported() bool {
+	return false
+}
+
+// setThreadName sets the description of the calling thread.
+func setThreadName(name string) error {
+	return nil
+}
+
+// setThreadPriority sets the priority of the calling thread and returns the
+// priority it had before.
+func setThreadPriority(p ThreadPriority) (previous ThreadPriority, err error) {
+	return ThreadPriorityNormal, nil
+}
+
+// setThreadAffinity sets the affinity mask of the calling thread and returns
+// the mask it had before.
+func setThreadAffinity(mask uintptr) (previous uintptr, err error) {
+	return mask, nil
+}
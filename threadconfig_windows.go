@@ -0,0 +1,65 @@
+//go:build windows
+
+package comshim
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procSetThreadDescription = modkernel32.NewProc("SetThreadDescription")
+	procGetThreadPriority    = modkernel32.NewProc("GetThreadPriority")
+	procSetThreadPriority    = modkernel32.NewProc("SetThreadPriority")
+	procSetThreadAffinity    = modkernel32.NewProc("SetThreadAffinityMask")
+)
+
+// threadPriorityErrorReturn is returned by GetThreadPriority when it fails.
+const threadPriorityErrorReturn = 0x7FFFFFFF
+
+// threadNamesSupported reports whether the operating system provides
+// SetThreadDescription.
+func threadNamesSupported() bool {
+	return procSetThreadDescription.Find() == nil
+}
+
+// setThreadName sets the description of the calling thread.
+func setThreadName(name string) error {
+	if err := procSetThreadDescription.Find(); err != nil {
+		return err
+	}
+	description, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	thread, _ := windows.GetCurrentThread()
+	hr, _, _ := procSetThreadDescription.Call(uintptr(thread), uintptr(unsafe.Pointer(description)))
+	return hresultError(hr)
+}
+
+// setThreadPriority sets the priority of the calling thread and returns the
+// priority it had before.
+func setThreadPriority(p ThreadPriority) (previous ThreadPriority, err error) {
+	thread, _ := windows.GetCurrentThread()
+	r, _, err := procGetThreadPriority.Call(uintptr(thread))
+	if int32(r) == threadPriorityErrorReturn {
+		return 0, err
+	}
+	previous = ThreadPriority(int32(r))
+	if ok, _, err := procSetThreadPriority.Call(uintptr(thread), uintptr(p)); ok == 0 {
+		return 0, err
+	}
+	return previous, nil
+}
+
+// setThreadAffinity sets the affinity mask of the calling thread and returns
+// the mask it had before.
+func setThreadAffinity(mask uintptr) (previous uintptr, err error) {
+	thread, _ := windows.GetCurrentThread()
+	previous, _, err = procSetThreadAffinity.Call(uintptr(thread), mask)
+	if previous == 0 {
+		return 0, err
+	}
+	return previous, nil
+}
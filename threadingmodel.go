@@ -0,0 +1,78 @@
+package comshim
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-ole/go-ole"
+)
+
+// ThreadingModel is the apartment a class registers for its in-process
+// objects, as recorded by the ThreadingModel value of its InprocServer32 key.
+type ThreadingModel int
+
+// The threading models a class can register.
+const (
+	ThreadingModelSingle    ThreadingModel = iota // No value: objects live in the main STA
+	ThreadingModelApartment                       // "Apartment": objects live in an STA
+	ThreadingModelFree                            // "Free": objects live in the MTA
+	ThreadingModelBoth                            // "Both": objects live in the creating apartment
+	ThreadingModelNeutral                         // "Neutral": objects live in the neutral apartment
+)
+
+// String returns the registry spelling of the threading model.
+func (m ThreadingModel) String() string {
+	switch m {
+	case ThreadingModelSingle:
+		return "Single"
+	case ThreadingModelApartment:
+		return "Apartment"
+	case ThreadingModelFree:
+		return "Free"
+	case ThreadingModelBoth:
+		return "Both"
+	case ThreadingModelNeutral:
+		return "Neutral"
+	default:
+		return fmt.Sprintf("ThreadingModel(%d)", int(m))
+	}
+}
+
+// Apartment returns the apartment an ApartmentManager runs functions using
+// objects of the threading model in. Classes requiring an STA go to one of the
+// manager's single-threaded apartments, even those that really want the main
+// STA, which the manager doesn't have; every other class goes to the MTA.
+func (m ThreadingModel) Apartment() ApartmentType {
+	switch m {
+	case ThreadingModelSingle, ThreadingModelApartment:
+		return ApartmentSTA
+	default:
+		return ApartmentMTA
+	}
+}
+
+// ThreadingModelOf reads the threading model that the class clsid registers
+// under HKEY_CLASSES_ROOT\CLSID. Guessing the apartment of a component wrong
+// is a common source of E_NOINTERFACE errors and deadlocks, so this is worth
+// checking instead. A class without an InprocServer32 key, such as one served
+// only by a local server, is reached through proxies that work from any
+// apartment, so it is reported as ThreadingModelBoth.
+func ThreadingModelOf(clsid ole.GUID) (ThreadingModel, error) {
+	return threadingModelOf(&clsid)
+}
+
+// RunFor runs fn on a thread of the apartment that the objects of the class
+// clsid live in, as reported by ThreadingModelOf, and returns its result.
+func (m *ApartmentManager) RunFor(clsid ole.GUID, fn func() error) error {
+	return m.RunForContext(context.Background(), clsid, fn)
+}
+
+// RunForContext is like RunFor, but stops waiting and returns ctx.Err() if
+// ctx is done before fn has finished, like RunContext.
+func (m *ApartmentManager) RunForContext(ctx context.Context, clsid ole.GUID, fn func() error) error {
+	model, err := ThreadingModelOf(clsid)
+	if err != nil {
+		return err
+	}
+	return m.RunContext(ctx, model.Apartment(), fn)
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package comshim
+
+import "github.com/go-ole/go-ole"
+
+// threadingModelOf reads the threading model of the class clsid from the
+// registry.
+func threadingModelOf(clsid *ole.GUID) (ThreadingModel, error) {
+	return 0, ole.NewError(ole.E_NOTIMPL)
+}
@@ -0,0 +1,52 @@
+//go:build windows
+
+package comshim
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-ole/go-ole"
+	winreg "golang.org/x/sys/windows/registry"
+)
+
+// threadingModelOf reads the threading model of the class clsid from the
+// registry.
+func threadingModelOf(clsid *ole.GUID) (ThreadingModel, error) {
+	path := `CLSID\` + clsid.String()
+	k, err := winreg.OpenKey(winreg.CLASSES_ROOT, path+`\InprocServer32`, winreg.QUERY_VALUE)
+	if errors.Is(err, winreg.ErrNotExist) {
+		// Not an in-process server, but the class has to exist at all
+		k, err = winreg.OpenKey(winreg.CLASSES_ROOT, path, winreg.QUERY_VALUE)
+		if err != nil {
+			return 0, fmt.Errorf("class %v is not registered: %w", clsid, err)
+		}
+		k.Close()
+		return ThreadingModelBoth, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer k.Close()
+
+	value, _, err := k.GetStringValue("ThreadingModel")
+	if errors.Is(err, winreg.ErrNotExist) {
+		return ThreadingModelSingle, nil
+	} else if err != nil {
+		return 0, err
+	}
+	switch {
+	case strings.EqualFold(value, "Apartment"):
+		return ThreadingModelApartment, nil
+	case strings.EqualFold(value, "Free"):
+		return ThreadingModelFree, nil
+	case strings.EqualFold(value, "Both"):
+		return ThreadingModelBoth, nil
+	case strings.EqualFold(value, "Neutral"):
+		return ThreadingModelNeutral, nil
+	case strings.EqualFold(value, "Single"):
+		return ThreadingModelSingle, nil
+	default:
+		return 0, fmt.Errorf("class %v has unknown threading model %q", clsid, value)
+	}
+}
@@ -0,0 +1,84 @@
+package comshim
+
+import (
+	"context"
+	"runtime"
+	"time"
+)
+
+// RunTimeout is like Run, but gives up on fn if it hasn't returned after d,
+// and returns context.DeadlineExceeded. If fn had already started by then, the
+// thread is assumed to be stuck in a COM call that will never return, as
+// happens with hung WMI providers. The shim abandons that thread and starts a
+// replacement for the functions submitted after it, rather than leaving them
+// all queued behind fn.
+//
+// The abandoned thread is left to finish fn on its own. If it ever does, it
+// uninitializes COM and exits; until then it is not waited for by WaitDone.
+// Objects created on it are lost along with it, so an STA shim should only be
+// given functions that leave nothing behind on the thread.
+//
+// On shims holding an MTA usage cookie, fn runs on the calling goroutine and
+// can't be given up on.
+func (s *Shim) RunTimeout(d time.Duration, fn func() error) error {
+	if err := s.holdTask(context.Background()); err != nil {
+		return err
+	}
+	defer s.done(nil)
+
+	t := &task{fn: fn, done: make(chan error, 1)}
+	if s.usingMTAUsage() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		return t.call()
+	}
+	if err := s.enqueue(t); err != nil {
+		return err
+	}
+
+	timer := s.settings().scheduler.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case err := <-t.done:
+		return err
+	case <-timer.C():
+		t.abandoned.Store(true)
+		s.poison(t)
+		return context.DeadlineExceeded
+	}
+}
+
+// poison gives up on the shim's thread if it is still running t, and starts a
+// replacement for the tasks queued behind it. The caller must hold a
+// reference.
+func (s *Shim) poison(t *task) {
+	if s.settings().shared != nil {
+		s.settings().shared.poison(t)
+		return
+	}
+	s.signalAccess.Lock()
+	if s.busy != t || s.current == nil {
+		s.signalAccess.Unlock()
+		return // t hasn't started, or has finished in the meantime
+	}
+	s.current.detached.Store(true)
+	s.current = nil
+	s.busy = nil
+	s.busySince.Store(0)
+	s.pump = nil
+	thread := s.threadID.Swap(0)
+	s.stopWatchdog()
+	s.setRunning(false)
+	s.signalAccess.Unlock()
+	s.end() // The abandoned goroutine no longer counts for WaitDone
+
+	s.log().Error("comshim: abandoning thread stuck in a task", "thread", thread)
+	if err := s.hold(0); err != nil {
+		// Nobody is going to run the tasks that are still queued
+		s.signalAccess.Lock()
+		if !s.running.Load() {
+			s.abandonTasks()
+		}
+		s.signalAccess.Unlock()
+	}
+}
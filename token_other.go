@@ -0,0 +1,13 @@
+//go:build !windows
+
+package comshim
+
+// impersonate makes the calling thread impersonate the user of token.
+func impersonate(token uintptr) error {
+	return nil
+}
+
+// revertToSelf ends the impersonation of the calling thread.
+func revertToSelf() error {
+	return nil
+}
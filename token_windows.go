@@ -0,0 +1,38 @@
+//go:build windows
+
+package comshim
+
+import "golang.org/x/sys/windows"
+
+var procImpersonateLoggedOnUser = modadvapi32.NewProc("ImpersonateLoggedOnUser")
+
+// WithToken makes the shim's thread impersonate the user of token, with
+// ImpersonateLoggedOnUser, before it initializes COM, and revert to the
+// identity of the process once COM has been uninitialized. Every function run
+// on the thread, and every remote activation made from it, then acts under
+// that identity, which allows WMI and DCOM to be used with other credentials
+// than the ones of the service account.
+//
+// The token must stay open for as long as the shim can start its thread. If
+// impersonating fails the start fails with an *InitError. If reverting fails
+// the thread is terminated rather than handed back to the Go runtime with the
+// impersonation in place. Shims holding an MTA usage cookie have no thread of
+// their own, so the option has no effect on them.
+func WithToken(token windows.Token) Option {
+	return func(c *config) {
+		c.token = uintptr(token)
+	}
+}
+
+// impersonate makes the calling thread impersonate the user of token.
+func impersonate(token uintptr) error {
+	if r, _, err := procImpersonateLoggedOnUser.Call(token); r == 0 {
+		return err
+	}
+	return nil
+}
+
+// revertToSelf ends the impersonation of the calling thread.
+func revertToSelf() error {
+	return windows.RevertToSelf()
+}
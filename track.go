@@ -0,0 +1,73 @@
+package comshim
+
+import "time"
+
+// Releaser is implemented by COM objects that can be released, such as
+// *ole.IUnknown and *ole.IDispatch.
+type Releaser interface {
+	Release() int32
+}
+
+// tracked is an object registered with Track.
+type tracked struct {
+	obj   Releaser
+	since time.Time
+}
+
+// Track registers obj to be released on the shim's thread, after the counter
+// has dropped to zero but before COM is uninitialized. Objects still tracked
+// at that point are released in the reverse order of their registration, so
+// that go-ole objects don't end up being released by finalizers after
+// CoUninitialize has already run.
+//
+// Track returns a function that releases obj early and stops tracking it. It
+// releases obj on the calling goroutine; use it from Run for objects with
+// thread affinity. Calling it more than once has no further effect, and obj
+// must not be released in any other way.
+func (s *Shim) Track(obj Releaser) (release func()) {
+	if s.settings().shared != nil {
+		return s.settings().shared.Track(obj)
+	}
+	t := &tracked{obj: obj, since: s.now()}
+	s.signalAccess.Lock()
+	s.tracked = append(s.tracked, t)
+	s.signalAccess.Unlock()
+
+	return func() {
+		if s.untrack(t) {
+			t.obj.Release()
+		}
+	}
+}
+
+// untrack stops tracking t, reporting whether it was still being tracked.
+func (s *Shim) untrack(t *tracked) bool {
+	s.signalAccess.Lock()
+	defer s.signalAccess.Unlock()
+	for i, other := range s.tracked {
+		if other == t {
+			s.tracked = append(s.tracked[:i], s.tracked[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// releaseTracked releases every tracked object, most recently registered
+// first. It must be called on the shim's thread while holding signalAccess,
+// before COM is uninitialized. A panicking Release is logged rather than
+// allowed to prevent the others from being released.
+func (s *Shim) releaseTracked() {
+	objs := s.tracked
+	s.tracked = nil
+	for i := len(objs) - 1; i >= 0; i-- {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					s.log().Error("comshim: releasing a tracked object panicked", "panic", r)
+				}
+			}()
+			objs[i].obj.Release()
+		}()
+	}
+}
@@ -0,0 +1,85 @@
+package comshim_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/NozomiNetworks/go-comshim"
+)
+
+// releaseRecorder records the order in which its objects are released.
+type releaseRecorder struct {
+	m     sync.Mutex
+	order []int
+}
+
+// object returns a releasable object that records id when released.
+func (r *releaseRecorder) object(id int) comshim.Releaser {
+	return &recordedObject{r: r, id: id}
+}
+
+func (r *releaseRecorder) Order() []int {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return append([]int(nil), r.order...)
+}
+
+type recordedObject struct {
+	r  *releaseRecorder
+	id int
+}
+
+func (o *recordedObject) Release() int32 {
+	o.r.m.Lock()
+	defer o.r.m.Unlock()
+	o.r.order = append(o.r.order, o.id)
+	return 0
+}
+
+func TestTrackReleasesInReverseOrder(t *testing.T) {
+	s := comshim.New()
+	var r releaseRecorder
+
+	s.Add(1)
+	s.Track(r.object(1))
+	release := s.Track(r.object(2))
+	s.Track(r.object(3))
+
+	release()
+	release()
+	if order := r.Order(); len(order) != 1 || order[0] != 2 {
+		t.Errorf("early release released %v, want [2]", order)
+	}
+
+	s.Done()
+	s.WaitDone()
+	order := r.Order()
+	if len(order) != 3 || order[1] != 3 || order[2] != 1 {
+		t.Errorf("objects were released in the order %v, want [2 3 1]", order)
+	}
+}
+
+func TestWithUnreleasedObjectHandler(t *testing.T) {
+	var r releaseRecorder
+	var unreleased []comshim.TrackedObject
+	s := comshim.New(comshim.WithUnreleasedObjectHandler(func(objs []comshim.TrackedObject) {
+		if order := r.Order(); len(order) != 1 {
+			t.Errorf("handler was called after objects %v had been released, want only [1]", order)
+		}
+		unreleased = objs
+	}))
+
+	s.Add(1)
+	s.Track(r.object(1))()
+	leaked := r.object(2)
+	s.Track(leaked)
+	s.Done()
+	s.WaitDone()
+
+	if len(unreleased) != 1 || unreleased[0].Object != leaked || unreleased[0].Since.IsZero() {
+		t.Errorf("handler was called with %+v, want the leaked object", unreleased)
+	}
+	if order := r.Order(); len(order) != 2 {
+		t.Errorf("objects %v were released, want both", order)
+	}
+}
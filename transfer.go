@@ -0,0 +1,36 @@
+package comshim
+
+import "context"
+
+// Transfer moves n references from the shim to the shim to, as if the holders
+// had called to.Add(n) and then Done n times on this shim, such as when a
+// subsystem moves from the global shim to one of its own during a
+// reconfiguration. The references are added to to first, starting it if
+// necessary, so there is no moment at which neither shim holds them and COM
+// could be unloaded.
+//
+// If to can't be started, Transfer returns the error and nothing is moved. If
+// the shim holds fewer than n references, ErrNegativeCounter is returned and
+// nothing is moved either. Transferring n <= 0 references, or transferring to
+// the shim itself, does nothing.
+func (s *Shim) Transfer(to *Shim, n int) error {
+	if n <= 0 || to == s {
+		return nil
+	}
+	if err := s.unowned(); err != nil {
+		return err
+	}
+	if err := to.unowned(); err != nil {
+		return err
+	}
+	if err := to.tryAddContext(context.Background(), n, nil, false); err != nil {
+		return err
+	}
+	if _, err := s.add(-n); err != nil {
+		to.add(-n) // Taking back our own delta can't underflow
+		to.track(-n, nil)
+		return err
+	}
+	s.track(-n, nil)
+	return nil
+}
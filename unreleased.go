@@ -0,0 +1,52 @@
+package comshim
+
+import "time"
+
+// TrackedObject describes a reference that was still outstanding when the
+// shim's thread was about to be uninitialized.
+type TrackedObject struct {
+	Object Releaser  // The object passed to Track, or nil for a GIT registration
+	Cookie Cookie    // The cookie of an interface registered with RegisterInterface, or 0
+	Since  time.Time // When the object was tracked or the interface registered
+}
+
+// WithUnreleasedObjectHandler sets a function that is called on the shim's
+// thread, or in the case of an MTA usage cookie on the goroutine releasing it,
+// when it is about to be uninitialized while objects passed to Track,
+// including those returned by CreateObject and similar helpers, or interfaces
+// registered with RegisterInterface, have not been released. Uninitializing
+// COM under live references is a common cause of crashes, so the handler
+// gets a chance to log them, or to fail loudly in tests.
+//
+// Before the check the thread calls CoFreeUnusedLibraries, and afterwards it
+// carries on as usual: tracked objects are released, while the registrations
+// are left to COM. The handler must not call Run or any other method of the
+// shim that waits for its thread.
+func WithUnreleasedObjectHandler(fn func([]TrackedObject)) Option {
+	return func(c *config) {
+		c.unreleasedHandler = fn
+	}
+}
+
+// reportUnreleased passes the references still outstanding to the handler set
+// by WithUnreleasedObjectHandler, if there is one and there are any. It must
+// be called on the shim's thread while holding signalAccess, before
+// releaseTracked.
+func (s *Shim) reportUnreleased() {
+	if s.settings().unreleasedHandler == nil {
+		return
+	}
+	coFreeUnusedLibraries()
+	if len(s.tracked) == 0 && len(s.gitCookies) == 0 {
+		return
+	}
+	objs := make([]TrackedObject, 0, len(s.tracked)+len(s.gitCookies))
+	for _, t := range s.tracked {
+		objs = append(objs, TrackedObject{Object: t.obj, Since: t.since})
+	}
+	for cookie, since := range s.gitCookies {
+		objs = append(objs, TrackedObject{Cookie: cookie, Since: since})
+	}
+	s.log().Warn("comshim: uninitializing with references outstanding", "count", len(objs))
+	s.settings().unreleasedHandler(objs)
+}
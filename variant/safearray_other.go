@@ -0,0 +1,32 @@
+//go:build !windows
+
+package variant
+
+import (
+	"time"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+)
+
+func safeArrayCreateVector(vt ole.VT, n int) (*ole.SafeArray, error) {
+	return nil, ole.NewError(ole.E_NOTIMPL)
+}
+
+func safeArrayDestroy(sa *ole.SafeArray) {}
+
+func safeArrayBounds(sa *ole.SafeArray) (lower, upper int32, err error) {
+	return 0, 0, ole.NewError(ole.E_NOTIMPL)
+}
+
+func safeArrayGetElement(sa *ole.SafeArray, i int32, dst unsafe.Pointer) error {
+	return ole.NewError(ole.E_NOTIMPL)
+}
+
+func safeArrayPutElement(sa *ole.SafeArray, i int32, src unsafe.Pointer) error {
+	return ole.NewError(ole.E_NOTIMPL)
+}
+
+func variantTime(t time.Time) (float64, error) {
+	return 0, ole.NewError(ole.E_NOTIMPL)
+}
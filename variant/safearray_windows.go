@@ -0,0 +1,92 @@
+//go:build windows
+
+package variant
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modoleaut32 = windows.NewLazySystemDLL("oleaut32.dll")
+
+	procSafeArrayCreateVector   = modoleaut32.NewProc("SafeArrayCreateVector")
+	procSafeArrayDestroy        = modoleaut32.NewProc("SafeArrayDestroy")
+	procSafeArrayGetDim         = modoleaut32.NewProc("SafeArrayGetDim")
+	procSafeArrayGetLBound      = modoleaut32.NewProc("SafeArrayGetLBound")
+	procSafeArrayGetUBound      = modoleaut32.NewProc("SafeArrayGetUBound")
+	procSafeArrayGetElement     = modoleaut32.NewProc("SafeArrayGetElement")
+	procSafeArrayPutElement     = modoleaut32.NewProc("SafeArrayPutElement")
+	procSystemTimeToVariantTime = modoleaut32.NewProc("SystemTimeToVariantTime")
+)
+
+// hresultError returns nil if hr is S_OK, and an *ole.OleError otherwise.
+func hresultError(hr uintptr) error {
+	if hr != 0 {
+		return ole.NewError(hr)
+	}
+	return nil
+}
+
+func safeArrayCreateVector(vt ole.VT, n int) (*ole.SafeArray, error) {
+	sa, _, _ := syscall.SyscallN(procSafeArrayCreateVector.Addr(), uintptr(vt), 0, uintptr(n))
+	if sa == 0 {
+		return nil, ole.NewError(ole.E_OUTOFMEMORY)
+	}
+	return *(**ole.SafeArray)(unsafe.Pointer(&sa)), nil
+}
+
+func safeArrayDestroy(sa *ole.SafeArray) {
+	syscall.SyscallN(procSafeArrayDestroy.Addr(), uintptr(unsafe.Pointer(sa)))
+}
+
+// safeArrayBounds returns the bounds of sa, which must be one-dimensional.
+func safeArrayBounds(sa *ole.SafeArray) (lower, upper int32, err error) {
+	dims, _, _ := syscall.SyscallN(procSafeArrayGetDim.Addr(), uintptr(unsafe.Pointer(sa)))
+	if dims != 1 {
+		return 0, 0, ErrUnsupported
+	}
+	hr, _, _ := syscall.SyscallN(procSafeArrayGetLBound.Addr(), uintptr(unsafe.Pointer(sa)), 1, uintptr(unsafe.Pointer(&lower)))
+	if err := hresultError(hr); err != nil {
+		return 0, 0, err
+	}
+	hr, _, _ = syscall.SyscallN(procSafeArrayGetUBound.Addr(), uintptr(unsafe.Pointer(sa)), 1, uintptr(unsafe.Pointer(&upper)))
+	if err := hresultError(hr); err != nil {
+		return 0, 0, err
+	}
+	return lower, upper, nil
+}
+
+func safeArrayGetElement(sa *ole.SafeArray, i int32, dst unsafe.Pointer) error {
+	hr, _, _ := syscall.SyscallN(procSafeArrayGetElement.Addr(), uintptr(unsafe.Pointer(sa)), uintptr(unsafe.Pointer(&i)), uintptr(dst))
+	return hresultError(hr)
+}
+
+func safeArrayPutElement(sa *ole.SafeArray, i int32, src unsafe.Pointer) error {
+	hr, _, _ := syscall.SyscallN(procSafeArrayPutElement.Addr(), uintptr(unsafe.Pointer(sa)), uintptr(unsafe.Pointer(&i)), uintptr(src))
+	return hresultError(hr)
+}
+
+// variantTime converts t to an OLE automation date, as held by VT_DATE.
+func variantTime(t time.Time) (float64, error) {
+	st := windows.Systemtime{
+		Year:         uint16(t.Year()),
+		Month:        uint16(t.Month()),
+		DayOfWeek:    uint16(t.Weekday()),
+		Day:          uint16(t.Day()),
+		Hour:         uint16(t.Hour()),
+		Minute:       uint16(t.Minute()),
+		Second:       uint16(t.Second()),
+		Milliseconds: uint16(t.Nanosecond() / int(time.Millisecond)),
+	}
+	var date float64
+	ok, _, _ := syscall.SyscallN(procSystemTimeToVariantTime.Addr(), uintptr(unsafe.Pointer(&st)), uintptr(unsafe.Pointer(&date)))
+	if ok == 0 {
+		return 0, ole.NewError(ole.E_INVALIDARG)
+	}
+	return date, nil
+}
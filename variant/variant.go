@@ -0,0 +1,388 @@
+// Package variant converts VARIANTs holding arrays and dictionaries to Go
+// slices and maps, and back. Conversions that touch interface pointers, such
+// as the elements of an array of objects or the entries of a
+// Scripting.Dictionary, run on the thread of a comshim.Shim, so that the
+// pointers are only used from the apartment they belong to; plain data is
+// converted on the calling goroutine.
+package variant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+	"unsafe"
+
+	"github.com/NozomiNetworks/go-comshim"
+	"github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+)
+
+var (
+	// ErrUnsupported is returned, wrapped with the type that was found, for
+	// VARIANT types and Go types that can't be converted.
+	ErrUnsupported = errors.New("variant: type is not supported")
+
+	// ErrNotArray is returned by Slice when the VARIANT doesn't hold an array.
+	ErrNotArray = errors.New("variant: value is not an array")
+
+	// ErrNotObject is returned by Map when the VARIANT doesn't hold an object.
+	ErrNotObject = errors.New("variant: value is not an object")
+)
+
+// Value converts v to a Go value that remains valid after v is cleared:
+//
+//   - VT_EMPTY and VT_NULL become nil, VT_BOOL a bool, VT_BSTR a string,
+//     VT_DATE a time.Time, and numbers the Go type of the same size.
+//   - One-dimensional arrays become []interface{}, with their elements
+//     converted in the same way.
+//   - VT_DISPATCH and VT_UNKNOWN become *ole.IDispatch and *ole.IUnknown.
+//     The caller receives a reference of its own, which it must release on
+//     the thread of s.
+//
+// If v holds interface pointers, or an array that may hold them, the
+// conversion runs on the thread of s, and waits for it at most until ctx is
+// done. Otherwise s isn't used.
+func Value(ctx context.Context, s *comshim.Shim, v *ole.VARIANT) (interface{}, error) {
+	if !holdsInterfaces(v.VT) {
+		return value(v)
+	}
+	var x interface{}
+	err := s.RunContext(ctx, func() (err error) {
+		x, err = value(v)
+		return err
+	})
+	return x, err
+}
+
+// Slice is like Value, but v must hold an array.
+func Slice(ctx context.Context, s *comshim.Shim, v *ole.VARIANT) ([]interface{}, error) {
+	if v.VT&ole.VT_ARRAY == 0 {
+		return nil, ErrNotArray
+	}
+	x, err := Value(ctx, s, v)
+	if err != nil {
+		return nil, err
+	}
+	return x.([]interface{}), nil
+}
+
+// Map converts v, which must hold a dictionary such as a Scripting.Dictionary,
+// to a map of its entries, on the thread of s. The keys must be strings, and
+// the items are converted like Value.
+func Map(ctx context.Context, s *comshim.Shim, v *ole.VARIANT) (map[string]interface{}, error) {
+	if v.VT != ole.VT_DISPATCH || v.Val == 0 {
+		return nil, ErrNotObject
+	}
+	var m map[string]interface{}
+	err := s.RunContext(ctx, func() (err error) {
+		m, err = dictionary(v.ToIDispatch())
+		return err
+	})
+	return m, err
+}
+
+// FromSlice converts values to a VARIANT holding a one-dimensional array of
+// VT_VARIANT, which the caller must clear with VariantClear. The elements may
+// be nil, bools, numbers, strings, time.Time values, *ole.IDispatch and
+// *ole.IUnknown, which gain a reference held by the array, and further
+// []interface{} values, which become nested arrays. If values holds interface
+// pointers, the array is built on the thread of s, and the caller must clear
+// it there too.
+func FromSlice(ctx context.Context, s *comshim.Shim, values []interface{}) (*ole.VARIANT, error) {
+	if !sliceHoldsInterfaces(values) {
+		return fromSlice(values)
+	}
+	var v *ole.VARIANT
+	err := s.RunContext(ctx, func() (err error) {
+		v, err = fromSlice(values)
+		return err
+	})
+	return v, err
+}
+
+// FromMap creates a Scripting.Dictionary on the thread of s holding the
+// entries of m, with the values converted like the elements of FromSlice, and
+// returns it. The caller must release it on the thread of s.
+func FromMap(ctx context.Context, s *comshim.Shim, m map[string]interface{}) (*ole.IDispatch, error) {
+	var d *ole.IDispatch
+	err := s.RunContext(ctx, func() (err error) {
+		d, err = fromMap(m)
+		return err
+	})
+	return d, err
+}
+
+// holdsInterfaces reports whether a VARIANT of type vt holds, or may hold,
+// interface pointers.
+func holdsInterfaces(vt ole.VT) bool {
+	switch vt &^ ole.VT_ARRAY {
+	case ole.VT_DISPATCH, ole.VT_UNKNOWN:
+		return true
+	case ole.VT_VARIANT:
+		return vt&ole.VT_ARRAY != 0
+	default:
+		return false
+	}
+}
+
+// sliceHoldsInterfaces reports whether values, or a slice nested in it, holds
+// interface pointers.
+func sliceHoldsInterfaces(values []interface{}) bool {
+	for _, x := range values {
+		switch x := x.(type) {
+		case *ole.IDispatch, *ole.IUnknown:
+			return true
+		case []interface{}:
+			if sliceHoldsInterfaces(x) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// value implements Value on the current thread.
+func value(v *ole.VARIANT) (interface{}, error) {
+	if v.VT&ole.VT_ARRAY != 0 {
+		return slice(v)
+	}
+	switch v.VT {
+	case ole.VT_EMPTY, ole.VT_NULL:
+		return nil, nil
+	case ole.VT_BOOL:
+		return v.Val&0xFFFF != 0, nil
+	case ole.VT_BSTR:
+		return v.ToString(), nil
+	case ole.VT_DATE:
+		return ole.GetVariantDate(uint64(v.Val))
+	case ole.VT_DISPATCH:
+		d := v.ToIDispatch()
+		if d != nil {
+			d.AddRef()
+		}
+		return d, nil
+	case ole.VT_UNKNOWN:
+		u := v.ToIUnknown()
+		if u != nil {
+			u.AddRef()
+		}
+		return u, nil
+	case ole.VT_I1, ole.VT_UI1, ole.VT_I2, ole.VT_UI2, ole.VT_I4, ole.VT_UI4, ole.VT_I8, ole.VT_UI8,
+		ole.VT_INT, ole.VT_UINT, ole.VT_R4, ole.VT_R8:
+		return v.Value(), nil
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, v.VT)
+	}
+}
+
+// slice converts v, which holds an array, to a slice.
+func slice(v *ole.VARIANT) ([]interface{}, error) {
+	vt := v.VT &^ ole.VT_ARRAY
+	if v.VT&ole.VT_BYREF != 0 || !elementType(vt) {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, v.VT)
+	}
+	sa := *(**ole.SafeArray)(unsafe.Pointer(&v.Val))
+	if sa == nil {
+		return nil, nil
+	}
+	lower, upper, err := safeArrayBounds(sa)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, 0, upper-lower+1)
+	for i := lower; i <= upper; i++ {
+		// The element is copied into a VARIANT of its own type, which owns
+		// the copy until it is cleared
+		var el ole.VARIANT
+		dst := unsafe.Pointer(&el)
+		if vt != ole.VT_VARIANT {
+			el.VT = vt
+			dst = unsafe.Pointer(&el.Val)
+		}
+		if err := safeArrayGetElement(sa, i, dst); err != nil {
+			return nil, err
+		}
+		x, err := value(&el)
+		ole.VariantClear(&el)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, x)
+	}
+	return values, nil
+}
+
+// elementType reports whether slice can convert the elements of arrays of
+// type vt, which must fit in the Val field of a VARIANT.
+func elementType(vt ole.VT) bool {
+	switch vt {
+	case ole.VT_I1, ole.VT_UI1, ole.VT_I2, ole.VT_UI2, ole.VT_I4, ole.VT_UI4, ole.VT_I8, ole.VT_UI8,
+		ole.VT_INT, ole.VT_UINT, ole.VT_R4, ole.VT_R8, ole.VT_BOOL, ole.VT_BSTR, ole.VT_DATE,
+		ole.VT_DISPATCH, ole.VT_UNKNOWN, ole.VT_VARIANT:
+		return true
+	default:
+		return false
+	}
+}
+
+// dictionary converts the entries of d, a dictionary, to a map.
+func dictionary(d *ole.IDispatch) (map[string]interface{}, error) {
+	keys, err := oleutil.CallMethod(d, "Keys")
+	if err != nil {
+		return nil, err
+	}
+	defer keys.Clear()
+	names, err := slice(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		key, ok := name.(string)
+		if !ok {
+			release(name)
+			return nil, fmt.Errorf("%w: key of type %T", ErrUnsupported, name)
+		}
+		item, err := oleutil.GetProperty(d, "Item", key)
+		if err != nil {
+			return nil, err
+		}
+		x, err := value(item)
+		item.Clear()
+		if err != nil {
+			return nil, err
+		}
+		m[key] = x
+	}
+	return m, nil
+}
+
+// release releases x if it is an interface pointer.
+func release(x interface{}) {
+	switch x := x.(type) {
+	case *ole.IDispatch:
+		x.Release()
+	case *ole.IUnknown:
+		x.Release()
+	}
+}
+
+// fromSlice implements FromSlice on the current thread.
+func fromSlice(values []interface{}) (*ole.VARIANT, error) {
+	sa, err := safeArrayCreateVector(ole.VT_VARIANT, len(values))
+	if err != nil {
+		return nil, err
+	}
+	for i, x := range values {
+		el, err := variantOf(x)
+		if err == nil {
+			// The array takes a copy of the element
+			err = safeArrayPutElement(sa, int32(i), unsafe.Pointer(el))
+			ole.VariantClear(el)
+		}
+		if err != nil {
+			safeArrayDestroy(sa)
+			return nil, err
+		}
+	}
+	return &ole.VARIANT{VT: ole.VT_ARRAY | ole.VT_VARIANT, Val: int64(uintptr(unsafe.Pointer(sa)))}, nil
+}
+
+// fromMap implements FromMap on the current thread.
+func fromMap(m map[string]interface{}) (*ole.IDispatch, error) {
+	unknown, err := oleutil.CreateObject("Scripting.Dictionary")
+	if err != nil {
+		return nil, err
+	}
+	d, err := unknown.QueryInterface(ole.IID_IDispatch)
+	unknown.Release()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		item, err := variantOf(m[key])
+		if err != nil {
+			d.Release()
+			return nil, err
+		}
+		// The dictionary takes a copy of the item
+		_, err = oleutil.CallMethod(d, "Add", key, item)
+		ole.VariantClear(item)
+		if err != nil {
+			d.Release()
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// variantOf converts x to a VARIANT, which the caller must clear.
+func variantOf(x interface{}) (*ole.VARIANT, error) {
+	v := new(ole.VARIANT)
+	switch x := x.(type) {
+	case nil:
+		v.VT = ole.VT_EMPTY
+	case bool:
+		v.VT = ole.VT_BOOL
+		if x {
+			v.Val = 0xFFFF // VARIANT_TRUE
+		}
+	case int8:
+		*v = ole.NewVariant(ole.VT_I1, int64(x))
+	case uint8:
+		*v = ole.NewVariant(ole.VT_UI1, int64(x))
+	case int16:
+		*v = ole.NewVariant(ole.VT_I2, int64(x))
+	case uint16:
+		*v = ole.NewVariant(ole.VT_UI2, int64(x))
+	case int32:
+		*v = ole.NewVariant(ole.VT_I4, int64(x))
+	case uint32:
+		*v = ole.NewVariant(ole.VT_UI4, int64(x))
+	case int64:
+		*v = ole.NewVariant(ole.VT_I8, x)
+	case uint64:
+		*v = ole.NewVariant(ole.VT_UI8, int64(x))
+	case int:
+		*v = ole.NewVariant(ole.VT_I8, int64(x))
+	case uint:
+		*v = ole.NewVariant(ole.VT_UI8, int64(x))
+	case float32:
+		*v = ole.NewVariant(ole.VT_R4, int64(math.Float32bits(x)))
+	case float64:
+		*v = ole.NewVariant(ole.VT_R8, int64(math.Float64bits(x)))
+	case string:
+		*v = ole.NewVariant(ole.VT_BSTR, int64(uintptr(unsafe.Pointer(ole.SysAllocStringLen(x)))))
+	case time.Time:
+		date, err := variantTime(x)
+		if err != nil {
+			return nil, err
+		}
+		*v = ole.NewVariant(ole.VT_DATE, int64(math.Float64bits(date)))
+	case *ole.IDispatch:
+		if x != nil {
+			x.AddRef()
+		}
+		*v = ole.NewVariant(ole.VT_DISPATCH, int64(uintptr(unsafe.Pointer(x))))
+	case *ole.IUnknown:
+		if x != nil {
+			x.AddRef()
+		}
+		*v = ole.NewVariant(ole.VT_UNKNOWN, int64(uintptr(unsafe.Pointer(x))))
+	case []interface{}:
+		return fromSlice(x)
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupported, x)
+	}
+	return v, nil
+}
@@ -0,0 +1,52 @@
+package variant_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/NozomiNetworks/go-comshim/variant"
+	"github.com/go-ole/go-ole"
+)
+
+func TestValueWithoutInterfaces(t *testing.T) {
+	tests := []struct {
+		v    ole.VARIANT
+		want interface{}
+	}{
+		{ole.NewVariant(ole.VT_EMPTY, 0), nil},
+		{ole.NewVariant(ole.VT_NULL, 0), nil},
+		{ole.NewVariant(ole.VT_BOOL, 0xFFFF), true},
+		{ole.NewVariant(ole.VT_BOOL, 0), false},
+		{ole.NewVariant(ole.VT_I4, 42), int32(42)},
+		{ole.NewVariant(ole.VT_UI2, 7), uint16(7)},
+		{ole.NewVariant(ole.VT_R8, int64(math.Float64bits(1.5))), 1.5},
+	}
+	for _, test := range tests {
+		// Plain data doesn't need a shim
+		got, err := variant.Value(context.Background(), nil, &test.v)
+		if err != nil {
+			t.Errorf("Value of %v returned %v", test.v.VT, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Value of %v returned %#v, want %#v", test.v.VT, got, test.want)
+		}
+	}
+
+	v := ole.NewVariant(ole.VT_CY, 0)
+	if _, err := variant.Value(context.Background(), nil, &v); !errors.Is(err, variant.ErrUnsupported) {
+		t.Errorf("Value of VT_CY returned %v, want %v", err, variant.ErrUnsupported)
+	}
+}
+
+func TestWrongKind(t *testing.T) {
+	v := ole.NewVariant(ole.VT_I4, 1)
+	if _, err := variant.Slice(context.Background(), nil, &v); err != variant.ErrNotArray {
+		t.Errorf("Slice of VT_I4 returned %v, want %v", err, variant.ErrNotArray)
+	}
+	if _, err := variant.Map(context.Background(), nil, &v); err != variant.ErrNotObject {
+		t.Errorf("Map of VT_I4 returned %v, want %v", err, variant.ErrNotObject)
+	}
+}
@@ -0,0 +1,61 @@
+package variant_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/NozomiNetworks/go-comshim"
+	"github.com/NozomiNetworks/go-comshim/variant"
+	"github.com/go-ole/go-ole"
+)
+
+func TestSliceRoundTrip(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	s := comshim.New()
+
+	values := []interface{}{nil, true, int32(-3), uint8(200), 2.5, "text", []interface{}{"nested", int64(1) << 40}}
+	v, err := variant.FromSlice(ctx, s, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ole.VariantClear(v)
+
+	got, err := variant.Slice(ctx, s, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, values) {
+		t.Errorf("Slice returned %#v, want %#v", got, values)
+	}
+}
+
+func TestMapRoundTrip(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	s := comshim.NewSTA()
+	s.Add(1)
+	defer s.Done()
+
+	m := map[string]interface{}{"name": "value", "count": int32(3), "list": []interface{}{"a", "b"}}
+	d, err := variant.FromMap(ctx, s, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Run(func() error {
+		d.Release()
+		return nil
+	})
+
+	v := ole.NewVariant(ole.VT_DISPATCH, int64(uintptr(unsafe.Pointer(d))))
+	got, err := variant.Map(ctx, s, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("Map returned %#v, want %#v", got, m)
+	}
+}
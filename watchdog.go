@@ -0,0 +1,118 @@
+package comshim
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Ping sends a function that does nothing through the shim's thread and waits
+// for it to return, which shows that the thread is still responding. Unlike
+// Run, it doesn't hold a reference, so it never starts the thread; on a shim
+// that isn't running it returns ErrStopped. Shims holding an MTA usage cookie
+// have no thread, so Ping returns nil right away. The function jumps the
+// queue like one passed to RunPriority, so a long queue doesn't look like a
+// stall.
+//
+// If ctx is done first, for example because the thread is stuck in a COM call
+// that never returns, Ping returns ctx.Err().
+func (s *Shim) Ping(ctx context.Context) error {
+	if s.settings().shared != nil {
+		return s.settings().shared.Ping(ctx)
+	}
+	return s.pass(ctx, true)
+}
+
+// pass sends a function that does nothing through the shim's thread without
+// holding a reference, and waits for it to return. It returns nil right away
+// on shims holding an MTA usage cookie and ErrStopped on shims that aren't
+// running.
+func (s *Shim) pass(ctx context.Context, urgent bool) error {
+	t := &task{fn: func() error { return nil }, done: make(chan error, 1), urgent: urgent}
+	s.signalAccess.Lock()
+	if s.cookie != 0 {
+		s.signalAccess.Unlock()
+		return nil
+	}
+	if !s.running.Load() {
+		s.signalAccess.Unlock()
+		return ErrStopped
+	}
+	s.queue(t)
+	s.signal()
+	s.signalAccess.Unlock()
+
+	select {
+	case err := <-t.done:
+		return err
+	case <-ctx.Done():
+		t.abandoned.Store(true)
+		return ctx.Err()
+	}
+}
+
+// WithWatchdog makes the shim check that its thread is still responding by
+// calling Ping every interval while the thread is running. If a ping doesn't
+// come back within interval, the stall is logged and onStall is called with
+// the time since the thread last responded. It is called after every failed
+// ping, on a goroutine of the watchdog's own, for as long as the thread is
+// stuck.
+func WithWatchdog(interval time.Duration, onStall func(stalled time.Duration)) Option {
+	return func(c *config) {
+		c.watchdogInterval = interval
+		c.onStall = onStall
+	}
+}
+
+// startWatchdog starts the watchdog for a thread that has just started
+// running, if the shim has one. It must be called while holding signalAccess.
+func (s *Shim) startWatchdog() {
+	if s.settings().watchdogInterval <= 0 {
+		return
+	}
+	s.watchdog = make(chan struct{})
+	s.begin() // So that WaitDone waits for the watchdog too
+	go s.watch(s.watchdog)
+}
+
+// stopWatchdog stops the watchdog of a thread that is going away. It must be
+// called while holding signalAccess.
+func (s *Shim) stopWatchdog() {
+	if s.watchdog != nil {
+		close(s.watchdog)
+		s.watchdog = nil
+	}
+}
+
+// watch pings the shim's thread until stop is closed.
+func (s *Shim) watch(stop <-chan struct{}) {
+	defer s.end()
+	timer := s.settings().scheduler.NewTimer(s.settings().watchdogInterval)
+	defer timer.Stop()
+
+	responded := s.now()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C():
+		}
+		timer.Reset(s.settings().watchdogInterval)
+
+		ctx, cancel := s.withTimeout(context.Background(), s.settings().watchdogInterval)
+		err := timeoutErr(ctx, s.Ping(ctx))
+		cancel()
+		switch {
+		case err == nil:
+			responded = s.now()
+		case errors.Is(err, context.DeadlineExceeded):
+			stalled := s.now().Sub(responded)
+			s.log().Warn("comshim: thread is not responding", "stalled", stalled)
+			if s.settings().onStall != nil {
+				s.settings().onStall(stalled)
+			}
+		default:
+			return // The thread is going away
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package comshim
+
+import "github.com/go-ole/go-ole"
+
+// NewWinRT returns a new shim whose thread is initialized for the Windows
+// Runtime with RoInitialize instead of CoInitializeEx, so that code using
+// Windows Runtime APIs gets the same counter-based lifetime management as
+// classic COM. The thread joins the multithreaded apartment unless the options
+// include WithCoInitFlags(ole.COINIT_APARTMENTTHREADED).
+//
+// The Windows Runtime is available on Windows 8 and later. On older systems
+// starting the shim fails.
+func NewWinRT(opts ...Option) *Shim {
+	return New(append([]Option{withWinRT()}, opts...)...)
+}
+
+func withWinRT() Option {
+	return func(c *config) {
+		c.winRT = true
+	}
+}
+
+// RO_INIT_TYPE values accepted by RoInitialize.
+const (
+	roInitSingleThreaded = 0
+	roInitMultiThreaded  = 1
+)
+
+// initialize initializes the calling thread for COM, or for the Windows
+// Runtime if the shim was created by NewWinRT.
+func (s *Shim) initialize() error {
+	return s.initializeWith(s.settings().coinit)
+}
+
+// initializeWith is like initialize, but with coinit in place of the flags the
+// shim was configured with.
+func (s *Shim) initializeWith(coinit uint32) error {
+	if s.settings().initializer != nil {
+		return s.settings().initializer.Initialize(coinit)
+	}
+	if !s.settings().winRT {
+		return coInitializeEx(coinit)
+	}
+	if coinit&ole.COINIT_APARTMENTTHREADED != 0 {
+		return roInitialize(roInitSingleThreaded)
+	}
+	return roInitialize(roInitMultiThreaded)
+}
+
+// initOp returns the name of the function called by initialize, for errors.
+func (s *Shim) initOp() string {
+	if s.settings().winRT {
+		return "RoInitialize"
+	}
+	return "CoInitializeEx"
+}
+
+// uninitOp returns the name of the function called by uninitialize, for
+// traces.
+func (s *Shim) uninitOp() string {
+	if s.settings().winRT {
+		return "RoUninitialize"
+	}
+	return "CoUninitialize"
+}
+
+// uninitialize undoes a successful call to initialize on the calling thread.
+func (s *Shim) uninitialize() {
+	if s.settings().initializer != nil {
+		s.settings().initializer.Uninitialize()
+		return
+	}
+	if s.settings().winRT {
+		roUninitialize()
+		return
+	}
+	coUninitialize()
+}
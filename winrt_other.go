@@ -0,0 +1,16 @@
+//go:build !windows
+
+package comshim
+
+// roInitialize initializes the Windows Runtime on the calling thread.
+func roInitialize(initType uint32) error {
+	return nil
+}
+
+// roUninitialize uninitializes the Windows Runtime on the calling thread.
+func roUninitialize() {}
+
+// winRTSupported reports whether the operating system provides RoInitialize.
+func winRTSupported() bool {
+	return false
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package comshim
+
+import "golang.org/x/sys/windows"
+
+var (
+	modcombase = windows.NewLazySystemDLL("combase.dll")
+
+	procRoInitialize   = modcombase.NewProc("RoInitialize")
+	procRoUninitialize = modcombase.NewProc("RoUninitialize")
+)
+
+// winRTSupported reports whether the operating system provides RoInitialize.
+func winRTSupported() bool {
+	return procRoInitialize.Find() == nil
+}
+
+// roInitialize initializes the Windows Runtime on the calling thread.
+func roInitialize(initType uint32) error {
+	if err := procRoInitialize.Find(); err != nil {
+		return err
+	}
+	hr, _, _ := procRoInitialize.Call(uintptr(initType))
+	return hresultError(hr)
+}
+
+// roUninitialize uninitializes the Windows Runtime on the calling thread.
+func roUninitialize() {
+	procRoUninitialize.Call()
+}
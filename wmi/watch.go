@@ -0,0 +1,129 @@
+package wmi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/NozomiNetworks/go-comshim"
+	"github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+)
+
+// wbemErrTimedOut is the SCODE with which NextEvent reports that no event
+// arrived in time.
+const wbemErrTimedOut = 0x80043001
+
+// pollTimeout is how many milliseconds each call to NextEvent waits for an
+// event. It bounds how long the shim's thread is kept from other work, and how
+// long it takes Watch to notice that its context is done.
+const pollTimeout = 250
+
+// Event is an event delivered by Watch.
+type Event struct {
+	// Properties holds the properties of the event object by name, as Query
+	// returns them, except that embedded objects such as TargetInstance and
+	// PreviousInstance are returned as maps of their own properties.
+	Properties map[string]interface{}
+
+	// Err is set on the last event if watching failed, right before the
+	// channel is closed.
+	Err error
+}
+
+// Watch runs the WQL event query wql against namespace, such as
+//
+//	SELECT * FROM __InstanceCreationEvent WITHIN 5 WHERE TargetInstance ISA 'Win32_Process'
+//
+// with ExecNotificationQuery, and delivers the events it produces to the
+// returned channel until ctx is done. The channel is then closed. The query is
+// set up and the events are fetched on the same thread as Query's, which is
+// kept until the watch ends; other queries still get their turn between two
+// events.
+//
+// Events are delivered in order and the next one isn't fetched until the
+// previous one has been received, so a slow receiver makes WMI buffer events,
+// up to the limit of the provider.
+func Watch(ctx context.Context, namespace, wql string) (<-chan Event, error) {
+	return WatchOn(ctx, shim, namespace, wql)
+}
+
+// WatchOn is like Watch, but runs the event query on the thread of s. The
+// reference kept for the watch is held by the client of s called "wmi".
+func WatchOn(ctx context.Context, s *comshim.Shim, namespace, wql string) (<-chan Event, error) {
+	c := s.NewClient("wmi")
+	if err := c.TryAddContext(ctx, 1); err != nil {
+		return nil, err
+	}
+	var source *ole.IDispatch
+	err := s.RunContext(ctx, func() (err error) {
+		source, err = subscribe(namespace, wql)
+		return err
+	})
+	if err != nil {
+		c.Done()
+		return nil, err
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer c.Done()
+		defer close(events)
+		defer s.Run(func() error {
+			source.Release()
+			return nil
+		})
+		for ctx.Err() == nil {
+			var props map[string]interface{}
+			err := s.Run(func() (err error) {
+				props, err = nextEvent(source)
+				return err
+			})
+			if err == nil && props == nil {
+				continue // Timed out, time to check ctx
+			}
+			select {
+			case events <- Event{Properties: props, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// subscribe runs the event query wql against namespace and returns the
+// resulting SWbemEventSource. It must be called on a COM-initialized thread.
+func subscribe(namespace, wql string) (*ole.IDispatch, error) {
+	service, err := connect(namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer service.Release()
+
+	sourceVar, err := oleutil.CallMethod(service, "ExecNotificationQuery", wql)
+	if err != nil {
+		return nil, err
+	}
+	return sourceVar.ToIDispatch(), nil // Released by clearing sourceVar
+}
+
+// nextEvent waits for the next event of source, and returns its properties,
+// or nil if none arrived in time. It must be called on the thread that
+// created source.
+func nextEvent(source *ole.IDispatch) (map[string]interface{}, error) {
+	eventVar, err := oleutil.CallMethod(source, "NextEvent", pollTimeout)
+	if err != nil {
+		var oerr *ole.OleError
+		if errors.As(err, &oerr) {
+			if info, ok := oerr.SubError().(ole.EXCEPINFO); ok && info.SCODE() == wbemErrTimedOut {
+				return nil, nil
+			}
+		}
+		return nil, err
+	}
+	defer eventVar.Clear()
+	return properties(eventVar.ToIDispatch(), true)
+}
@@ -0,0 +1,165 @@
+// Package wmi runs Windows Management Instrumentation queries on a
+// COM-initialized thread managed by a comshim.Shim, releasing every object it
+// creates along the way.
+package wmi
+
+import (
+	"context"
+
+	"github.com/NozomiNetworks/go-comshim"
+	"github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+)
+
+// wbemImpersonationLevelImpersonate lets the WMI service act on behalf of the
+// caller, which most providers require.
+const wbemImpersonationLevelImpersonate = 3
+
+// shim is used by Query. It is independent of the global shim of the comshim
+// package, so that WMI keeps its own thread.
+var shim = comshim.Named("github.com/NozomiNetworks/go-comshim/wmi")
+
+// Query runs the WQL query wql against namespace, such as `root\cimv2`, on the
+// local machine, and returns the properties of every object in the result.
+// The query runs on a thread of its own, which is released again once no
+// queries are running.
+//
+// Properties that hold arrays are returned as slices. Properties that hold
+// embedded objects are returned as nil.
+func Query(ctx context.Context, namespace, wql string) ([]map[string]interface{}, error) {
+	return QueryOn(ctx, shim, namespace, wql)
+}
+
+// QueryOn is like Query, but runs the query on the thread of s.
+func QueryOn(ctx context.Context, s *comshim.Shim, namespace, wql string) ([]map[string]interface{}, error) {
+	var rows []map[string]interface{}
+	err := s.RunContext(ctx, func() error {
+		var err error
+		rows, err = query(namespace, wql)
+		return err
+	})
+	return rows, err
+}
+
+// query runs wql against namespace. It must be called on a COM-initialized
+// thread.
+func query(namespace, wql string) ([]map[string]interface{}, error) {
+	service, err := connect(namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer service.Release()
+
+	resultVar, err := oleutil.CallMethod(service, "ExecQuery", wql)
+	if err != nil {
+		return nil, err
+	}
+	defer resultVar.Clear()
+
+	var rows []map[string]interface{}
+	err = oleutil.ForEach(resultVar.ToIDispatch(), func(item *ole.VARIANT) error {
+		defer item.Clear()
+		row, err := properties(item.ToIDispatch(), false)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, row)
+		return nil
+	})
+	return rows, err
+}
+
+// connect returns the SWbemServices of namespace on the local machine, set up
+// to impersonate the caller. It must be called on a COM-initialized thread.
+func connect(namespace string) (*ole.IDispatch, error) {
+	unknown, err := oleutil.CreateObject("WbemScripting.SWbemLocator")
+	if err != nil {
+		return nil, err
+	}
+	defer unknown.Release()
+
+	locator, err := unknown.QueryInterface(ole.IID_IDispatch)
+	if err != nil {
+		return nil, err
+	}
+	defer locator.Release()
+
+	serviceVar, err := oleutil.CallMethod(locator, "ConnectServer", nil, namespace)
+	if err != nil {
+		return nil, err
+	}
+	service := serviceVar.ToIDispatch() // Released by clearing serviceVar
+
+	// The scripting API sets the proxy blanket of the underlying
+	// IWbemServices from the security settings of SWbemServices
+	if err := setImpersonation(service); err != nil {
+		service.Release()
+		return nil, err
+	}
+	return service, nil
+}
+
+// setImpersonation makes service impersonate the caller.
+func setImpersonation(service *ole.IDispatch) error {
+	securityVar, err := oleutil.GetProperty(service, "Security_")
+	if err != nil {
+		return err
+	}
+	defer securityVar.Clear()
+
+	_, err = oleutil.PutProperty(securityVar.ToIDispatch(), "ImpersonationLevel", wbemImpersonationLevelImpersonate)
+	return err
+}
+
+// properties returns the properties of the SWbemObject obj by name. If
+// embedded is set, embedded objects are converted to maps of their own
+// properties.
+func properties(obj *ole.IDispatch, embedded bool) (map[string]interface{}, error) {
+	propsVar, err := oleutil.GetProperty(obj, "Properties_")
+	if err != nil {
+		return nil, err
+	}
+	defer propsVar.Clear()
+
+	row := make(map[string]interface{})
+	err = oleutil.ForEach(propsVar.ToIDispatch(), func(prop *ole.VARIANT) error {
+		defer prop.Clear()
+
+		nameVar, err := oleutil.GetProperty(prop.ToIDispatch(), "Name")
+		if err != nil {
+			return err
+		}
+		defer nameVar.Clear()
+
+		valueVar, err := oleutil.GetProperty(prop.ToIDispatch(), "Value")
+		if err != nil {
+			return err
+		}
+		defer valueVar.Clear()
+
+		v, err := value(valueVar, embedded)
+		if err != nil {
+			return err
+		}
+		row[nameVar.ToString()] = v
+		return nil
+	})
+	return row, err
+}
+
+// value converts v to a Go value that remains valid after v is cleared.
+// Arrays become slices. Embedded objects can't outlive the query, so they are
+// converted to maps of their properties if embedded is set, and reported as
+// nil otherwise.
+func value(v *ole.VARIANT, embedded bool) (interface{}, error) {
+	switch {
+	case v.VT&ole.VT_ARRAY != 0:
+		return v.ToArray().ToValueArray(), nil
+	case v.VT == ole.VT_DISPATCH && embedded && v.Val != 0:
+		return properties(v.ToIDispatch(), true)
+	case v.VT == ole.VT_DISPATCH, v.VT == ole.VT_UNKNOWN:
+		return nil, nil
+	default:
+		return v.Value(), nil
+	}
+}
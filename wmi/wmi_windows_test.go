@@ -0,0 +1,51 @@
+package wmi_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NozomiNetworks/go-comshim/wmi"
+)
+
+func TestQuery(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	rows, err := wmi.Query(ctx, `root\cimv2`, "SELECT Caption FROM Win32_OperatingSystem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("got %d operating systems, want 1", len(rows))
+	}
+	if caption, ok := rows[0]["Caption"].(string); !ok || caption == "" {
+		t.Errorf("operating system has no caption: %v", rows[0])
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// Win32_LocalTime changes every second
+	events, err := wmi.Watch(ctx, `root\cimv2`, "SELECT * FROM __InstanceModificationEvent WITHIN 1 WHERE TargetInstance ISA 'Win32_LocalTime'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, ok := <-events
+	if !ok {
+		t.Fatal("channel was closed before an event arrived")
+	}
+	if event.Err != nil {
+		t.Fatal(event.Err)
+	}
+	if target, ok := event.Properties["TargetInstance"].(map[string]interface{}); !ok || target["Second"] == nil {
+		t.Errorf("event has no target instance with a Second property: %v", event.Properties)
+	}
+
+	cancel()
+	for range events {
+		// Drain whatever was fetched before the cancellation was noticed
+	}
+}